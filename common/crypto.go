@@ -0,0 +1,219 @@
+package common
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Encrypted secrets are stored as "enc:v1:<key id>:<base64(nonce|ciphertext)>".
+// Values without the prefix are legacy plaintext, which ResealSecret encrypts.
+const secretPrefix = "enc:v1:"
+
+var ErrSecretKeyUnknown = errors.New("secret was encrypted with an unknown key")
+
+var ErrSecretNotEncrypted = errors.New("secret is not encrypted")
+
+type secretKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+var (
+	keyMu      sync.RWMutex
+	currentKey *secretKey
+	knownKeys  map[string]*secretKey
+)
+
+// InitSecrets loads the credential encryption keys. The current key is taken
+// from key or, if empty, from keyFile, which is generated on first start.
+// oldKeys are previous keys that can still decrypt but are no longer used to encrypt.
+func InitSecrets(key, keyFile string, oldKeys []string) error {
+	material := key
+	if material == "" {
+		var err error
+		material, err = loadOrCreateKeyFile(keyFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	current, err := newSecretKey(material)
+	if err != nil {
+		return err
+	}
+
+	known := map[string]*secretKey{current.id: current}
+	for _, old := range oldKeys {
+		old = strings.TrimSpace(old)
+		if old == "" {
+			continue
+		}
+		k, err := newSecretKey(old)
+		if err != nil {
+			return err
+		}
+		if _, exists := known[k.id]; !exists {
+			known[k.id] = k
+		}
+	}
+
+	keyMu.Lock()
+	currentKey = current
+	knownKeys = known
+	keyMu.Unlock()
+
+	Infof("Credential encryption initialized (key %s, %d previous keys)", current.id, len(known)-1)
+	return nil
+}
+
+func loadOrCreateKeyFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		material := strings.TrimSpace(string(data))
+		if material == "" {
+			return "", fmt.Errorf("secret key file %s is empty", path)
+		}
+		return material, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read secret key file: %w", err)
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate secret key: %w", err)
+	}
+	material := base64.StdEncoding.EncodeToString(raw)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", fmt.Errorf("failed to create secret key directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(material+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("failed to write secret key file: %w", err)
+	}
+
+	Infof("Generated new secret key at %s", path)
+	return material, nil
+}
+
+// newSecretKey derives an AES-256 key from arbitrary key material
+func newSecretKey(material string) (*secretKey, error) {
+	sum := sha256.Sum256([]byte(material))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// The key id is a hash of the derived key, not of the raw material
+	idSum := sha256.Sum256(sum[:])
+	return &secretKey{id: hex.EncodeToString(idSum[:4]), aead: aead}, nil
+}
+
+// IsEncryptedSecret reports whether value is in the encrypted format
+func IsEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, secretPrefix)
+}
+
+// EncryptSecret encrypts plaintext with the current key. Empty stays empty.
+func EncryptSecret(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	keyMu.RLock()
+	key := currentKey
+	keyMu.RUnlock()
+	if key == nil {
+		return "", fmt.Errorf("secret key not initialized")
+	}
+
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := key.aead.Seal(nonce, nonce, []byte(plaintext), []byte(key.id))
+	return secretPrefix + key.id + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret decrypts a value produced by EncryptSecret with the current
+// or a previous key. Stored plaintext is encrypted at startup, so any other
+// non-empty value is rejected rather than used as the secret.
+func DecryptSecret(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	if !IsEncryptedSecret(value) {
+		return "", ErrSecretNotEncrypted
+	}
+
+	keyID, payload, ok := strings.Cut(strings.TrimPrefix(value, secretPrefix), ":")
+	if !ok {
+		return "", fmt.Errorf("malformed encrypted secret")
+	}
+
+	keyMu.RLock()
+	key := knownKeys[keyID]
+	keyMu.RUnlock()
+	if key == nil {
+		return "", fmt.Errorf("%w: %s", ErrSecretKeyUnknown, keyID)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted secret: %w", err)
+	}
+	nonceSize := key.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", fmt.Errorf("malformed encrypted secret")
+	}
+
+	plaintext, err := key.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(keyID))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// NeedsReseal reports whether value is plaintext or encrypted with an old key
+func NeedsReseal(value string) bool {
+	if value == "" {
+		return false
+	}
+	if !IsEncryptedSecret(value) {
+		return true
+	}
+
+	keyMu.RLock()
+	defer keyMu.RUnlock()
+	return currentKey == nil || !strings.HasPrefix(value, secretPrefix+currentKey.id+":")
+}
+
+// ResealSecret returns value encrypted with the current key, re-encrypting
+// values sealed with an old key and encrypting legacy plaintext
+func ResealSecret(value string) (string, error) {
+	if !NeedsReseal(value) {
+		return value, nil
+	}
+	if !IsEncryptedSecret(value) {
+		return EncryptSecret(value)
+	}
+	plaintext, err := DecryptSecret(value)
+	if err != nil {
+		return "", err
+	}
+	return EncryptSecret(plaintext)
+}
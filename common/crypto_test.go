@@ -0,0 +1,176 @@
+package common_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/stoz/common"
+)
+
+func TestMain(m *testing.M) {
+	common.InitLogger("error")
+	os.Exit(m.Run())
+}
+
+func initSecrets(t *testing.T, key string, oldKeys ...string) {
+	t.Helper()
+	if err := common.InitSecrets(key, "", oldKeys); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSecretRoundTrip(t *testing.T) {
+	initSecrets(t, "current")
+
+	for _, plaintext := range []string{"secret", "pässwörd with spaces", strings.Repeat("x", 4096)} {
+		sealed, err := common.EncryptSecret(plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !common.IsEncryptedSecret(sealed) || strings.Contains(sealed, plaintext) {
+			t.Errorf("EncryptSecret(%q) = %q, want an encrypted value", plaintext, sealed)
+		}
+		if common.NeedsReseal(sealed) {
+			t.Errorf("value sealed with the current key needs a reseal")
+		}
+		got, err := common.DecryptSecret(sealed)
+		if err != nil || got != plaintext {
+			t.Errorf("DecryptSecret = %q, %v, want %q", got, err, plaintext)
+		}
+	}
+
+	// Every encryption uses a fresh nonce
+	a, _ := common.EncryptSecret("secret")
+	b, _ := common.EncryptSecret("secret")
+	if a == b {
+		t.Error("encrypting twice gave the same value")
+	}
+
+	// Empty stays empty
+	if sealed, err := common.EncryptSecret(""); err != nil || sealed != "" {
+		t.Errorf("EncryptSecret(\"\") = %q, %v", sealed, err)
+	}
+	if got, err := common.DecryptSecret(""); err != nil || got != "" {
+		t.Errorf("DecryptSecret(\"\") = %q, %v", got, err)
+	}
+}
+
+func TestDecryptSecretRejectsBadValues(t *testing.T) {
+	initSecrets(t, "current")
+	sealed, err := common.EncryptSecret("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID := strings.Split(sealed, ":")[2]
+	payload := strings.Split(sealed, ":")[3]
+	tampered := []byte(payload)
+	tampered[len(tampered)/2] ^= 1
+
+	for _, value := range []string{
+		"enc:v1:",
+		"enc:v1:" + keyID,
+		"enc:v1:" + keyID + ":not base64!",
+		"enc:v1:" + keyID + ":AAAA",
+		"enc:v1:" + keyID + ":" + string(tampered),
+		"enc:v1:00000000:" + payload, // Payload bound to its key id
+	} {
+		if got, err := common.DecryptSecret(value); err == nil {
+			t.Errorf("DecryptSecret(%q) = %q, want an error", value, got)
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	initSecrets(t, "old")
+	sealedOld, err := common.EncryptSecret("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The old key still decrypts, but values sealed with it are resealed
+	initSecrets(t, "new", "old")
+	if got, err := common.DecryptSecret(sealedOld); err != nil || got != "secret" {
+		t.Errorf("DecryptSecret with the old key = %q, %v", got, err)
+	}
+	if !common.NeedsReseal(sealedOld) {
+		t.Error("value sealed with the old key does not need a reseal")
+	}
+	resealed, err := common.ResealSecret(sealedOld)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resealed == sealedOld || common.NeedsReseal(resealed) {
+		t.Errorf("ResealSecret did not move %q to the new key", sealedOld)
+	}
+	if again, _ := common.ResealSecret(resealed); again != resealed {
+		t.Error("ResealSecret changed a value sealed with the current key")
+	}
+
+	// Once the old key is retired its values can no longer be read
+	initSecrets(t, "new")
+	if _, err := common.DecryptSecret(sealedOld); !errors.Is(err, common.ErrSecretKeyUnknown) {
+		t.Errorf("DecryptSecret after retiring the key: got %v, want ErrSecretKeyUnknown", err)
+	}
+	if got, err := common.DecryptSecret(resealed); err != nil || got != "secret" {
+		t.Errorf("DecryptSecret of the resealed value = %q, %v", got, err)
+	}
+}
+
+func TestLegacyPlaintext(t *testing.T) {
+	initSecrets(t, "current")
+
+	// Plaintext is never used as a secret, only encrypted by a reseal
+	if got, err := common.DecryptSecret("plainsecret"); !errors.Is(err, common.ErrSecretNotEncrypted) {
+		t.Errorf("DecryptSecret(plaintext) = %q, %v, want ErrSecretNotEncrypted", got, err)
+	}
+	if !common.NeedsReseal("plainsecret") {
+		t.Error("plaintext does not need a reseal")
+	}
+	sealed, err := common.ResealSecret("plainsecret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := common.DecryptSecret(sealed); err != nil || got != "plainsecret" {
+		t.Errorf("DecryptSecret of resealed plaintext = %q, %v", got, err)
+	}
+	if common.NeedsReseal("") {
+		t.Error("an empty secret needs a reseal")
+	}
+}
+
+func TestSecretKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "secret.key")
+	if err := common.InitSecrets("", path, nil); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := common.EncryptSecret("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("key file was not created: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("key file mode %v, want 0600", info.Mode().Perm())
+	}
+
+	// The generated key is loaded again on the next start
+	initSecrets(t, "other")
+	if err := common.InitSecrets("", path, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := common.DecryptSecret(sealed); err != nil || got != "secret" {
+		t.Errorf("DecryptSecret after reloading the key file = %q, %v", got, err)
+	}
+
+	if err := os.WriteFile(path, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := common.InitSecrets("", path, nil); err == nil {
+		t.Error("InitSecrets accepted an empty key file")
+	}
+}
@@ -1,17 +1,108 @@
 package common
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"regexp"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 )
 
 var Logger *logrus.Logger
 
+const redacted = "[REDACTED]"
+
+// Patterns for credentials that may end up in messages, errors or response
+// bodies: headers, bearer tokens, query/form values, JSON fields and URL userinfo
+var redactPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`(?i)(authorization"?\s*[:=]\s*"?)(bearer\s+)?[^\s"',;&]+`), "${1}${2}" + redacted},
+	{regexp.MustCompile(`(?i)\b(bearer\s+)[a-z0-9\-._~+/]+=*`), "${1}" + redacted},
+	{regexp.MustCompile(`(?i)("[a-z_]*(?:token|password|secret)"\s*:\s*)"(?:[^"\\]|\\.)*"`), `${1}"` + redacted + `"`},
+	{regexp.MustCompile(`(?i)\b([a-z_]*(?:token|password|passwd|secret))(\s*[:=]\s*)([^\s,;&"'}\]]+)`), "${1}${2}" + redacted},
+	{regexp.MustCompile(`(://[^/\s:@]+:)[^/\s@]+@`), "${1}" + redacted + "@"},
+}
+
+// sensitiveKeys are log field names whose values are never written
+var sensitiveKeys = []string{"password", "token", "secret", "authorization", "cookie"}
+
+// Redact scrubs credentials from s
+func Redact(s string) string {
+	for _, p := range redactPatterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	return s
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactingFormatter scrubs the message and every field before formatting
+type redactingFormatter struct {
+	logrus.Formatter
+}
+
+func (f *redactingFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	data := make(logrus.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		switch v := value.(type) {
+		case string:
+			value = Redact(v)
+		case error:
+			value = errors.New(Redact(v.Error()))
+		case fmt.Stringer:
+			value = Redact(v.String())
+		}
+		if isSensitiveKey(key) {
+			value = redacted
+		}
+		data[key] = value
+	}
+
+	scrubbed := *entry
+	scrubbed.Data = data
+	scrubbed.Message = Redact(entry.Message)
+	return f.Formatter.Format(&scrubbed)
+}
+
+// NewRedactingFormatter wraps formatter so no credential reaches the output
+func NewRedactingFormatter(formatter logrus.Formatter) logrus.Formatter {
+	return &redactingFormatter{Formatter: formatter}
+}
+
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (r *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := r.w.Write([]byte(Redact(string(p)))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// NewRedactingWriter scrubs credentials from output not written through
+// Logger, such as the HTTP access log
+func NewRedactingWriter(w io.Writer) io.Writer {
+	return &redactingWriter{w: w}
+}
+
 func InitLogger(level string) {
 	Logger = logrus.New()
 	Logger.SetOutput(os.Stdout)
-	Logger.SetFormatter(&logrus.JSONFormatter{})
+	Logger.SetFormatter(NewRedactingFormatter(&logrus.JSONFormatter{}))
 
 	logLevel, err := logrus.ParseLevel(level)
 	if err != nil {
@@ -0,0 +1,48 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SecurityLogger records rejected or suspicious requests. It writes to its own
+// file so the events survive log rotation of stdout and are easy to ship.
+var SecurityLogger *logrus.Logger
+
+// InitSecurityLog opens the security log at path. With an empty path events
+// only go to the main log.
+func InitSecurityLog(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create security log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open security log: %w", err)
+	}
+
+	SecurityLogger = logrus.New()
+	SecurityLogger.SetOutput(file)
+	SecurityLogger.SetFormatter(NewRedactingFormatter(&logrus.JSONFormatter{}))
+	return nil
+}
+
+// SecurityEvent records a security-relevant event in the security log and,
+// as a warning, in the main log
+func SecurityEvent(event string, fields map[string]interface{}) {
+	entryFields := logrus.Fields{"event": event}
+	for key, value := range fields {
+		entryFields[key] = value
+	}
+
+	if SecurityLogger != nil {
+		SecurityLogger.WithFields(entryFields).Warn(event)
+	}
+	Logger.WithFields(entryFields).Warn("Security event: " + event)
+}
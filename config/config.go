@@ -3,23 +3,31 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	Scan     ScanConfig
-	Worker   WorkerConfig
-	ZimaOS   ZimaOSConfig
+	Server    ServerConfig
+	Database  DatabaseConfig
+	Scan      ScanConfig
+	Worker    WorkerConfig
+	ZimaOS    ZimaOSConfig
+	Bandwidth BandwidthConfig
+	Security  SecurityConfig
+	Auth      AuthConfig
+	TLS       TLSConfig
+	Dest      DestinationConfig
+	Source    SourceConfig
 }
 
 type ServerConfig struct {
-	Port     string
-	GinMode  string
-	LogLevel string
+	Port            string
+	GinMode         string
+	LogLevel        string
+	ShutdownTimeout time.Duration // Time allowed for running uploads to reach a checkpoint
 }
 
 type DatabaseConfig struct {
@@ -27,8 +35,10 @@ type DatabaseConfig struct {
 }
 
 type ScanConfig struct {
-	HostPath string
-	CacheTTL time.Duration
+	HostPath      string
+	SecurityLog   string // Rejected source paths and other security events, empty = main log only
+	CacheTTL      time.Duration
+	IndexInterval time.Duration // Folder sizes older than this are recomputed in the background, 0 = only on request
 }
 
 type WorkerConfig struct {
@@ -36,13 +46,67 @@ type WorkerConfig struct {
 	ConcurrentFiles int
 	ChunkSize       int64
 	MaxRetries      int
+	MaxPerTarget    int           // Max running tasks per ZimaOS host, 0 = unlimited
+	QueuePoll       time.Duration // How often idle workers re-check the queue
 	// Verification settings
 	EnableVerification bool  // Enable file verification after upload
 	VerifyChunkSize    int64 // Size of chunk to verify (default 1MB)
 }
 
 type ZimaOSConfig struct {
-	Timeout int
+	Timeout               time.Duration // Whole-request limit for API calls (login, folders, listings)
+	DownloadTimeout       time.Duration // Whole-request limit for verification downloads
+	DialTimeout           time.Duration
+	KeepAlive             time.Duration // TCP keep-alive probe interval
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration // Wait for the response after the request body was sent
+	IdleConnTimeout       time.Duration // Close pooled connections idle this long
+	MaxIdleConnsPerHost   int
+	StallTimeout          time.Duration // Abort uploads that send no data this long, 0 = never
+}
+
+type BandwidthConfig struct {
+	Limit    string // Global upload limit per second, e.g. "20MB" or bytes, 0 = unlimited
+	Schedule string // Time-of-day overrides, e.g. "08:00-19:00=20MB;19:00-08:00=0"
+}
+
+type SecurityConfig struct {
+	SecretKey     string   // Key for encrypting stored credentials, overrides SecretKeyFile
+	SecretKeyFile string   // Generated on first start when SecretKey is not set
+	OldSecretKeys []string // Previous keys, still accepted for decryption during rotation
+}
+
+type AuthConfig struct {
+	SessionTTL     time.Duration // Lifetime of a login session
+	CookieSecure   bool          // Only send the session cookie over HTTPS
+	AllowedOrigins []string      // CORS origins allowed to call the API, empty = same-origin only
+}
+
+type TLSConfig struct {
+	Enabled      bool
+	CertFile     string   // User-supplied certificate, self-signed if empty
+	KeyFile      string   // Key for CertFile
+	Dir          string   // Where the self-signed certificate is kept
+	Hostnames    []string // Extra names for the self-signed certificate
+	RedirectPort string   // Plain HTTP port that redirects to HTTPS, empty = disabled
+}
+
+type DestinationConfig struct {
+	LocalRoots        []string      // Directories local destinations may write to (mounted NFS/SMB shares)
+	S3Timeout         time.Duration // Whole-request limit for S3 API calls (bucket check, HEAD, multipart control)
+	S3DownloadTimeout time.Duration // Whole-request limit for S3 verification downloads
+	S3CopyTimeout     time.Duration // Whole-request limit for server-side copies of duplicates, 0 = none
+}
+
+type SourceConfig struct {
+	Type          string // local (HOST_PATH mount) or sftp
+	URL           string // host[:port] of the network source
+	Username      string
+	Password      string
+	KeyFile       string // PEM private key, used instead of Password
+	HostKey       string // Expected server key, "SHA256:..."
+	IgnoreHostKey bool
+	Roots         []string // Directories listed as volumes, default "/"
 }
 
 var AppConfig *Config
@@ -50,30 +114,86 @@ var AppConfig *Config
 func Load() error {
 	godotenv.Load()
 
+	tlsEnabled := getEnvAsBool("TLS_ENABLED", false)
+
 	AppConfig = &Config{
 		Server: ServerConfig{
-			Port:     getEnv("SERVER_PORT", "8080"),
-			GinMode:  getEnv("GIN_MODE", "release"),
-			LogLevel: getEnv("LOG_LEVEL", "info"),
+			Port:            getEnv("SERVER_PORT", "8080"),
+			GinMode:         getEnv("GIN_MODE", "release"),
+			LogLevel:        getEnv("LOG_LEVEL", "info"),
+			ShutdownTimeout: time.Duration(getEnvAsInt("SHUTDOWN_TIMEOUT", 30)) * time.Second,
 		},
 		Database: DatabaseConfig{
 			Path: getEnv("DB_PATH", "/data/stoz.db"),
 		},
 		Scan: ScanConfig{
-			HostPath: getEnv("HOST_PATH", "/host"),
-			CacheTTL: time.Duration(getEnvAsInt("SCAN_CACHE_TTL", 300)) * time.Second,
+			HostPath:      getEnv("HOST_PATH", "/host"),
+			SecurityLog:   getEnv("SECURITY_LOG", "/data/security.log"),
+			CacheTTL:      time.Duration(getEnvAsInt("SCAN_CACHE_TTL", 300)) * time.Second,
+			IndexInterval: time.Duration(getEnvAsInt("SCAN_INDEX_INTERVAL", 21600)) * time.Second,
 		},
 		Worker: WorkerConfig{
 			Count:              getEnvAsInt("WORKER_COUNT", 3),
 			ConcurrentFiles:    getEnvAsInt("CONCURRENT_FILES", 3),
 			ChunkSize:          int64(getEnvAsInt("CHUNK_SIZE", 10485760)),
 			MaxRetries:         getEnvAsInt("MAX_RETRIES", 3),
+			MaxPerTarget:       getEnvAsInt("MAX_TASKS_PER_TARGET", 0),
+			QueuePoll:          time.Duration(getEnvAsInt("QUEUE_POLL_INTERVAL", 5)) * time.Second,
 			EnableVerification: getEnvAsBool("ENABLE_VERIFICATION", true),
 			VerifyChunkSize:    int64(getEnvAsInt("VERIFY_CHUNK_SIZE", 1048576)), // 1MB
 		},
 		ZimaOS: ZimaOSConfig{
-			Timeout: getEnvAsInt("ZIMAOS_TIMEOUT", 30),
+			Timeout:               time.Duration(getEnvAsInt("ZIMAOS_TIMEOUT", 30)) * time.Second,
+			DownloadTimeout:       time.Duration(getEnvAsInt("ZIMAOS_DOWNLOAD_TIMEOUT", 60)) * time.Second,
+			DialTimeout:           time.Duration(getEnvAsInt("ZIMAOS_DIAL_TIMEOUT", 10)) * time.Second,
+			KeepAlive:             time.Duration(getEnvAsInt("ZIMAOS_KEEPALIVE", 30)) * time.Second,
+			TLSHandshakeTimeout:   time.Duration(getEnvAsInt("ZIMAOS_TLS_HANDSHAKE_TIMEOUT", 10)) * time.Second,
+			ResponseHeaderTimeout: time.Duration(getEnvAsInt("ZIMAOS_RESPONSE_HEADER_TIMEOUT", 120)) * time.Second,
+			IdleConnTimeout:       time.Duration(getEnvAsInt("ZIMAOS_IDLE_CONN_TIMEOUT", 90)) * time.Second,
+			MaxIdleConnsPerHost:   getEnvAsInt("ZIMAOS_MAX_IDLE_CONNS", 8),
+			StallTimeout:          time.Duration(getEnvAsInt("ZIMAOS_STALL_TIMEOUT", 60)) * time.Second,
+		},
+		Bandwidth: BandwidthConfig{
+			Limit:    getEnv("BANDWIDTH_LIMIT", "0"),
+			Schedule: getEnv("BANDWIDTH_SCHEDULE", ""),
+		},
+		Security: SecurityConfig{
+			SecretKey:     getEnv("SECRET_KEY", ""),
+			SecretKeyFile: getEnv("SECRET_KEY_FILE", "/data/secret.key"),
+			OldSecretKeys: getEnvAsList("SECRET_KEY_OLD"),
+		},
+		Auth: AuthConfig{
+			SessionTTL:     time.Duration(getEnvAsInt("SESSION_TTL", 168)) * time.Hour,
+			CookieSecure:   getEnvAsBool("COOKIE_SECURE", tlsEnabled),
+			AllowedOrigins: getEnvAsList("CORS_ALLOWED_ORIGINS"),
+		},
+		TLS: TLSConfig{
+			Enabled:      tlsEnabled,
+			CertFile:     getEnv("TLS_CERT_FILE", ""),
+			KeyFile:      getEnv("TLS_KEY_FILE", ""),
+			Dir:          getEnv("TLS_DIR", "/data/tls"),
+			Hostnames:    getEnvAsList("TLS_HOSTNAMES"),
+			RedirectPort: getEnv("HTTP_REDIRECT_PORT", ""),
+		},
+		Dest: DestinationConfig{
+			LocalRoots:        getEnvAsList("LOCAL_DEST_ROOTS"),
+			S3Timeout:         time.Duration(getEnvAsInt("S3_TIMEOUT", 30)) * time.Second,
+			S3DownloadTimeout: time.Duration(getEnvAsInt("S3_DOWNLOAD_TIMEOUT", 60)) * time.Second,
+			S3CopyTimeout:     time.Duration(getEnvAsInt("S3_COPY_TIMEOUT", 3600)) * time.Second,
 		},
+		Source: SourceConfig{
+			Type:          getEnv("SOURCE_TYPE", "local"),
+			URL:           getEnv("SOURCE_URL", ""),
+			Username:      getEnv("SOURCE_USERNAME", ""),
+			Password:      getEnv("SOURCE_PASSWORD", ""),
+			KeyFile:       getEnv("SOURCE_KEY_FILE", ""),
+			HostKey:       getEnv("SOURCE_HOST_KEY", ""),
+			IgnoreHostKey: getEnvAsBool("SOURCE_IGNORE_HOST_KEY", false),
+			Roots:         getEnvAsList("SOURCE_ROOTS"),
+		},
+	}
+	if len(AppConfig.Dest.LocalRoots) == 0 {
+		AppConfig.Dest.LocalRoots = []string{"/mnt"}
 	}
 
 	return nil
@@ -94,6 +214,16 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvAsList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(getEnv(key, ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
 func getEnvAsBool(key string, defaultValue bool) bool {
 	valueStr := getEnv(key, "")
 	if valueStr == "" {
@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/middleware"
+	"github.com/atopos31/stoz/models"
+	"github.com/atopos31/stoz/service"
+	"github.com/gin-gonic/gin"
+)
+
+type AuditHandler struct {
+	auditSvc *service.AuditService
+}
+
+func NewAuditHandler() *AuditHandler {
+	return &AuditHandler{
+		auditSvc: service.GetAuditService(),
+	}
+}
+
+// ListAudit returns audit entries, newest first. With format=csv the full
+// filtered result is exported instead of a page.
+func (h *AuditHandler) ListAudit(c *gin.Context) {
+	filter := service.AuditFilter{
+		Actor:      c.Query("actor"),
+		Action:     c.Query("action"),
+		ResourceID: c.Query("resource_id"),
+		Target:     c.Query("target"),
+		Outcome:    c.Query("outcome"),
+	}
+	var err error
+	if filter.Since, err = parseTimeQuery(c, "since"); err != nil {
+		models.BadRequest(c, err.Error())
+		return
+	}
+	if filter.Until, err = parseTimeQuery(c, "until"); err != nil {
+		models.BadRequest(c, err.Error())
+		return
+	}
+
+	csvExport := c.Query("format") == "csv"
+	if !csvExport {
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		if err != nil || limit <= 0 {
+			limit = 50
+		}
+		offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if err != nil || offset < 0 {
+			offset = 0
+		}
+		filter.Limit = limit
+		filter.Offset = offset
+	}
+
+	entries, total, err := h.auditSvc.List(filter)
+	if err != nil {
+		common.Errorf("Failed to list audit log: %v", err)
+		models.Error(c, 500, "Failed to list audit log: "+err.Error())
+		return
+	}
+
+	if csvExport {
+		h.writeCSV(c, entries)
+		return
+	}
+
+	models.Success(c, gin.H{
+		"entries": entries,
+		"total":   total,
+		"limit":   filter.Limit,
+		"offset":  filter.Offset,
+	})
+}
+
+// parseTimeQuery reads an optional RFC 3339 query parameter
+func parseTimeQuery(c *gin.Context, name string) (*time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: expected an RFC 3339 timestamp", name)
+	}
+	return &t, nil
+}
+
+func (h *AuditHandler) writeCSV(c *gin.Context, entries []*models.AuditLog) {
+	filename := fmt.Sprintf("stoz-audit-%s.csv", time.Now().Format("20060102-150405"))
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"id", "time", "actor", "client_ip", "action", "resource_type", "resource_id", "target_id", "target_host", "outcome", "detail"})
+	for _, e := range entries {
+		w.Write([]string{
+			strconv.FormatUint(uint64(e.ID), 10),
+			e.CreatedAt.UTC().Format(time.RFC3339),
+			e.Actor,
+			e.ClientIP,
+			e.Action,
+			e.ResourceType,
+			e.ResourceID,
+			e.TargetID,
+			e.TargetHost,
+			e.Outcome,
+			csvSafe(e.Detail),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		common.Errorf("Failed to write audit CSV: %v", err)
+	}
+}
+
+// csvSafe keeps spreadsheet applications from evaluating a cell as a formula
+func csvSafe(value string) string {
+	if value != "" && (value[0] == '=' || value[0] == '+' || value[0] == '-' || value[0] == '@') {
+		return "'" + value
+	}
+	return value
+}
+
+// audit records an action taken by the current user. A non-nil err marks
+// the entry as failed and stores the error as its detail.
+func audit(c *gin.Context, entry *models.AuditLog, err error) {
+	if user := middleware.CurrentUser(c); user != nil {
+		entry.Actor = user.Username
+		entry.ActorID = user.ID
+	}
+	entry.ClientIP = c.ClientIP()
+	if err != nil {
+		entry.Outcome = models.AuditFailure
+		entry.Detail = err.Error()
+	}
+	service.GetAuditService().Record(entry)
+}
+
+// auditTask records an action on a task, taking the target from the task itself
+func auditTask(c *gin.Context, action, taskID string, err error) {
+	entry := &models.AuditLog{Action: action, ResourceType: "task", ResourceID: taskID}
+	if task, getErr := service.GetMigrationService().GetTask(taskID); getErr == nil {
+		entry.TargetID = task.TargetID
+		entry.TargetHost = task.DestURL
+	}
+	audit(c, entry, err)
+}
+
+// auditSchedule records an action on a schedule
+func auditSchedule(c *gin.Context, action string, schedule *models.Schedule, err error) {
+	audit(c, &models.AuditLog{
+		Action:       action,
+		ResourceType: "schedule",
+		ResourceID:   schedule.ScheduleID,
+		TargetID:     schedule.TargetID,
+		TargetHost:   schedule.ZimaOSHost,
+	}, err)
+}
+
+// auditTarget records an action on a saved target
+func auditTarget(c *gin.Context, action, targetID string, err error) {
+	entry := &models.AuditLog{Action: action, ResourceType: "target", ResourceID: targetID, TargetID: targetID}
+	if target, getErr := service.GetTargetService().GetTarget(targetID); getErr == nil {
+		entry.TargetHost = target.ZimaOSHost
+	}
+	audit(c, entry, err)
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/config"
+	"github.com/atopos31/stoz/middleware"
+	"github.com/atopos31/stoz/models"
+	"github.com/atopos31/stoz/service"
+	"github.com/gin-gonic/gin"
+)
+
+type AuthHandler struct {
+	authSvc *service.AuthService
+}
+
+func NewAuthHandler() *AuthHandler {
+	return &AuthHandler{
+		authSvc: service.GetAuthService(),
+	}
+}
+
+type CredentialsRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// Status tells the web UI whether to show the setup or the login page
+func (h *AuthHandler) Status(c *gin.Context) {
+	required, err := h.authSvc.SetupRequired()
+	if err != nil {
+		common.Errorf("Failed to check setup status: %v", err)
+		models.Error(c, 500, "Failed to check setup status: "+err.Error())
+		return
+	}
+
+	var username string
+	if user, err := h.authSvc.Authenticate(middleware.SessionToken(c)); err == nil {
+		username = user.Username
+	}
+
+	models.Success(c, gin.H{
+		"setup_required": required,
+		"authenticated":  username != "",
+		"username":       username,
+	})
+}
+
+// Setup creates the first admin account and logs it in
+func (h *AuthHandler) Setup(c *gin.Context) {
+	var req CredentialsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		models.BadRequest(c, "Invalid request: "+err.Error())
+		return
+	}
+
+	_, err := h.authSvc.Setup(req.Username, req.Password)
+	audit(c, &models.AuditLog{Actor: req.Username, Action: "auth.setup", ResourceType: "user", ResourceID: req.Username}, err)
+	if err != nil {
+		common.Errorf("Setup failed: %v", err)
+		switch {
+		case errors.Is(err, common.ErrInvalidRequest):
+			models.BadRequest(c, err.Error())
+		case errors.Is(err, common.ErrInvalidStatus):
+			models.Error(c, 409, err.Error())
+		default:
+			models.Error(c, 500, "Setup failed: "+err.Error())
+		}
+		return
+	}
+
+	h.login(c, req)
+}
+
+func (h *AuthHandler) Login(c *gin.Context) {
+	var req CredentialsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		models.BadRequest(c, "Invalid request: "+err.Error())
+		return
+	}
+
+	h.login(c, req)
+}
+
+func (h *AuthHandler) login(c *gin.Context, req CredentialsRequest) {
+	token, session, user, err := h.authSvc.Login(req.Username, req.Password)
+	entry := &models.AuditLog{Actor: req.Username, Action: "auth.login", ResourceType: "user", ResourceID: req.Username}
+	if user != nil {
+		entry.ActorID = user.ID
+	}
+	audit(c, entry, err)
+	if err != nil {
+		if errors.Is(err, common.ErrAuthFailed) {
+			common.Warnf("Failed login for %q from %s", req.Username, c.ClientIP())
+			models.Unauthorized(c, "Invalid username or password")
+			return
+		}
+		common.Errorf("Login failed: %v", err)
+		models.Error(c, 500, "Login failed: "+err.Error())
+		return
+	}
+
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(middleware.SessionCookie, token, int(time.Until(session.ExpiresAt).Seconds()), "/", "", config.AppConfig.Auth.CookieSecure, true)
+
+	// The token is also returned for API clients using bearer auth
+	models.Success(c, gin.H{
+		"token":      token,
+		"expires_at": session.ExpiresAt,
+		"user":       user,
+	})
+}
+
+func (h *AuthHandler) Logout(c *gin.Context) {
+	err := h.authSvc.Logout(middleware.SessionToken(c))
+	audit(c, &models.AuditLog{Action: "auth.logout", ResourceType: "user"}, err)
+	if err != nil {
+		common.Errorf("Logout failed: %v", err)
+	}
+
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(middleware.SessionCookie, "", -1, "/", "", config.AppConfig.Auth.CookieSecure, true)
+	models.SuccessWithMessage(c, "Logged out", nil)
+}
+
+func (h *AuthHandler) Me(c *gin.Context) {
+	models.Success(c, middleware.CurrentUser(c))
+}
@@ -1,13 +1,18 @@
 package handler
 
 import (
+	"errors"
+	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/middleware"
 	"github.com/atopos31/stoz/models"
 	"github.com/atopos31/stoz/service"
 	"github.com/atopos31/stoz/worker"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type MigrationHandler struct {
@@ -20,38 +25,182 @@ func NewMigrationHandler() *MigrationHandler {
 	}
 }
 
-type TestConnectionRequest struct {
-	Host     string `json:"host" binding:"required"`
-	Username string `json:"username" binding:"required"`
-	Password string `json:"password" binding:"required"`
+// ConnectionRequest identifies a ZimaOS instance either by saved target or
+// by inline credentials
+type ConnectionRequest struct {
+	TargetID string `json:"target_id"`
+	Host     string `json:"host"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (r *ConnectionRequest) validate() error {
+	if r.TargetID == "" && (r.Host == "" || r.Username == "" || r.Password == "") {
+		return errors.New("either target_id or host, username and password are required")
+	}
+	return nil
+}
+
+// client returns a client for the saved target or the inline credentials
+func (r *ConnectionRequest) client() (*service.ZimaOSClient, error) {
+	if r.TargetID == "" {
+		return service.NewZimaOSClient(r.Host, r.Username, r.Password), nil
+	}
+	target, err := service.GetTargetService().GetTarget(r.TargetID)
+	if err != nil {
+		return nil, err
+	}
+	return service.GetTargetService().NewClient(target)
+}
+
+// BrowseRequest lists the folders below Path; an empty path lists the storages
+type BrowseRequest struct {
+	ConnectionRequest
+	Path string `json:"path"`
+}
+
+// Browse lists folders on a ZimaOS instance for the base path picker
+func (h *MigrationHandler) Browse(c *gin.Context) {
+	var req BrowseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		models.BadRequest(c, "Invalid request: "+err.Error())
+		return
+	}
+	if err := req.validate(); err != nil {
+		models.BadRequest(c, "Invalid request: "+err.Error())
+		return
+	}
+
+	client, err := req.client()
+	if err != nil {
+		handleTargetError(c, "Failed to browse folders", err)
+		return
+	}
+	folders, err := client.BrowseFolders(req.Path)
+	if err != nil {
+		handleTargetError(c, "Failed to browse folders", err)
+		return
+	}
+
+	models.Success(c, gin.H{
+		"path":    req.Path,
+		"folders": folders,
+		"count":   len(folders),
+	})
+}
+
+// CreateFolderRequest creates Path inside an existing folder
+type CreateFolderRequest struct {
+	ConnectionRequest
+	Path string `json:"path" binding:"required"`
+}
+
+// CreateFolder creates a folder from the base path picker
+func (h *MigrationHandler) CreateFolder(c *gin.Context) {
+	var req CreateFolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		models.BadRequest(c, "Invalid request: "+err.Error())
+		return
+	}
+	if err := req.validate(); err != nil {
+		models.BadRequest(c, "Invalid request: "+err.Error())
+		return
+	}
+
+	client, err := req.client()
+	if err == nil {
+		// The parent must exist, which also keeps the folder inside a storage
+		if err = client.CheckFolder(filepath.Dir(req.Path)); err == nil {
+			err = client.CreateFolder(filepath.Clean(req.Path))
+		}
+	}
+	audit(c, &models.AuditLog{
+		Action:       "zimaos.folder.create",
+		ResourceType: "zimaos",
+		ResourceID:   req.Path,
+		TargetID:     req.TargetID,
+		TargetHost:   req.Host,
+	}, err)
+	if err != nil {
+		handleTargetError(c, "Failed to create folder", err)
+		return
+	}
+
+	models.SuccessWithMessage(c, "Folder created", gin.H{
+		"path": filepath.Clean(req.Path),
+	})
 }
 
 func (h *MigrationHandler) TestConnection(c *gin.Context) {
-	var req TestConnectionRequest
+	var req ConnectionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		models.BadRequest(c, "Invalid request: "+err.Error())
 		return
 	}
+	if err := req.validate(); err != nil {
+		models.BadRequest(c, "Invalid request: "+err.Error())
+		return
+	}
+
+	if req.TargetID != "" {
+		NewTargetHandler().testTarget(c, req.TargetID)
+		return
+	}
 
 	client := service.NewZimaOSClient(req.Host, req.Username, req.Password)
-	if err := client.TestConnection(); err != nil {
+	err := client.TestConnection()
+	audit(c, &models.AuditLog{Action: "zimaos.test", ResourceType: "zimaos", TargetHost: req.Host}, err)
+	if err != nil {
 		common.Errorf("Connection test failed: %v", err)
 		models.Error(c, 500, "Connection test failed: "+err.Error())
 		return
 	}
 
 	models.SuccessWithMessage(c, "Connection successful", gin.H{
-		"token": client.GetToken()[:10] + "...",
+		"host": req.Host,
+	})
+}
+
+// TestDestination connects to a non-ZimaOS destination with the given settings
+func (h *MigrationHandler) TestDestination(c *gin.Context) {
+	var req service.DestinationSpec
+	if err := c.ShouldBindJSON(&req); err != nil {
+		models.BadRequest(c, "Invalid request: "+err.Error())
+		return
+	}
+
+	dest, err := service.NewDestination(req.Type, req.URL, req.Username, req.Password, req.DestinationOptions)
+	if err != nil {
+		models.BadRequest(c, err.Error())
+		return
+	}
+	defer dest.Close()
+
+	err = dest.Login()
+	audit(c, &models.AuditLog{Action: "destination.test", ResourceType: req.Type, TargetHost: req.URL}, err)
+	if err != nil {
+		common.Errorf("Destination test failed: %v", err)
+		models.Error(c, 500, "Connection test failed: "+err.Error())
+		return
+	}
+
+	models.SuccessWithMessage(c, "Connection successful", gin.H{
+		"type": req.Type,
+		"url":  req.URL,
 	})
 }
 
 type CreateMigrationRequest struct {
 	SourceFolders []string                 `json:"source_folders" binding:"required"`
-	ZimaOSHost    string                   `json:"zimaos_host" binding:"required"`
-	ZimaOSUser    string                   `json:"zimaos_username" binding:"required"`
-	ZimaOSPass    string                   `json:"zimaos_password" binding:"required"`
-	BasePath      string                   `json:"base_path" binding:"required"`
+	TargetID      string                   `json:"target_id"` // Saved target, replaces the ZimaOS fields
+	ZimaOSHost    string                   `json:"zimaos_host"`
+	ZimaOSUser    string                   `json:"zimaos_username"`
+	ZimaOSPass    string                   `json:"zimaos_password"`
+	BasePath      string                   `json:"base_path"` // Defaults to the target's base path
 	Options       service.MigrationOptions `json:"options"`
+	Priority      int                      `json:"priority"`
+	Destination   *service.DestinationSpec `json:"destination"`      // Non-ZimaOS destination, replaces the ZimaOS fields
+	SourceTarget  string                   `json:"source_target_id"` // Read source_folders from this ZimaOS target
 }
 
 func (h *MigrationHandler) CreateMigration(c *gin.Context) {
@@ -65,21 +214,61 @@ func (h *MigrationHandler) CreateMigration(c *gin.Context) {
 		models.BadRequest(c, "At least one source folder is required")
 		return
 	}
+	source, releaseSource, err := service.OpenTaskSource(req.SourceTarget)
+	if err != nil {
+		if errors.Is(err, common.ErrInvalidRequest) {
+			models.BadRequest(c, err.Error())
+			return
+		}
+		models.Error(c, 500, "Failed to open source: "+err.Error())
+		return
+	}
+	sourceFolders, ok := authorizeSourcePaths(c, source, req.SourceFolders)
+	releaseSource()
+	if !ok {
+		return
+	}
+
+	spec := service.TaskSpec{
+		SourceFolders: sourceFolders,
+		Host:          req.ZimaOSHost,
+		Username:      req.ZimaOSUser,
+		Password:      req.ZimaOSPass,
+		BasePath:      req.BasePath,
+		Options:       req.Options,
+		Priority:      req.Priority,
+		TargetID:      req.TargetID,
+
+		SourceTargetID: req.SourceTarget,
+	}
+	if dest := req.Destination; dest != nil {
+		spec.DestinationType = dest.Type
+		spec.DestinationOptions = dest.DestinationOptions
+		spec.Host, spec.Username, spec.Password = dest.URL, dest.Username, dest.Password
+	}
 
-	taskID, err := h.migrationSvc.CreateTask(
-		req.SourceFolders,
-		req.ZimaOSHost,
-		req.ZimaOSUser,
-		req.ZimaOSPass,
-		req.BasePath,
-		req.Options,
-	)
+	err = h.migrationSvc.CheckBasePath(spec)
+	var taskID string
+	if err == nil {
+		taskID, err = h.migrationSvc.CreateTask(spec)
+	}
 	if err != nil {
+		audit(c, &models.AuditLog{
+			Action:       "migration.create",
+			ResourceType: "task",
+			TargetID:     req.TargetID,
+			TargetHost:   spec.Host,
+		}, err)
 		common.Errorf("Failed to create migration task: %v", err)
+		if errors.Is(err, common.ErrInvalidRequest) {
+			models.BadRequest(c, err.Error())
+			return
+		}
 		models.Error(c, 500, "Failed to create task: "+err.Error())
 		return
 	}
 
+	auditTask(c, "migration.create", taskID, nil)
 	worker.GetWorkerPool().SubmitTask(taskID)
 
 	models.Success(c, gin.H{
@@ -94,6 +283,10 @@ func (h *MigrationHandler) GetMigrationStatus(c *gin.Context) {
 		return
 	}
 
+	if !h.authorizeTask(c, taskID) {
+		return
+	}
+
 	status, err := h.migrationSvc.GetTaskStatus(taskID)
 	if err != nil {
 		common.Errorf("Failed to get task status: %v", err)
@@ -118,7 +311,7 @@ func (h *MigrationHandler) ListMigrations(c *gin.Context) {
 		offset = 0
 	}
 
-	tasks, total, err := h.migrationSvc.ListTasks(limit, offset)
+	tasks, total, err := h.migrationSvc.ListTasksFor(middleware.CurrentUser(c), limit, offset)
 	if err != nil {
 		common.Errorf("Failed to list tasks: %v", err)
 		models.Error(c, 500, "Failed to list tasks: "+err.Error())
@@ -140,7 +333,13 @@ func (h *MigrationHandler) CancelMigration(c *gin.Context) {
 		return
 	}
 
-	if err := h.migrationSvc.CancelTask(taskID); err != nil {
+	if !h.authorizeTask(c, taskID) {
+		return
+	}
+
+	err := h.migrationSvc.CancelTask(taskID)
+	auditTask(c, "migration.cancel", taskID, err)
+	if err != nil {
 		common.Errorf("Failed to cancel task: %v", err)
 		models.Error(c, 500, "Failed to cancel task: "+err.Error())
 		return
@@ -149,27 +348,115 @@ func (h *MigrationHandler) CancelMigration(c *gin.Context) {
 	models.SuccessWithMessage(c, "Task cancelled", nil)
 }
 
-type GetStorageListRequest struct {
-	Host     string `json:"host" binding:"required"`
-	Username string `json:"username" binding:"required"`
-	Password string `json:"password" binding:"required"`
+func (h *MigrationHandler) UpdateLimits(c *gin.Context) {
+	taskID := c.Param("taskId")
+	if taskID == "" {
+		models.BadRequest(c, "Task ID is required")
+		return
+	}
+
+	if !h.authorizeTask(c, taskID) {
+		return
+	}
+
+	var req service.BandwidthPolicy
+	if err := c.ShouldBindJSON(&req); err != nil {
+		models.BadRequest(c, "Invalid request: "+err.Error())
+		return
+	}
+
+	applied, err := h.migrationSvc.UpdateTaskLimits(taskID, req)
+	auditTask(c, "migration.limits", taskID, err)
+	if err != nil {
+		common.Errorf("Failed to update task limits: %v", err)
+		switch {
+		case errors.Is(err, common.ErrInvalidRequest):
+			models.BadRequest(c, err.Error())
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			models.Error(c, 404, "Task not found")
+		case errors.Is(err, common.ErrInvalidStatus):
+			models.Error(c, 409, err.Error())
+		default:
+			models.Error(c, 500, "Failed to update limits: "+err.Error())
+		}
+		return
+	}
+
+	models.SuccessWithMessage(c, "Limits updated", gin.H{
+		"bandwidth":     req,
+		"current_limit": req.LimitAt(time.Now()),
+		"applied":       applied,
+	})
+}
+
+type MoveMigrationRequest struct {
+	Position *int `json:"position"` // 1-based position in the pending queue
+	Priority *int `json:"priority"`
+}
+
+func (h *MigrationHandler) MoveMigration(c *gin.Context) {
+	taskID := c.Param("taskId")
+	if taskID == "" {
+		models.BadRequest(c, "Task ID is required")
+		return
+	}
+
+	var req MoveMigrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		models.BadRequest(c, "Invalid request: "+err.Error())
+		return
+	}
+	if req.Position == nil && req.Priority == nil {
+		models.BadRequest(c, "position or priority is required")
+		return
+	}
+
+	if !h.authorizeTask(c, taskID) {
+		return
+	}
+
+	task, err := h.migrationSvc.MoveTask(taskID, req.Position, req.Priority)
+	auditTask(c, "migration.move", taskID, err)
+	if err != nil {
+		common.Errorf("Failed to move task: %v", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			models.Error(c, 404, "Task not found")
+			return
+		}
+		if errors.Is(err, common.ErrInvalidStatus) {
+			models.BadRequest(c, err.Error())
+			return
+		}
+		models.Error(c, 500, "Failed to move task: "+err.Error())
+		return
+	}
+
+	models.SuccessWithMessage(c, "Task moved", gin.H{
+		"task_id":        task.TaskID,
+		"priority":       task.Priority,
+		"queue_position": task.QueueOrder,
+	})
 }
 
 func (h *MigrationHandler) GetStorageList(c *gin.Context) {
-	var req GetStorageListRequest
+	var req ConnectionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		models.BadRequest(c, "Invalid request: "+err.Error())
 		return
 	}
+	if err := req.validate(); err != nil {
+		models.BadRequest(c, "Invalid request: "+err.Error())
+		return
+	}
 
-	client := service.NewZimaOSClient(req.Host, req.Username, req.Password)
-	if err := client.Login(); err != nil {
-		common.Errorf("Login failed: %v", err)
-		models.Error(c, 500, "Login failed: "+err.Error())
+	if req.TargetID != "" {
+		NewTargetHandler().getStorages(c, req.TargetID)
 		return
 	}
 
+	client := service.NewZimaOSClient(req.Host, req.Username, req.Password)
 	storages, err := client.GetStorageList()
+	audit(c, &models.AuditLog{Action: "zimaos.storages", ResourceType: "zimaos", TargetHost: req.Host}, err)
 	if err != nil {
 		common.Errorf("Failed to get storage list: %v", err)
 		models.Error(c, 500, "Failed to get storage list: "+err.Error())
@@ -181,3 +468,14 @@ func (h *MigrationHandler) GetStorageList(c *gin.Context) {
 		"count":    len(storages),
 	})
 }
+
+// authorizeTask responds 404 for tasks the current user may not see, so
+// restricted users cannot probe for other departments' tasks
+func (h *MigrationHandler) authorizeTask(c *gin.Context, taskID string) bool {
+	task, err := h.migrationSvc.GetTask(taskID)
+	if err != nil || !service.CanAccessTask(middleware.CurrentUser(c), task) {
+		models.Error(c, 404, "Task not found")
+		return false
+	}
+	return true
+}
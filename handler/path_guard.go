@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/middleware"
+	"github.com/atopos31/stoz/models"
+	"github.com/atopos31/stoz/service"
+	"github.com/gin-gonic/gin"
+)
+
+// authorizeSourcePaths resolves the given paths on src (HOST_PATH/volume* for
+// the local mount) and checks them against the current user's allowed paths.
+// It returns the resolved paths, or responds 403 with the offending path and reason.
+func authorizeSourcePaths(c *gin.Context, src service.Source, paths []string) ([]string, bool) {
+	user := middleware.CurrentUser(c)
+	resolved := make([]string, 0, len(paths))
+
+	for _, path := range paths {
+		real, err := src.Resolve(path)
+		if err != nil {
+			var violation *service.PathViolation
+			if !errors.As(err, &violation) {
+				violation = &service.PathViolation{Path: path, Reason: service.PathInvalid, Detail: err.Error()}
+			}
+			common.SecurityEvent("source_path_rejected", map[string]interface{}{
+				"user":      user.Username,
+				"client_ip": c.ClientIP(),
+				"route":     c.FullPath(),
+				"path":      path,
+				"reason":    violation.Reason,
+			})
+			c.AbortWithStatusJSON(http.StatusForbidden, models.Response{
+				Code:    http.StatusForbidden,
+				Message: "Source path not allowed: " + path,
+				Data:    violation,
+			})
+			return nil, false
+		}
+
+		if !service.CanAccessPath(user, real) {
+			common.SecurityEvent("source_path_denied", map[string]interface{}{
+				"user":      user.Username,
+				"client_ip": c.ClientIP(),
+				"route":     c.FullPath(),
+				"path":      path,
+				"reason":    "not_in_allowed_paths",
+			})
+			c.AbortWithStatusJSON(http.StatusForbidden, models.Response{
+				Code:    http.StatusForbidden,
+				Message: "Access to " + path + " is not allowed",
+				Data:    &service.PathViolation{Path: path, Reason: "not_in_allowed_paths", Detail: "path is outside your allowed paths"},
+			})
+			return nil, false
+		}
+
+		resolved = append(resolved, real)
+	}
+	return resolved, true
+}
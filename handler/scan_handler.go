@@ -1,7 +1,10 @@
 package handler
 
 import (
+	"errors"
+
 	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/middleware"
 	"github.com/atopos31/stoz/models"
 	"github.com/atopos31/stoz/service"
 	"github.com/gin-gonic/gin"
@@ -25,7 +28,7 @@ func (h *ScanHandler) Scan(c *gin.Context) {
 		return
 	}
 
-	models.Success(c, result)
+	models.Success(c, service.FilterScanResult(middleware.CurrentUser(c), result))
 }
 
 type GetFolderDetailsRequest struct {
@@ -40,7 +43,12 @@ func (h *ScanHandler) GetFolderDetails(c *gin.Context) {
 		return
 	}
 
-	details, err := h.scannerService.GetFolderDetails(req.Path, req.IncludeRecycle)
+	paths, ok := authorizeSourcePaths(c, service.GetSource(), []string{req.Path})
+	if !ok {
+		return
+	}
+
+	details, err := h.scannerService.GetFolderDetails(paths[0], req.IncludeRecycle)
 	if err != nil {
 		common.Errorf("Failed to get folder details for %s: %v", req.Path, err)
 		models.Error(c, 500, "Failed to get folder details: "+err.Error())
@@ -49,3 +57,104 @@ func (h *ScanHandler) GetFolderDetails(c *gin.Context) {
 
 	models.Success(c, details)
 }
+
+type StartDedupeRequest struct {
+	Paths          []string `json:"paths" binding:"required,min=1"`
+	IncludeRecycle bool     `json:"include_recycle"`
+	MinSize        int64    `json:"min_size"` // Default 4 KiB
+}
+
+// StartDedupe starts a duplicate analysis of the given folders in the
+// background; poll GetDedupe for its progress and report
+func (h *ScanHandler) StartDedupe(c *gin.Context) {
+	var req StartDedupeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		models.BadRequest(c, "Invalid request: "+err.Error())
+		return
+	}
+
+	paths, ok := authorizeSourcePaths(c, service.GetSource(), req.Paths)
+	if !ok {
+		return
+	}
+
+	job, err := h.scannerService.StartDedupe(paths, req.IncludeRecycle, req.MinSize)
+	if err != nil {
+		switch {
+		case errors.Is(err, common.ErrInvalidRequest):
+			models.BadRequest(c, err.Error())
+		case errors.Is(err, common.ErrInvalidStatus):
+			models.Error(c, 409, err.Error())
+		default:
+			models.Error(c, 500, "Failed to start duplicate analysis: "+err.Error())
+		}
+		return
+	}
+
+	models.Success(c, job)
+}
+
+func (h *ScanHandler) GetDedupe(c *gin.Context) {
+	job, err := h.scannerService.GetDedupe(c.Param("jobId"))
+	if err != nil {
+		models.Error(c, 404, "Duplicate analysis not found")
+		return
+	}
+
+	// Like tasks, a job is only visible to users who may see all of its paths
+	user := middleware.CurrentUser(c)
+	for _, path := range job.Paths {
+		if !service.CanAccessPath(user, path) {
+			models.Error(c, 404, "Duplicate analysis not found")
+			return
+		}
+	}
+
+	models.Success(c, job)
+}
+
+// GetIndexStatus reports the progress of the background folder indexer
+func (h *ScanHandler) GetIndexStatus(c *gin.Context) {
+	status := service.GetFolderIndexer().Status()
+	if status.CurrentPath != "" && !service.CanAccessPath(middleware.CurrentUser(c), status.CurrentPath) {
+		status.CurrentPath = ""
+	}
+	models.Success(c, status)
+}
+
+type RefreshIndexRequest struct {
+	Paths []string `json:"paths"` // Empty refreshes every share
+}
+
+// RefreshIndex queues folders to be indexed again ahead of the periodic
+// refresh. Without paths every share is walked again, which only users
+// without path restrictions may start.
+func (h *ScanHandler) RefreshIndex(c *gin.Context) {
+	var req RefreshIndexRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			models.BadRequest(c, "Invalid request: "+err.Error())
+			return
+		}
+	}
+
+	indexer := service.GetFolderIndexer()
+	if len(req.Paths) == 0 {
+		if service.AllowedPaths(middleware.CurrentUser(c)) != nil {
+			models.Forbidden(c, "Refreshing every share requires access to all paths")
+			return
+		}
+		indexer.RefreshAll()
+		models.Success(c, indexer.Status())
+		return
+	}
+
+	paths, ok := authorizeSourcePaths(c, service.GetSource(), req.Paths)
+	if !ok {
+		return
+	}
+	for _, path := range paths {
+		indexer.Request(path)
+	}
+	models.Success(c, indexer.Status())
+}
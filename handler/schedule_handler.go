@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/middleware"
+	"github.com/atopos31/stoz/models"
+	"github.com/atopos31/stoz/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ScheduleHandler struct {
+	scheduleSvc *service.ScheduleService
+}
+
+func NewScheduleHandler() *ScheduleHandler {
+	return &ScheduleHandler{
+		scheduleSvc: service.GetScheduleService(),
+	}
+}
+
+func (h *ScheduleHandler) ListSchedules(c *gin.Context) {
+	schedules, err := h.scheduleSvc.ListSchedules()
+	if err != nil {
+		common.Errorf("Failed to list schedules: %v", err)
+		models.Error(c, 500, "Failed to list schedules: "+err.Error())
+		return
+	}
+
+	user := middleware.CurrentUser(c)
+	visible := make([]*models.Schedule, 0, len(schedules))
+	for _, schedule := range schedules {
+		if service.CanAccessSchedule(user, schedule) {
+			visible = append(visible, schedule)
+		}
+	}
+	schedules = visible
+
+	models.Success(c, gin.H{
+		"schedules": schedules,
+		"total":     len(schedules),
+	})
+}
+
+func (h *ScheduleHandler) CreateSchedule(c *gin.Context) {
+	var req service.ScheduleSpec
+	if err := c.ShouldBindJSON(&req); err != nil {
+		models.BadRequest(c, "Invalid request: "+err.Error())
+		return
+	}
+
+	sourceFolders, ok := authorizeSourcePaths(c, service.GetSource(), req.SourceFolders)
+	if !ok {
+		return
+	}
+	req.SourceFolders = sourceFolders
+
+	schedule, err := h.scheduleSvc.CreateSchedule(req)
+	entry := &models.AuditLog{Action: "schedule.create", ResourceType: "schedule", TargetID: req.TargetID, TargetHost: req.ZimaOSHost}
+	if schedule != nil {
+		entry.ResourceID = schedule.ScheduleID
+		entry.TargetHost = schedule.ZimaOSHost
+	}
+	audit(c, entry, err)
+	if err != nil {
+		h.handleError(c, "Failed to create schedule", err)
+		return
+	}
+
+	models.Success(c, schedule)
+}
+
+func (h *ScheduleHandler) GetSchedule(c *gin.Context) {
+	schedule, ok := h.authorizeSchedule(c)
+	if !ok {
+		return
+	}
+
+	models.Success(c, schedule)
+}
+
+func (h *ScheduleHandler) UpdateSchedule(c *gin.Context) {
+	var req service.ScheduleSpec
+	if err := c.ShouldBindJSON(&req); err != nil {
+		models.BadRequest(c, "Invalid request: "+err.Error())
+		return
+	}
+
+	current, ok := h.authorizeSchedule(c)
+	if !ok {
+		return
+	}
+	sourceFolders, ok := authorizeSourcePaths(c, service.GetSource(), req.SourceFolders)
+	if !ok {
+		return
+	}
+	req.SourceFolders = sourceFolders
+
+	schedule, err := h.scheduleSvc.UpdateSchedule(c.Param("scheduleId"), req)
+	auditSchedule(c, "schedule.update", current, err)
+	if err != nil {
+		h.handleError(c, "Failed to update schedule", err)
+		return
+	}
+
+	models.Success(c, schedule)
+}
+
+func (h *ScheduleHandler) DeleteSchedule(c *gin.Context) {
+	schedule, ok := h.authorizeSchedule(c)
+	if !ok {
+		return
+	}
+
+	err := h.scheduleSvc.DeleteSchedule(c.Param("scheduleId"))
+	auditSchedule(c, "schedule.delete", schedule, err)
+	if err != nil {
+		h.handleError(c, "Failed to delete schedule", err)
+		return
+	}
+
+	models.SuccessWithMessage(c, "Schedule deleted", nil)
+}
+
+// authorizeSchedule loads the schedule from the URL, responding 404 if it
+// does not exist or is not visible to the current user
+func (h *ScheduleHandler) authorizeSchedule(c *gin.Context) (*models.Schedule, bool) {
+	schedule, err := h.scheduleSvc.GetSchedule(c.Param("scheduleId"))
+	if err == nil && !service.CanAccessSchedule(middleware.CurrentUser(c), schedule) {
+		err = gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		h.handleError(c, "Failed to get schedule", err)
+		return nil, false
+	}
+	return schedule, true
+}
+
+func (h *ScheduleHandler) handleError(c *gin.Context, message string, err error) {
+	common.Errorf("%s: %v", message, err)
+	switch {
+	case errors.Is(err, common.ErrInvalidRequest):
+		models.BadRequest(c, err.Error())
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		models.Error(c, 404, "Schedule not found")
+	default:
+		models.Error(c, 500, message+": "+err.Error())
+	}
+}
@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/models"
+	"github.com/atopos31/stoz/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TargetHandler struct {
+	targetSvc *service.TargetService
+}
+
+func NewTargetHandler() *TargetHandler {
+	return &TargetHandler{
+		targetSvc: service.GetTargetService(),
+	}
+}
+
+func (h *TargetHandler) ListTargets(c *gin.Context) {
+	targets, err := h.targetSvc.ListTargets()
+	if err != nil {
+		common.Errorf("Failed to list targets: %v", err)
+		models.Error(c, 500, "Failed to list targets: "+err.Error())
+		return
+	}
+
+	models.Success(c, gin.H{
+		"targets": targets,
+		"total":   len(targets),
+	})
+}
+
+func (h *TargetHandler) CreateTarget(c *gin.Context) {
+	var req service.TargetSpec
+	if err := c.ShouldBindJSON(&req); err != nil {
+		models.BadRequest(c, "Invalid request: "+err.Error())
+		return
+	}
+
+	target, err := h.targetSvc.CreateTarget(req)
+	if err != nil {
+		audit(c, &models.AuditLog{Action: "target.create", ResourceType: "target", TargetHost: req.ZimaOSHost}, err)
+		handleTargetError(c, "Failed to create target", err)
+		return
+	}
+	auditTarget(c, "target.create", target.TargetID, nil)
+
+	models.Success(c, target)
+}
+
+func (h *TargetHandler) GetTarget(c *gin.Context) {
+	target, err := h.targetSvc.GetTarget(c.Param("targetId"))
+	if err != nil {
+		handleTargetError(c, "Failed to get target", err)
+		return
+	}
+
+	models.Success(c, target)
+}
+
+func (h *TargetHandler) UpdateTarget(c *gin.Context) {
+	var req service.TargetSpec
+	if err := c.ShouldBindJSON(&req); err != nil {
+		models.BadRequest(c, "Invalid request: "+err.Error())
+		return
+	}
+
+	target, err := h.targetSvc.UpdateTarget(c.Param("targetId"), req)
+	auditTarget(c, "target.update", c.Param("targetId"), err)
+	if err != nil {
+		handleTargetError(c, "Failed to update target", err)
+		return
+	}
+
+	models.Success(c, target)
+}
+
+func (h *TargetHandler) DeleteTarget(c *gin.Context) {
+	targetID := c.Param("targetId")
+	entry := &models.AuditLog{Action: "target.delete", ResourceType: "target", ResourceID: targetID, TargetID: targetID}
+	if target, err := h.targetSvc.GetTarget(targetID); err == nil {
+		entry.TargetHost = target.ZimaOSHost
+	}
+
+	err := h.targetSvc.DeleteTarget(targetID)
+	audit(c, entry, err)
+	if err != nil {
+		handleTargetError(c, "Failed to delete target", err)
+		return
+	}
+
+	models.SuccessWithMessage(c, "Target deleted", nil)
+}
+
+// TestTarget re-tests the connection using the stored credentials
+func (h *TargetHandler) TestTarget(c *gin.Context) {
+	h.testTarget(c, c.Param("targetId"))
+}
+
+func (h *TargetHandler) testTarget(c *gin.Context, targetID string) {
+	target, err := h.targetSvc.TestTarget(targetID)
+	auditTarget(c, "target.test", targetID, err)
+	if err != nil {
+		handleTargetError(c, "Connection test failed", err)
+		return
+	}
+
+	models.SuccessWithMessage(c, "Connection successful", target)
+}
+
+// GetStorages fetches the current storage list and caches it on the target
+func (h *TargetHandler) GetStorages(c *gin.Context) {
+	h.getStorages(c, c.Param("targetId"))
+}
+
+func (h *TargetHandler) getStorages(c *gin.Context, targetID string) {
+	storages, err := h.targetSvc.RefreshStorages(targetID)
+	auditTarget(c, "target.storages", targetID, err)
+	if err != nil {
+		handleTargetError(c, "Failed to get storage list", err)
+		return
+	}
+
+	models.Success(c, gin.H{
+		"storages": storages,
+		"count":    len(storages),
+	})
+}
+
+// handleTargetError maps errors of target operations, including browsing and
+// creating folders on a target, to responses
+func handleTargetError(c *gin.Context, message string, err error) {
+	common.Errorf("%s: %v", message, err)
+	switch {
+	case errors.Is(err, common.ErrInvalidRequest):
+		models.BadRequest(c, err.Error())
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		models.Error(c, 404, "Target not found")
+	case errors.Is(err, common.ErrInvalidStatus):
+		models.Error(c, 409, err.Error())
+	default:
+		models.Error(c, 500, message+": "+err.Error())
+	}
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/models"
+	"github.com/atopos31/stoz/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type UserHandler struct {
+	authSvc *service.AuthService
+}
+
+func NewUserHandler() *UserHandler {
+	return &UserHandler{
+		authSvc: service.GetAuthService(),
+	}
+}
+
+func (h *UserHandler) ListUsers(c *gin.Context) {
+	users, err := h.authSvc.ListUsers()
+	if err != nil {
+		common.Errorf("Failed to list users: %v", err)
+		models.Error(c, 500, "Failed to list users: "+err.Error())
+		return
+	}
+
+	models.Success(c, gin.H{
+		"users": users,
+		"total": len(users),
+	})
+}
+
+func (h *UserHandler) CreateUser(c *gin.Context) {
+	var req service.UserSpec
+	if err := c.ShouldBindJSON(&req); err != nil {
+		models.BadRequest(c, "Invalid request: "+err.Error())
+		return
+	}
+
+	user, err := h.authSvc.CreateUser(req)
+	audit(c, &models.AuditLog{Action: "user.create", ResourceType: "user", ResourceID: req.Username, Detail: "role " + req.Role}, err)
+	if err != nil {
+		h.handleError(c, "Failed to create user", err)
+		return
+	}
+
+	models.Success(c, user)
+}
+
+func (h *UserHandler) UpdateUser(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("userId"), 10, 64)
+	if err != nil {
+		models.BadRequest(c, "Invalid user ID")
+		return
+	}
+
+	var req service.UserSpec
+	if err := c.ShouldBindJSON(&req); err != nil {
+		models.BadRequest(c, "Invalid request: "+err.Error())
+		return
+	}
+
+	user, err := h.authSvc.UpdateUser(uint(userID), req)
+	audit(c, &models.AuditLog{Action: "user.update", ResourceType: "user", ResourceID: c.Param("userId"), Detail: "role " + req.Role}, err)
+	if err != nil {
+		h.handleError(c, "Failed to update user", err)
+		return
+	}
+
+	models.Success(c, user)
+}
+
+func (h *UserHandler) DeleteUser(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("userId"), 10, 64)
+	if err != nil {
+		models.BadRequest(c, "Invalid user ID")
+		return
+	}
+
+	err = h.authSvc.DeleteUser(uint(userID))
+	audit(c, &models.AuditLog{Action: "user.delete", ResourceType: "user", ResourceID: c.Param("userId")}, err)
+	if err != nil {
+		h.handleError(c, "Failed to delete user", err)
+		return
+	}
+
+	models.SuccessWithMessage(c, "User deleted", nil)
+}
+
+func (h *UserHandler) handleError(c *gin.Context, message string, err error) {
+	common.Errorf("%s: %v", message, err)
+	switch {
+	case errors.Is(err, common.ErrInvalidRequest):
+		models.BadRequest(c, err.Error())
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		models.Error(c, 404, "User not found")
+	case errors.Is(err, common.ErrInvalidStatus):
+		models.Error(c, 409, err.Error())
+	default:
+		models.Error(c, 500, message+": "+err.Error())
+	}
+}
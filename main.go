@@ -1,16 +1,24 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"embed"
+	"errors"
+	"fmt"
 	"io/fs"
+	"net"
 	"net/http"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"github.com/atopos31/stoz/common"
 	"github.com/atopos31/stoz/config"
 	"github.com/atopos31/stoz/handler"
 	"github.com/atopos31/stoz/middleware"
 	"github.com/atopos31/stoz/models"
+	"github.com/atopos31/stoz/service"
 	"github.com/atopos31/stoz/worker"
 	"github.com/gin-gonic/gin"
 )
@@ -29,27 +37,151 @@ func main() {
 	common.InitLogger(config.AppConfig.Server.LogLevel)
 	common.Infof("Starting STOZ application on port %s", config.AppConfig.Server.Port)
 
+	if err := common.InitSecurityLog(config.AppConfig.Scan.SecurityLog); err != nil {
+		common.Errorf("Security events will only be logged to stdout: %v", err)
+	}
+
 	if err := models.InitDB(config.AppConfig.Database.Path); err != nil {
 		common.Fatalf("Failed to initialize database: %v", err)
 	}
 	common.Info("Database initialized successfully")
 
-	worker.GetWorkerPool()
+	sec := config.AppConfig.Security
+	if err := common.InitSecrets(sec.SecretKey, sec.SecretKeyFile, sec.OldSecretKeys); err != nil {
+		common.Fatalf("Failed to initialize credential encryption: %v", err)
+	}
+	if err := service.SecureStoredCredentials(); err != nil {
+		common.Errorf("Failed to secure stored credentials: %v", err)
+	}
+
+	if err := service.InitSource(config.AppConfig.Source); err != nil {
+		common.Fatalf("Failed to initialize source: %v", err)
+	}
+	common.Infof("Reading sources from %s", config.AppConfig.Source.Type)
+
+	folderIndexer := service.GetFolderIndexer()
+	folderIndexer.Start()
+
+	recovered, err := service.GetMigrationService().RecoverInterruptedTasks()
+	if err != nil {
+		common.Errorf("Failed to recover interrupted tasks: %v", err)
+	} else if recovered > 0 {
+		common.Infof("Re-queued %d interrupted tasks", recovered)
+	}
+
+	service.GetAuthService().SetSessionTTL(config.AppConfig.Auth.SessionTTL)
+	if required, err := service.GetAuthService().SetupRequired(); err == nil && required {
+		common.Warn("No user account exists yet: open the web UI to create the admin account")
+	}
+
+	workerPool := worker.GetWorkerPool()
 	common.Info("Worker pool initialized")
 
+	scheduler := worker.GetScheduler()
+
 	gin.SetMode(config.AppConfig.Server.GinMode)
 	router := gin.New()
 
 	router.Use(middleware.Recovery())
-	router.Use(middleware.CORS())
-	router.Use(gin.Logger())
+	router.Use(middleware.CORS(config.AppConfig.Auth.AllowedOrigins))
+	router.Use(gin.LoggerWithWriter(common.NewRedactingWriter(gin.DefaultWriter)))
 
 	setupRoutes(router)
 
-	common.Infof("Server listening on :%s", config.AppConfig.Server.Port)
-	if err := router.Run(":" + config.AppConfig.Server.Port); err != nil {
-		common.Fatalf("Failed to start server: %v", err)
+	server := &http.Server{
+		Addr:    ":" + config.AppConfig.Server.Port,
+		Handler: router,
+	}
+
+	var redirectServer *http.Server
+	if config.AppConfig.TLS.Enabled {
+		certFile, keyFile, err := tlsFiles(config.AppConfig.TLS)
+		if err != nil {
+			common.Fatalf("Failed to set up TLS: %v", err)
+		}
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+
+		go func() {
+			common.Infof("Server listening on :%s (HTTPS)", config.AppConfig.Server.Port)
+			if err := server.ListenAndServeTLS(certFile, keyFile); err != nil && err != http.ErrServerClosed {
+				common.Fatalf("Failed to start server: %v", err)
+			}
+		}()
+
+		if port := config.AppConfig.TLS.RedirectPort; port != "" {
+			redirectServer = &http.Server{
+				Addr:    ":" + port,
+				Handler: httpsRedirect(config.AppConfig.Server.Port),
+			}
+			go func() {
+				common.Infof("Redirecting HTTP on :%s to HTTPS", port)
+				if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					common.Errorf("HTTP redirect listener failed: %v", err)
+				}
+			}()
+		}
+	} else {
+		go func() {
+			common.Infof("Server listening on :%s", config.AppConfig.Server.Port)
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				common.Fatalf("Failed to start server: %v", err)
+			}
+		}()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	stop()
+
+	common.Infof("Shutting down (timeout %v)", config.AppConfig.Server.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.AppConfig.Server.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		common.Errorf("HTTP server shutdown: %v", err)
+	}
+	if redirectServer != nil {
+		redirectServer.Shutdown(shutdownCtx)
+	}
+	scheduler.Stop()
+	folderIndexer.Stop()
+	if err := workerPool.Shutdown(shutdownCtx); err != nil {
+		common.Warnf("Worker pool shutdown: %v", err)
+	}
+	common.Info("Shutdown complete")
+}
+
+// tlsFiles returns the configured certificate, or a self-signed one from cfg.Dir
+func tlsFiles(cfg config.TLSConfig) (string, string, error) {
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return "", "", errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+		}
+		if _, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile); err != nil {
+			return "", "", fmt.Errorf("invalid certificate: %w", err)
+		}
+		return cfg.CertFile, cfg.KeyFile, nil
 	}
+
+	common.Warn("No TLS certificate configured, using a self-signed certificate")
+	return common.LoadOrCreateSelfSigned(cfg.Dir, cfg.Hostnames)
+}
+
+// httpsRedirect sends plain HTTP requests to the same host on the HTTPS port
+func httpsRedirect(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			host = strings.Trim(host, "[]")
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
 }
 
 func setupRoutes(router *gin.Engine) {
@@ -57,19 +189,68 @@ func setupRoutes(router *gin.Engine) {
 	scanHandler := handler.NewScanHandler()
 	migrationHandler := handler.NewMigrationHandler()
 	discoveryHandler := handler.NewDiscoveryHandler()
+	scheduleHandler := handler.NewScheduleHandler()
+	targetHandler := handler.NewTargetHandler()
+	authHandler := handler.NewAuthHandler()
+	userHandler := handler.NewUserHandler()
+	auditHandler := handler.NewAuditHandler()
+
+	public := router.Group("/api/v1")
+	{
+		public.GET("/health", healthHandler.Health)
+		public.GET("/auth/status", authHandler.Status)
+		public.POST("/auth/setup", authHandler.Setup)
+		public.POST("/auth/login", authHandler.Login)
+	}
 
-	api := router.Group("/api/v1")
+	// Every authenticated user, including viewers (read-only)
+	api := router.Group("/api/v1", middleware.Auth())
 	{
-		api.GET("/health", healthHandler.Health)
+		api.POST("/auth/logout", authHandler.Logout)
+		api.GET("/auth/me", authHandler.Me)
 		api.GET("/scan", scanHandler.Scan)
 		api.POST("/folder/details", scanHandler.GetFolderDetails)
-		api.GET("/discover", discoveryHandler.Discover)
-		api.POST("/zimaos/test", migrationHandler.TestConnection)
-		api.POST("/zimaos/storages", migrationHandler.GetStorageList)
-		api.POST("/migration", migrationHandler.CreateMigration)
+		api.GET("/scan/duplicates/:jobId", scanHandler.GetDedupe)
+		api.GET("/scan/index", scanHandler.GetIndexStatus)
 		api.GET("/migration/:taskId", migrationHandler.GetMigrationStatus)
 		api.GET("/migrations", migrationHandler.ListMigrations)
-		api.POST("/migration/:taskId/cancel", migrationHandler.CancelMigration)
+		api.GET("/targets", targetHandler.ListTargets)
+		api.GET("/targets/:targetId", targetHandler.GetTarget)
+		api.GET("/schedules", scheduleHandler.ListSchedules)
+		api.GET("/schedules/:scheduleId", scheduleHandler.GetSchedule)
+	}
+
+	operator := api.Group("", middleware.RequireRole(models.RoleAdmin, models.RoleOperator))
+	{
+		operator.GET("/discover", discoveryHandler.Discover)
+		operator.POST("/scan/duplicates", scanHandler.StartDedupe)
+		operator.POST("/scan/index/refresh", scanHandler.RefreshIndex)
+		operator.POST("/zimaos/test", migrationHandler.TestConnection)
+		operator.POST("/zimaos/storages", migrationHandler.GetStorageList)
+		operator.POST("/zimaos/browse", migrationHandler.Browse)
+		operator.POST("/zimaos/folders", migrationHandler.CreateFolder)
+		operator.POST("/destinations/test", migrationHandler.TestDestination)
+		operator.POST("/migration", migrationHandler.CreateMigration)
+		operator.POST("/migration/:taskId/cancel", migrationHandler.CancelMigration)
+		operator.PATCH("/migration/:taskId/limits", migrationHandler.UpdateLimits)
+		operator.POST("/migration/:taskId/move", migrationHandler.MoveMigration)
+		operator.POST("/targets/:targetId/test", targetHandler.TestTarget)
+		operator.GET("/targets/:targetId/storages", targetHandler.GetStorages)
+		operator.POST("/schedules", scheduleHandler.CreateSchedule)
+		operator.PUT("/schedules/:scheduleId", scheduleHandler.UpdateSchedule)
+		operator.DELETE("/schedules/:scheduleId", scheduleHandler.DeleteSchedule)
+	}
+
+	admin := api.Group("", middleware.RequireRole(models.RoleAdmin))
+	{
+		admin.POST("/targets", targetHandler.CreateTarget)
+		admin.PUT("/targets/:targetId", targetHandler.UpdateTarget)
+		admin.DELETE("/targets/:targetId", targetHandler.DeleteTarget)
+		admin.GET("/users", userHandler.ListUsers)
+		admin.POST("/users", userHandler.CreateUser)
+		admin.PUT("/users/:userId", userHandler.UpdateUser)
+		admin.DELETE("/users/:userId", userHandler.DeleteUser)
+		admin.GET("/audit", auditHandler.ListAudit)
 	}
 
 	distFS, err := fs.Sub(webFS, "webui/dist/assets")
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/models"
+	"github.com/atopos31/stoz/service"
+	"github.com/gin-gonic/gin"
+)
+
+// SessionCookie is the cookie holding the session token for the web UI
+const SessionCookie = "stoz_session"
+
+const userKey = "user"
+
+// Auth rejects requests without a valid session token, taken from an
+// "Authorization: Bearer" header or the session cookie
+func Auth() gin.HandlerFunc {
+	authSvc := service.GetAuthService()
+
+	return func(c *gin.Context) {
+		user, err := authSvc.Authenticate(SessionToken(c))
+		if err != nil {
+			if !errors.Is(err, common.ErrAuthFailed) {
+				common.Errorf("Failed to authenticate request: %v", err)
+			}
+			models.Unauthorized(c, "Authentication required")
+			return
+		}
+
+		c.Set(userKey, user)
+		c.Next()
+	}
+}
+
+// SessionToken returns the token the request was authenticated with
+func SessionToken(c *gin.Context) string {
+	if header := c.GetHeader("Authorization"); header != "" {
+		if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	if cookie, err := c.Cookie(SessionCookie); err == nil {
+		return cookie
+	}
+	return ""
+}
+
+// CurrentUser returns the authenticated user, or nil on public routes
+func CurrentUser(c *gin.Context) *models.User {
+	if value, ok := c.Get(userKey); ok {
+		if user, ok := value.(*models.User); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// RequireRole only lets users with one of roles through. It must run after Auth.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := CurrentUser(c)
+		if user == nil {
+			models.Unauthorized(c, "Authentication required")
+			return
+		}
+		for _, role := range roles {
+			if user.Role == role {
+				c.Next()
+				return
+			}
+		}
+		models.Forbidden(c, "Your role does not allow this action")
+	}
+}
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// Audit outcomes
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditLog is an append-only record of a user or system action. Updates and
+// deletes are rejected by database triggers created in InitDB.
+type AuditLog struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt    time.Time `gorm:"index" json:"created_at"`
+	Actor        string    `gorm:"index;not null" json:"actor"` // Username, or "system"/"scheduler"
+	ActorID      uint      `json:"actor_id,omitempty"`
+	ClientIP     string    `json:"client_ip,omitempty"`
+	Action       string    `gorm:"index;not null" json:"action"` // e.g. migration.create, task.completed
+	ResourceType string    `json:"resource_type,omitempty"`
+	ResourceID   string    `gorm:"index" json:"resource_id,omitempty"`
+	TargetID     string    `gorm:"index" json:"target_id,omitempty"`
+	TargetHost   string    `gorm:"index" json:"target_host,omitempty"`
+	Outcome      string    `gorm:"not null" json:"outcome"`
+	Detail       string    `gorm:"type:text" json:"detail,omitempty"`
+}
+
+// auditTriggers make the audit table append-only at the database level
+var auditTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS audit_logs_no_update BEFORE UPDATE ON audit_logs
+	BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END`,
+	`CREATE TRIGGER IF NOT EXISTS audit_logs_no_delete BEFORE DELETE ON audit_logs
+	BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END`,
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// DuplicateGroup is a set of files with identical content
+type DuplicateGroup struct {
+	Size  int64    `json:"size"`  // Of each file
+	Hash  string   `json:"hash"`  // SHA-256 of the content
+	Paths []string `json:"paths"` // The first is kept, the others are duplicates
+}
+
+type DedupeReport struct {
+	Groups         []DuplicateGroup `json:"groups"`
+	ScannedFiles   int              `json:"scanned_files"`
+	ScannedSize    int64            `json:"scanned_size"`
+	DuplicateFiles int              `json:"duplicate_files"` // Files beyond the first of each group
+	WastedSize     int64            `json:"wasted_size"`     // Bytes those files take
+}
+
+// DedupeJob is a duplicate analysis running in the background. Jobs are kept
+// in memory only.
+type DedupeJob struct {
+	JobID          string        `json:"job_id"`
+	Status         string        `json:"status"` // running, completed or failed
+	Paths          []string      `json:"paths"`
+	IncludeRecycle bool          `json:"include_recycle"`
+	MinSize        int64         `json:"min_size"`
+	HashedSize     int64         `json:"hashed_size"` // Bytes read so far
+	Report         *DedupeReport `json:"report,omitempty"`
+	Error          string        `json:"error,omitempty"`
+	StartedAt      time.Time     `json:"started_at"`
+	CompletedAt    *time.Time    `json:"completed_at,omitempty"`
+}
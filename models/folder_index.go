@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// FolderIndex is the stored size of a folder, kept up to date by the folder
+// indexer. Files in #recycle are counted apart, so both totals can be shown.
+type FolderIndex struct {
+	ID           uint      `gorm:"primaryKey" json:"-"`
+	Path         string    `gorm:"uniqueIndex;not null" json:"path"`
+	Size         int64     `gorm:"default:0" json:"size"`
+	FileCount    int       `gorm:"default:0" json:"file_count"`
+	RecycleSize  int64     `gorm:"default:0" json:"recycle_size"`
+	RecycleFiles int       `gorm:"default:0" json:"recycle_files"`
+	IndexedAt    time.Time `gorm:"index" json:"indexed_at"`
+	DurationMs   int64     `gorm:"default:0" json:"duration_ms"` // How long the walk took
+}
@@ -8,6 +8,7 @@ type FolderInfo struct {
 	Size         int64        `json:"size"`
 	FileCount    int          `json:"file_count"`
 	ModifiedTime time.Time    `json:"modified_time"`
+	IndexedAt    *time.Time   `json:"indexed_at,omitempty"` // When Size and FileCount were computed, nil until the indexer got to the folder
 	Children     []FolderInfo `json:"children,omitempty"`
 }
 
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/glebarez/sqlite"
@@ -10,26 +11,53 @@ import (
 var DB *gorm.DB
 
 type MigrationTask struct {
-	ID              uint       `gorm:"primaryKey" json:"id"`
-	TaskID          string     `gorm:"uniqueIndex;not null" json:"task_id"`
-	Status          string     `gorm:"index;not null" json:"status"`
-	Error           string     `gorm:"type:text" json:"error"`
-	SourceFolders   string     `gorm:"type:text;not null" json:"source_folders"`
-	ZimaOSHost      string     `gorm:"not null" json:"zimaos_host"`
-	ZimaOSUsername  string     `gorm:"not null" json:"zimaos_username"`
-	ZimaOSPassword  string     `gorm:"not null" json:"-"`
-	BasePath        string     `gorm:"not null" json:"base_path"`
-	TotalFiles      int        `gorm:"default:0" json:"total_files"`
-	ProcessedFiles  int        `gorm:"default:0" json:"processed_files"`
-	FailedFiles     int        `gorm:"default:0" json:"failed_files"`
-	TotalSize       int64      `gorm:"default:0" json:"total_size"`
-	TransferredSize int64      `gorm:"default:0" json:"transferred_size"`
-	Progress        float64    `gorm:"default:0" json:"progress"`
-	Options         string     `gorm:"type:text" json:"options"`
-	CreatedAt       time.Time  `json:"created_at"`
-	StartedAt       *time.Time `json:"started_at"`
-	CompletedAt     *time.Time `json:"completed_at"`
-	UpdatedAt       time.Time  `json:"updated_at"`
+	ID              uint    `gorm:"primaryKey" json:"id"`
+	TaskID          string  `gorm:"uniqueIndex;not null" json:"task_id"`
+	Status          string  `gorm:"index;not null" json:"status"`
+	Error           string  `gorm:"type:text" json:"error"`
+	SourceFolders   string  `gorm:"type:text;not null" json:"source_folders"`
+	DestURL         string  `gorm:"not null" json:"dest_url"` // ZimaOS host, WebDAV URL, SFTP host or S3 endpoint
+	DestUsername    string  `gorm:"not null" json:"dest_username"`
+	DestSecret      string  `gorm:"not null" json:"-"` // Encrypted password, SSH key or S3 secret key
+	BasePath        string  `gorm:"not null" json:"base_path"`
+	TotalFiles      int     `gorm:"default:0" json:"total_files"`
+	ProcessedFiles  int     `gorm:"default:0" json:"processed_files"`
+	FailedFiles     int     `gorm:"default:0" json:"failed_files"`
+	TotalSize       int64   `gorm:"default:0" json:"total_size"`
+	TransferredSize int64   `gorm:"default:0" json:"transferred_size"`
+	WireSize        int64   `gorm:"default:0" json:"wire_size"`     // Bytes sent, after compression
+	DedupedFiles    int     `gorm:"default:0" json:"deduped_files"` // Duplicates copied on the destination or skipped
+	DedupedSize     int64   `gorm:"default:0" json:"deduped_size"`  // Bytes of those files, not uploaded
+	Progress        float64 `gorm:"default:0" json:"progress"`
+	Options         string  `gorm:"type:text" json:"options"`
+	ScheduleID      string  `gorm:"index" json:"schedule_id,omitempty"`
+	TargetID        string  `gorm:"index" json:"target_id,omitempty"`   // Credentials come from this target when set
+	Priority        int     `gorm:"default:0;index" json:"priority"`    // Higher runs first
+	QueueOrder      int64   `gorm:"default:0;index" json:"queue_order"` // FIFO order within a priority
+	// Resume point: files before CheckpointIndex are done. CheckpointPath is the
+	// last finished file, used to detect a changed file list on resume.
+	CheckpointIndex       int        `gorm:"default:0" json:"checkpoint_index"`
+	CheckpointPath        string     `gorm:"type:text" json:"-"`
+	CheckpointTransferred int64      `gorm:"default:0" json:"-"`
+	DestinationType       string     `gorm:"default:zimaos" json:"destination_type"`         // zimaos, local, webdav, sftp or s3
+	DestinationOptions    string     `gorm:"type:text" json:"destination_options,omitempty"` // service.DestinationOptions (JSON)
+	SourceTargetID        string     `gorm:"index" json:"source_target_id,omitempty"`        // Read from this ZimaOS target instead of the Synology
+	CreatedAt             time.Time  `json:"created_at"`
+	StartedAt             *time.Time `json:"started_at"`
+	CompletedAt           *time.Time `json:"completed_at"`
+	UpdatedAt             time.Time  `json:"updated_at"`
+}
+
+// MarshalJSON also writes the destination under its old names, zimaos_host
+// and zimaos_username, for API clients written before other destinations
+// existed. The old names are deprecated in favour of dest_url and dest_username.
+func (t MigrationTask) MarshalJSON() ([]byte, error) {
+	type task MigrationTask // Without this method
+	return json.Marshal(struct {
+		task
+		ZimaOSHost     string `json:"zimaos_host"`
+		ZimaOSUsername string `json:"zimaos_username"`
+	}{task(t), t.DestURL, t.DestUsername})
 }
 
 type ErrorLog struct {
@@ -49,7 +77,44 @@ func InitDB(dbPath string) error {
 		return err
 	}
 
-	return DB.AutoMigrate(&MigrationTask{}, &ErrorLog{})
+	if err := renameTaskColumns(); err != nil {
+		return err
+	}
+	if err := DB.AutoMigrate(&MigrationTask{}, &ErrorLog{}, &Schedule{}, &Target{}, &User{}, &Session{}, &AuditLog{}, &FolderIndex{}); err != nil {
+		return err
+	}
+
+	for _, trigger := range auditTriggers {
+		if err := DB.Exec(trigger).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renamedTaskColumns maps columns of migration_tasks named after ZimaOS, from
+// before tasks could upload to other destinations, to their current names
+var renamedTaskColumns = [][2]string{
+	{"zima_os_host", "dest_url"},
+	{"zima_os_username", "dest_username"},
+	{"zima_os_password", "dest_secret"},
+}
+
+// renameTaskColumns moves existing task destinations into the neutral columns
+// before AutoMigrate would add them empty
+func renameTaskColumns() error {
+	migrator := DB.Migrator()
+	if !migrator.HasTable(&MigrationTask{}) {
+		return nil
+	}
+	for _, column := range renamedTaskColumns {
+		if migrator.HasColumn(&MigrationTask{}, column[0]) && !migrator.HasColumn(&MigrationTask{}, column[1]) {
+			if err := migrator.RenameColumn(&MigrationTask{}, column[0], column[1]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 const (
@@ -61,3 +126,8 @@ const (
 	StatusFailed    = "failed"
 	StatusCancelled = "cancelled"
 )
+
+// IsTerminalStatus reports whether a task in this status will never run again
+func IsTerminalStatus(status string) bool {
+	return status == StatusCompleted || status == StatusFailed || status == StatusCancelled
+}
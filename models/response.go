@@ -50,6 +50,20 @@ func BadRequest(c *gin.Context, message string) {
 	})
 }
 
+func Unauthorized(c *gin.Context, message string) {
+	c.AbortWithStatusJSON(http.StatusUnauthorized, Response{
+		Code:    http.StatusUnauthorized,
+		Message: message,
+	})
+}
+
+func Forbidden(c *gin.Context, message string) {
+	c.AbortWithStatusJSON(http.StatusForbidden, Response{
+		Code:    http.StatusForbidden,
+		Message: message,
+	})
+}
+
 func InternalError(c *gin.Context, message string) {
 	c.JSON(http.StatusInternalServerError, Response{
 		Code:    http.StatusInternalServerError,
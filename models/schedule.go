@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Schedule is a recurring or one-off migration job. Each run creates a new
+// MigrationTask from the stored template fields.
+type Schedule struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	ScheduleID     string     `gorm:"uniqueIndex;not null" json:"schedule_id"`
+	Name           string     `gorm:"not null" json:"name"`
+	CronExpr       string     `gorm:"not null" json:"cron_expr"`
+	Enabled        bool       `gorm:"index;not null" json:"enabled"`
+	Once           bool       `gorm:"default:false" json:"once"` // Disable after the first run
+	SourceFolders  string     `gorm:"type:text;not null" json:"source_folders"`
+	TargetID       string     `gorm:"index" json:"target_id,omitempty"`
+	ZimaOSHost     string     `gorm:"not null" json:"zimaos_host"`
+	ZimaOSUsername string     `gorm:"not null" json:"zimaos_username"`
+	ZimaOSPassword string     `gorm:"not null" json:"-"`
+	BasePath       string     `gorm:"not null" json:"base_path"`
+	Options        string     `gorm:"type:text" json:"options"`
+	Priority       int        `gorm:"default:0" json:"priority"`
+	LastTaskID     string     `json:"last_task_id"`
+	LastRunAt      *time.Time `json:"last_run_at"`
+	LastSkippedAt  *time.Time `json:"last_skipped_at"`
+	NextRunAt      *time.Time `gorm:"index" json:"next_run_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
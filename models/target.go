@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Target is a saved ZimaOS connection profile. Tasks and schedules that
+// reference a target use its current credentials instead of their own copy.
+type Target struct {
+	ID                uint       `gorm:"primaryKey" json:"id"`
+	TargetID          string     `gorm:"uniqueIndex;not null" json:"target_id"`
+	Name              string     `gorm:"not null" json:"name"`
+	ZimaOSHost        string     `gorm:"not null" json:"zimaos_host"`
+	ZimaOSUsername    string     `gorm:"not null" json:"zimaos_username"`
+	ZimaOSPassword    string     `gorm:"not null" json:"-"` // Encrypted
+	BasePath          string     `json:"base_path"`         // Default destination for new tasks
+	TLSCACert         string     `gorm:"type:text" json:"tls_ca_cert"`
+	TLSFingerprint    string     `json:"tls_fingerprint"` // Pinned SHA-256 of the server certificate
+	TLSInsecure       bool       `json:"tls_insecure_skip_verify"`
+	Storages          string     `gorm:"type:text" json:"storages"` // Last known storage list (JSON)
+	StoragesUpdatedAt *time.Time `json:"storages_updated_at"`
+	LastTestedAt      *time.Time `json:"last_tested_at"`
+	LastTestError     string     `gorm:"type:text" json:"last_test_error"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
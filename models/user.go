@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// User roles
+const (
+	RoleAdmin    = "admin"    // Everything, including users and targets
+	RoleOperator = "operator" // Scan and run migrations within the allowed paths
+	RoleViewer   = "viewer"   // Read-only access to scans and task progress
+)
+
+// User is a local account for the web UI and API
+type User struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	Username     string     `gorm:"uniqueIndex;not null" json:"username"`
+	PasswordHash string     `gorm:"not null" json:"-"`                  // bcrypt
+	Role         string     `gorm:"not null;default:admin" json:"role"` // Pre-existing accounts were all set up as admins
+	AllowedPaths string     `gorm:"type:text" json:"allowed_paths"`     // Source paths a non-admin may use (JSON array), empty = all
+	LastLoginAt  *time.Time `json:"last_login_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// Session is a login session. Only the SHA-256 of the token is stored, so a
+// leaked database does not leak usable tokens.
+type Session struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	TokenHash  string    `gorm:"uniqueIndex;not null" json:"-"`
+	UserID     uint      `gorm:"index;not null" json:"user_id"`
+	ExpiresAt  time.Time `gorm:"index;not null" json:"expires_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/config"
+	"github.com/atopos31/stoz/models"
+)
+
+// AllowedPaths returns the source paths user is restricted to, or nil if
+// the user may access every path
+func AllowedPaths(user *models.User) []string {
+	if user == nil || user.Role == models.RoleAdmin || user.AllowedPaths == "" {
+		return nil
+	}
+
+	var paths []string
+	if err := json.Unmarshal([]byte(user.AllowedPaths), &paths); err != nil {
+		common.Errorf("Invalid allowed paths for user %s: %v", user.Username, err)
+		// Fail closed: a broken restriction must not grant access to everything
+		return []string{}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	return paths
+}
+
+// NormalizeAllowedPath resolves a configured path like "/volume1/photos" to
+// its real location under the host mount, matching resolved source paths.
+// Network sources use their own paths unchanged.
+func NormalizeAllowedPath(path string) string {
+	path = filepath.Clean("/" + strings.TrimSpace(path))
+	if !IsLocalSource() {
+		return path
+	}
+	hostPath := filepath.Clean(config.AppConfig.Scan.HostPath)
+	if rel, err := filepath.Rel(hostPath, path); err == nil && !strings.HasPrefix(rel, "..") {
+		path = "/" + rel
+	}
+	return filepath.Join(hostRoot(), path)
+}
+
+// pathWithin reports whether path is base or inside it
+func pathWithin(path, base string) bool {
+	path = filepath.Clean(path)
+	base = filepath.Clean(base)
+	return path == base || strings.HasPrefix(path, strings.TrimSuffix(base, "/")+"/")
+}
+
+// CanAccessPath reports whether user may see and migrate path
+func CanAccessPath(user *models.User, path string) bool {
+	allowed := AllowedPaths(user)
+	if allowed == nil {
+		return true
+	}
+	for _, base := range allowed {
+		if pathWithin(path, NormalizeAllowedPath(base)) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccessTask reports whether every source folder of task is visible to user
+func CanAccessTask(user *models.User, task *models.MigrationTask) bool {
+	return canAccessSources(user, task.SourceFolders)
+}
+
+// CanAccessSchedule reports whether every source folder of schedule is visible to user
+func CanAccessSchedule(user *models.User, schedule *models.Schedule) bool {
+	return canAccessSources(user, schedule.SourceFolders)
+}
+
+func canAccessSources(user *models.User, sourceFoldersJSON string) bool {
+	if AllowedPaths(user) == nil {
+		return true
+	}
+
+	var sourceFolders []string
+	if err := json.Unmarshal([]byte(sourceFoldersJSON), &sourceFolders); err != nil {
+		return false
+	}
+	for _, folder := range sourceFolders {
+		if !CanAccessPath(user, folder) {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterScanResult returns the part of result visible to user. Volumes that
+// only contain an allowed share are kept with just that share.
+func FilterScanResult(user *models.User, result *models.ScanResult) *models.ScanResult {
+	if AllowedPaths(user) == nil {
+		return result
+	}
+
+	filtered := &models.ScanResult{ScannedAt: result.ScannedAt}
+	for _, volume := range result.Volumes {
+		var folders []models.FolderInfo
+		for _, folder := range volume.Folders {
+			if CanAccessPath(user, folder.Path) {
+				folders = append(folders, folder)
+			}
+		}
+		if len(folders) > 0 {
+			filteredVolume := volume
+			filteredVolume.Folders = folders
+			filtered.Volumes = append(filtered.Volumes, filteredVolume)
+		}
+	}
+	return filtered
+}
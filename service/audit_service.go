@@ -0,0 +1,114 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/models"
+)
+
+// Actors for entries not caused by a user request
+const (
+	AuditActorSystem    = "system"
+	AuditActorScheduler = "scheduler"
+)
+
+type AuditService struct{}
+
+// AuditFilter selects audit entries; zero values match everything
+type AuditFilter struct {
+	Actor      string
+	Action     string // Exact action, or a prefix ending in "." such as "migration."
+	ResourceID string
+	Target     string // Target ID or host
+	Outcome    string
+	Since      *time.Time
+	Until      *time.Time
+	Limit      int // 0 = no limit
+	Offset     int
+}
+
+var auditService *AuditService
+var auditOnce sync.Once
+
+func GetAuditService() *AuditService {
+	auditOnce.Do(func() {
+		auditService = &AuditService{}
+	})
+	return auditService
+}
+
+// Record appends an entry. Failures are logged but never fail the audited action.
+func (s *AuditService) Record(entry *models.AuditLog) {
+	if entry.Outcome == "" {
+		entry.Outcome = models.AuditSuccess
+	}
+	entry.Detail = common.Redact(entry.Detail)
+	if err := models.DB.Create(entry).Error; err != nil {
+		common.Errorf("Failed to write audit entry %s for %s: %v", entry.Action, entry.ResourceID, err)
+	}
+}
+
+// RecordTask appends a system entry about a task
+func (s *AuditService) RecordTask(actor, action string, task *models.MigrationTask, err error) {
+	entry := &models.AuditLog{
+		Actor:        actor,
+		Action:       action,
+		ResourceType: "task",
+		ResourceID:   task.TaskID,
+		TargetID:     task.TargetID,
+		TargetHost:   task.DestURL,
+	}
+	if err != nil {
+		entry.Outcome = models.AuditFailure
+		entry.Detail = err.Error()
+	}
+	s.Record(entry)
+}
+
+// List returns matching entries, newest first, and the total match count
+func (s *AuditService) List(filter AuditFilter) ([]*models.AuditLog, int64, error) {
+	query := models.DB.Model(&models.AuditLog{})
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		if filter.Action[len(filter.Action)-1] == '.' {
+			query = query.Where("action LIKE ?", filter.Action+"%")
+		} else {
+			query = query.Where("action = ?", filter.Action)
+		}
+	}
+	if filter.ResourceID != "" {
+		query = query.Where("resource_id = ?", filter.ResourceID)
+	}
+	if filter.Target != "" {
+		query = query.Where("target_id = ? OR target_host = ?", filter.Target, filter.Target)
+	}
+	if filter.Outcome != "" {
+		query = query.Where("outcome = ?", filter.Outcome)
+	}
+	if filter.Since != nil {
+		query = query.Where("created_at >= ?", *filter.Since)
+	}
+	if filter.Until != nil {
+		query = query.Where("created_at < ?", *filter.Until)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	query = query.Order("created_at desc, id desc").Offset(filter.Offset)
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var entries []*models.AuditLog
+	if err := query.Find(&entries).Error; err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
@@ -0,0 +1,342 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const minPasswordLength = 8
+
+// dummyHash is compared against when a username does not exist, so unknown
+// and known users take the same time to reject
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("stoz-dummy-password"), bcrypt.DefaultCost)
+
+// UserSpec holds the admin-editable fields of a user
+type UserSpec struct {
+	Username     string   `json:"username" binding:"required"`
+	Password     string   `json:"password"` // Optional on update: keeps the current password
+	Role         string   `json:"role" binding:"required"`
+	AllowedPaths []string `json:"allowed_paths"` // Ignored for admins
+}
+
+type AuthService struct {
+	sessionTTL time.Duration
+	setupMu    sync.Mutex
+}
+
+var authService *AuthService
+var authOnce sync.Once
+
+func GetAuthService() *AuthService {
+	authOnce.Do(func() {
+		authService = &AuthService{
+			sessionTTL: 7 * 24 * time.Hour,
+		}
+	})
+	return authService
+}
+
+// SetSessionTTL sets the lifetime of new sessions
+func (s *AuthService) SetSessionTTL(ttl time.Duration) {
+	if ttl > 0 {
+		s.sessionTTL = ttl
+	}
+}
+
+// SetupRequired reports whether no account exists yet
+func (s *AuthService) SetupRequired() (bool, error) {
+	var count int64
+	if err := models.DB.Model(&models.User{}).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count == 0, nil
+}
+
+// Setup creates the first admin account. It fails once any account exists.
+func (s *AuthService) Setup(username, password string) (*models.User, error) {
+	s.setupMu.Lock()
+	defer s.setupMu.Unlock()
+
+	required, err := s.SetupRequired()
+	if err != nil {
+		return nil, err
+	}
+	if !required {
+		return nil, fmt.Errorf("%w: setup has already been completed", common.ErrInvalidStatus)
+	}
+
+	user, err := s.CreateUser(UserSpec{Username: username, Password: password, Role: models.RoleAdmin})
+	if err != nil {
+		return nil, err
+	}
+	common.Infof("Initial admin account %q created", user.Username)
+	return user, nil
+}
+
+func (s *AuthService) CreateUser(spec UserSpec) (*models.User, error) {
+	if spec.Password == "" {
+		return nil, fmt.Errorf("%w: password is required", common.ErrInvalidRequest)
+	}
+
+	user := &models.User{}
+	if err := s.apply(user, spec); err != nil {
+		return nil, err
+	}
+
+	if err := models.DB.Create(user).Error; err != nil {
+		if isUniqueViolation(err) {
+			return nil, fmt.Errorf("%w: username %q is taken", common.ErrInvalidRequest, user.Username)
+		}
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	common.Infof("Created %s account %q", user.Role, user.Username)
+	return user, nil
+}
+
+// UpdateUser changes a user. Changing the password or role ends the user's sessions.
+func (s *AuthService) UpdateUser(userID uint, spec UserSpec) (*models.User, error) {
+	user, err := s.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Role == models.RoleAdmin && spec.Role != models.RoleAdmin {
+		if err := s.ensureOtherAdmin(user.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	oldRole := user.Role
+	if err := s.apply(user, spec); err != nil {
+		return nil, err
+	}
+
+	if err := models.DB.Save(user).Error; err != nil {
+		if isUniqueViolation(err) {
+			return nil, fmt.Errorf("%w: username %q is taken", common.ErrInvalidRequest, user.Username)
+		}
+		return nil, fmt.Errorf("failed to update user: %w", err)
+	}
+
+	if spec.Password != "" || oldRole != user.Role {
+		models.DB.Where("user_id = ?", user.ID).Delete(&models.Session{})
+	}
+	return user, nil
+}
+
+func (s *AuthService) apply(user *models.User, spec UserSpec) error {
+	username := strings.TrimSpace(spec.Username)
+	if username == "" {
+		return fmt.Errorf("%w: username is required", common.ErrInvalidRequest)
+	}
+	switch spec.Role {
+	case models.RoleAdmin, models.RoleOperator, models.RoleViewer:
+	default:
+		return fmt.Errorf("%w: role must be admin, operator or viewer", common.ErrInvalidRequest)
+	}
+
+	var allowedPaths []string
+	for _, path := range spec.AllowedPaths {
+		if strings.TrimSpace(path) != "" {
+			allowedPaths = append(allowedPaths, NormalizeAllowedPath(path))
+		}
+	}
+	user.AllowedPaths = ""
+	if len(allowedPaths) > 0 && spec.Role != models.RoleAdmin {
+		pathsJSON, err := json.Marshal(allowedPaths)
+		if err != nil {
+			return fmt.Errorf("failed to marshal allowed paths: %w", err)
+		}
+		user.AllowedPaths = string(pathsJSON)
+	}
+
+	if spec.Password != "" {
+		hash, err := hashPassword(spec.Password)
+		if err != nil {
+			return err
+		}
+		user.PasswordHash = hash
+	}
+
+	user.Username = username
+	user.Role = spec.Role
+	return nil
+}
+
+func (s *AuthService) GetUser(userID uint) (*models.User, error) {
+	var user models.User
+	if err := models.DB.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *AuthService) ListUsers() ([]*models.User, error) {
+	var users []*models.User
+	if err := models.DB.Order("username asc").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// DeleteUser removes a user and their sessions. The last admin cannot be removed.
+func (s *AuthService) DeleteUser(userID uint) error {
+	user, err := s.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if user.Role == models.RoleAdmin {
+		if err := s.ensureOtherAdmin(user.ID); err != nil {
+			return err
+		}
+	}
+
+	return models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.Session{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(user).Error; err != nil {
+			return err
+		}
+		common.Infof("Deleted account %q", user.Username)
+		return nil
+	})
+}
+
+// ensureOtherAdmin fails if userID is the only admin
+func (s *AuthService) ensureOtherAdmin(userID uint) error {
+	var admins int64
+	if err := models.DB.Model(&models.User{}).Where("role = ? AND id <> ?", models.RoleAdmin, userID).Count(&admins).Error; err != nil {
+		return err
+	}
+	if admins == 0 {
+		return fmt.Errorf("%w: at least one admin account must remain", common.ErrInvalidStatus)
+	}
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	return errors.Is(err, gorm.ErrDuplicatedKey) || strings.Contains(err.Error(), "UNIQUE")
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("%w: password must be at least %d characters", common.ErrInvalidRequest, minPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// Login checks the credentials and opens a session. The returned token is
+// only ever shown to the client; the database keeps its hash.
+func (s *AuthService) Login(username, password string) (string, *models.Session, *models.User, error) {
+	var user models.User
+	err := models.DB.Where("username = ?", strings.TrimSpace(username)).First(&user).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil, nil, err
+	}
+
+	hash := dummyHash
+	if err == nil {
+		hash = []byte(user.PasswordHash)
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || user.ID == 0 {
+		return "", nil, nil, common.ErrAuthFailed
+	}
+
+	token, session, err := s.createSession(user.ID)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	now := time.Now()
+	user.LastLoginAt = &now
+	models.DB.Model(&user).Update("last_login_at", now)
+
+	common.Infof("User %q logged in", user.Username)
+	return token, session, &user, nil
+}
+
+func (s *AuthService) createSession(userID uint) (string, *models.Session, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, fmt.Errorf("failed to generate session token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	now := time.Now()
+	session := &models.Session{
+		TokenHash:  hashToken(token),
+		UserID:     userID,
+		ExpiresAt:  now.Add(s.sessionTTL),
+		LastSeenAt: now,
+	}
+	if err := models.DB.Create(session).Error; err != nil {
+		return "", nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	// Opportunistically drop expired sessions
+	models.DB.Where("expires_at < ?", now).Delete(&models.Session{})
+	return token, session, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticate resolves a session token to its user
+func (s *AuthService) Authenticate(token string) (*models.User, error) {
+	if token == "" {
+		return nil, common.ErrAuthFailed
+	}
+
+	var session models.Session
+	if err := models.DB.Where("token_hash = ?", hashToken(token)).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, common.ErrAuthFailed
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if now.After(session.ExpiresAt) {
+		models.DB.Delete(&session)
+		return nil, common.ErrAuthFailed
+	}
+
+	var user models.User
+	if err := models.DB.First(&user, session.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, common.ErrAuthFailed
+		}
+		return nil, err
+	}
+
+	// Avoid a write on every request
+	if now.Sub(session.LastSeenAt) > time.Minute {
+		models.DB.Model(&session).Update("last_seen_at", now)
+	}
+	return &user, nil
+}
+
+// Logout ends the session of token
+func (s *AuthService) Logout(token string) error {
+	return models.DB.Where("token_hash = ?", hashToken(token)).Delete(&models.Session{}).Error
+}
@@ -0,0 +1,349 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/config"
+)
+
+// BandwidthRule limits throughput between two wall-clock times (HH:MM, local time).
+// A rule whose start is after its end wraps around midnight.
+type BandwidthRule struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+	Limit int64  `json:"limit"` // Bytes per second, 0 = unlimited
+}
+
+// BandwidthPolicy describes a default limit plus optional time-of-day overrides.
+// The first matching schedule rule wins; otherwise Limit applies.
+type BandwidthPolicy struct {
+	Limit    int64           `json:"limit"` // Bytes per second, 0 = unlimited
+	Schedule []BandwidthRule `json:"schedule,omitempty"`
+}
+
+// Validate checks that all rules have well-formed times and non-negative limits
+func (p BandwidthPolicy) Validate() error {
+	if p.Limit < 0 {
+		return fmt.Errorf("limit must not be negative")
+	}
+	for i, rule := range p.Schedule {
+		if _, err := parseClock(rule.Start); err != nil {
+			return fmt.Errorf("schedule[%d]: invalid start: %w", i, err)
+		}
+		if _, err := parseClock(rule.End); err != nil {
+			return fmt.Errorf("schedule[%d]: invalid end: %w", i, err)
+		}
+		if rule.Limit < 0 {
+			return fmt.Errorf("schedule[%d]: limit must not be negative", i)
+		}
+	}
+	return nil
+}
+
+// LimitAt returns the limit in effect at the given time
+func (p BandwidthPolicy) LimitAt(t time.Time) int64 {
+	minute := t.Hour()*60 + t.Minute()
+	for _, rule := range p.Schedule {
+		start, err := parseClock(rule.Start)
+		if err != nil {
+			continue
+		}
+		end, err := parseClock(rule.End)
+		if err != nil {
+			continue
+		}
+
+		if start <= end {
+			if minute >= start && minute < end {
+				return rule.Limit
+			}
+		} else if minute >= start || minute < end {
+			// Wraps around midnight, e.g. 19:00-08:00
+			return rule.Limit
+		}
+	}
+	return p.Limit
+}
+
+// parseClock converts HH:MM into minutes since midnight
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("expected HH:MM, got %q", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// ParseBandwidthSchedule parses a schedule such as "08:00-19:00=20MB;19:00-08:00=0".
+// Rates accept a plain byte count or a KB/MB/GB suffix (per second).
+func ParseBandwidthSchedule(value string) ([]BandwidthRule, error) {
+	var rules []BandwidthRule
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		window, rate, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid schedule entry %q: missing '='", entry)
+		}
+		start, end, ok := strings.Cut(window, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid schedule entry %q: missing '-'", entry)
+		}
+		limit, err := ParseRate(rate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule entry %q: %w", entry, err)
+		}
+
+		rules = append(rules, BandwidthRule{
+			Start: strings.TrimSpace(start),
+			End:   strings.TrimSpace(end),
+			Limit: limit,
+		})
+	}
+
+	policy := BandwidthPolicy{Schedule: rules}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// ParseRate parses a byte rate such as "1048576", "512KB" or "20MB"
+func ParseRate(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	value = strings.TrimSuffix(value, "/S")
+
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		factor int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(value, unit.suffix) {
+			multiplier = unit.factor
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 || math.IsNaN(number) || math.IsInf(number, 0) {
+		return 0, fmt.Errorf("invalid rate %q", value)
+	}
+	rate := number * float64(multiplier)
+	if rate >= math.MaxInt64 {
+		return 0, fmt.Errorf("rate %q is too large", value)
+	}
+	return int64(rate), nil
+}
+
+// BandwidthLimiter is a token bucket whose rate follows a BandwidthPolicy.
+// The bucket holds at most one second worth of tokens.
+type BandwidthLimiter struct {
+	mu     sync.Mutex
+	policy BandwidthPolicy
+	rate   int64
+	tokens float64
+	last   time.Time
+	sent   atomic.Int64
+}
+
+func NewBandwidthLimiter(policy BandwidthPolicy) *BandwidthLimiter {
+	return &BandwidthLimiter{
+		policy: policy,
+		last:   time.Now(),
+	}
+}
+
+// SetPolicy replaces the policy; the new rate takes effect on the next read
+func (l *BandwidthLimiter) SetPolicy(policy BandwidthPolicy) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.policy = policy
+}
+
+func (l *BandwidthLimiter) Policy() BandwidthPolicy {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.policy
+}
+
+// CurrentLimit returns the rate in effect right now (0 = unlimited)
+func (l *BandwidthLimiter) CurrentLimit() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.policy.LimitAt(time.Now())
+}
+
+// WaitN blocks until n bytes may be sent or the context is cancelled
+func (l *BandwidthLimiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+	l.sent.Add(int64(n))
+
+	l.mu.Lock()
+	now := time.Now()
+	rate := l.policy.LimitAt(now)
+	if rate != l.rate {
+		// Rate changed (schedule boundary or live update): start from an empty bucket
+		l.rate = rate
+		l.tokens = 0
+		l.last = now
+	}
+	if rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+
+	l.tokens += now.Sub(l.last).Seconds() * float64(rate)
+	if l.tokens > float64(rate) {
+		l.tokens = float64(rate)
+	}
+	l.last = now
+
+	// Reserve the tokens up front; a negative balance is paid back by waiting
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / float64(rate) * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Sent returns the number of bytes that went through WaitN
+func (l *BandwidthLimiter) Sent() int64 {
+	return l.sent.Load()
+}
+
+// throttledReader delays reads so that all limiters are respected
+type throttledReader struct {
+	ctx      context.Context
+	reader   io.Reader
+	limiters []*BandwidthLimiter
+}
+
+// maxThrottleChunk keeps individual waits short so rate changes apply promptly
+const maxThrottleChunk = 32 * 1024
+
+// throttleSlices is the number of reads one second of a limiter's rate is
+// cut into. Readers sharing a limiter wait for each other's reads, so at a
+// low rate whole 32KB reads could keep an upload waiting past
+// ZIMAOS_STALL_TIMEOUT; smaller reads keep each wait to a fraction of a
+// second per reader.
+const throttleSlices = 8
+
+// chunkSize returns the largest read that keeps waits short at the current rate
+func (r *throttledReader) chunkSize() int {
+	chunk := int64(maxThrottleChunk)
+	for _, limiter := range r.limiters {
+		if limit := limiter.CurrentLimit(); limit > 0 {
+			chunk = min(chunk, max(limit/throttleSlices, 1))
+		}
+	}
+	return int(chunk)
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if chunk := r.chunkSize(); len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		for _, limiter := range r.limiters {
+			if waitErr := limiter.WaitN(r.ctx, n); waitErr != nil {
+				return n, waitErr
+			}
+		}
+	}
+	return n, err
+}
+
+// BandwidthManager owns the global limiter and the live per-task limiters
+type BandwidthManager struct {
+	global *BandwidthLimiter
+	tasks  sync.Map // taskID -> *BandwidthLimiter
+}
+
+var bandwidthManager *BandwidthManager
+var bandwidthOnce sync.Once
+
+func GetBandwidthManager() *BandwidthManager {
+	bandwidthOnce.Do(func() {
+		var policy BandwidthPolicy
+		if limit, err := ParseRate(config.AppConfig.Bandwidth.Limit); err != nil {
+			common.Errorf("Ignoring invalid BANDWIDTH_LIMIT: %v", err)
+		} else {
+			policy.Limit = limit
+		}
+		if config.AppConfig.Bandwidth.Schedule != "" {
+			rules, err := ParseBandwidthSchedule(config.AppConfig.Bandwidth.Schedule)
+			if err != nil {
+				common.Errorf("Ignoring invalid BANDWIDTH_SCHEDULE: %v", err)
+			} else {
+				policy.Schedule = rules
+			}
+		}
+		bandwidthManager = &BandwidthManager{
+			global: NewBandwidthLimiter(policy),
+		}
+	})
+	return bandwidthManager
+}
+
+// Global returns the limiter shared by all running tasks
+func (m *BandwidthManager) Global() *BandwidthLimiter {
+	return m.global
+}
+
+// AcquireTask registers a limiter for a running task
+func (m *BandwidthManager) AcquireTask(taskID string, policy BandwidthPolicy) *BandwidthLimiter {
+	limiter := NewBandwidthLimiter(policy)
+	m.tasks.Store(taskID, limiter)
+	return limiter
+}
+
+// ReleaseTask removes the limiter of a task that is no longer running
+func (m *BandwidthManager) ReleaseTask(taskID string) {
+	m.tasks.Delete(taskID)
+}
+
+// SetTaskPolicy updates the limiter of a running task.
+// It returns false if the task is not currently running.
+func (m *BandwidthManager) SetTaskPolicy(taskID string, policy BandwidthPolicy) bool {
+	value, ok := m.tasks.Load(taskID)
+	if !ok {
+		return false
+	}
+	value.(*BandwidthLimiter).SetPolicy(policy)
+	return true
+}
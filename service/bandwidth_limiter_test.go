@@ -0,0 +1,179 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/atopos31/stoz/service"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"0", 0},
+		{"1048576", 1048576},
+		{"100B", 100},
+		{"512KB", 512 << 10},
+		{"512kb", 512 << 10},
+		{" 20 MB ", 20 << 20},
+		{"20MB/s", 20 << 20},
+		{"1.5GB", 3 << 29},
+		{"0.5KB", 512},
+	}
+	for _, tt := range tests {
+		got, err := service.ParseRate(tt.value)
+		if err != nil {
+			t.Errorf("ParseRate(%q): %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRate(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+
+	for _, value := range []string{"", "fast", "-1", "-1MB", "10TB", "NaN", "nanMB", "Inf", "+Inf", "-Inf", "infinity", "1e300GB"} {
+		if got, err := service.ParseRate(value); err == nil {
+			t.Errorf("ParseRate(%q) = %d, want an error", value, got)
+		}
+	}
+}
+
+func TestParseBandwidthSchedule(t *testing.T) {
+	rules, err := service.ParseBandwidthSchedule("08:00-19:00=20MB; 19:00-08:00=0;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []service.BandwidthRule{
+		{Start: "08:00", End: "19:00", Limit: 20 << 20},
+		{Start: "19:00", End: "08:00", Limit: 0},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("got %d rules, want %d", len(rules), len(want))
+	}
+	for i := range want {
+		if rules[i] != want[i] {
+			t.Errorf("rule %d = %+v, want %+v", i, rules[i], want[i])
+		}
+	}
+
+	policy := service.BandwidthPolicy{Limit: 1024, Schedule: rules[:1]}
+	day := func(hour, minute int) time.Time { return time.Date(2026, 1, 15, hour, minute, 0, 0, time.Local) }
+	for _, tt := range []struct {
+		at   time.Time
+		want int64
+	}{
+		{day(7, 59), 1024},
+		{day(8, 0), 20 << 20},
+		{day(18, 59), 20 << 20},
+		{day(19, 0), 1024},
+	} {
+		if got := policy.LimitAt(tt.at); got != tt.want {
+			t.Errorf("LimitAt(%s) = %d, want %d", tt.at.Format("15:04"), got, tt.want)
+		}
+	}
+	// Wraps around midnight
+	policy.Schedule = rules[1:]
+	if got := policy.LimitAt(day(23, 0)); got != 0 {
+		t.Errorf("LimitAt(23:00) = %d, want 0", got)
+	}
+	if got := policy.LimitAt(day(12, 0)); got != 1024 {
+		t.Errorf("LimitAt(12:00) = %d, want 1024", got)
+	}
+
+	for _, value := range []string{
+		"08:00-19:00",
+		"08:00=20MB",
+		"8am-19:00=20MB",
+		"08:00-25:00=20MB",
+		"08:00-19:00=fast",
+		"08:00-19:00=NaN",
+		"08:00-19:00=-1",
+	} {
+		if _, err := service.ParseBandwidthSchedule(value); err == nil {
+			t.Errorf("ParseBandwidthSchedule(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestBandwidthLimiterWaitN(t *testing.T) {
+	ctx := context.Background()
+
+	var unlimited *service.BandwidthLimiter
+	if err := unlimited.WaitN(ctx, 1<<20); err != nil {
+		t.Errorf("nil limiter: %v", err)
+	}
+	unlimited = service.NewBandwidthLimiter(service.BandwidthPolicy{})
+	start := time.Now()
+	if err := unlimited.WaitN(ctx, 1<<30); err != nil || time.Since(start) > 100*time.Millisecond {
+		t.Errorf("unlimited: err %v after %v", err, time.Since(start))
+	}
+
+	// 256KB at 512KB/s takes half a second
+	limiter := service.NewBandwidthLimiter(service.BandwidthPolicy{Limit: 512 << 10})
+	start = time.Now()
+	for i := 0; i < 8; i++ {
+		if err := limiter.WaitN(ctx, 32<<10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("256KB at 512KB/s took %v, want about 500ms", elapsed)
+	}
+	if sent := limiter.Sent(); sent != 256<<10 {
+		t.Errorf("Sent = %d, want %d", sent, 256<<10)
+	}
+
+	// Waits end with the context
+	slow := service.NewBandwidthLimiter(service.BandwidthPolicy{Limit: 1})
+	cancelled, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if err := slow.WaitN(cancelled, 1<<20); err == nil {
+		t.Error("wait succeeded although the context was done")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancelled wait took %v", elapsed)
+	}
+}
+
+func TestThrottledReadsStayShortWhenShared(t *testing.T) {
+	// Four readers share 16KB/s. Whole 32KB reads would each wait seconds
+	// behind the others; reads of an eighth of the rate wait at most ~0.5s.
+	limiter := service.NewBandwidthLimiter(service.BandwidthPolicy{Limit: 16 << 10})
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var longest time.Duration
+	var largest int
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reader := service.NewThrottledReader(context.Background(), bytes.NewReader(make([]byte, 8<<10)), limiter)
+			buf := make([]byte, 32<<10)
+			for {
+				start := time.Now()
+				n, err := reader.Read(buf)
+				mu.Lock()
+				longest = max(longest, time.Since(start))
+				largest = max(largest, n)
+				mu.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if largest > 2<<10 {
+		t.Errorf("largest read was %d bytes, want at most %d", largest, 2<<10)
+	}
+	if longest > 1500*time.Millisecond {
+		t.Errorf("longest read took %v", longest)
+	}
+}
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/atopos31/stoz/common"
+)
+
+// Small-file batching packs the small files of one destination directory
+// into a tar archive that is uploaded in a single request. Destinations that
+// implement ArchiveExtractor unpack it in place; the others keep the archive
+// next to a JSON index of its entries.
+
+const (
+	defaultBatchFileSize  = 1 << 20  // 1 MiB
+	maxBatchFileSize      = 16 << 20 // Batched files are read into memory
+	defaultBatchSize      = 64 << 20 // 64 MiB
+	defaultBatchFileCount = 1000
+)
+
+// BatchPolicy enables small-file batching for a task. Zero fields use the defaults.
+type BatchPolicy struct {
+	MaxFileSize   int64 `json:"max_file_size"`   // Files up to this size are batched, default 1 MiB, at most 16 MiB
+	MaxBatchSize  int64 `json:"max_batch_size"`  // Bytes per archive, default 64 MiB
+	MaxBatchFiles int   `json:"max_batch_files"` // Files per archive, default 1000
+}
+
+// Validate checks that no limit is negative and files stay small enough to buffer
+func (p BatchPolicy) Validate() error {
+	if p.MaxFileSize < 0 || p.MaxBatchSize < 0 || p.MaxBatchFiles < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	if p.MaxFileSize > maxBatchFileSize {
+		return fmt.Errorf("max_file_size must not exceed %d bytes", maxBatchFileSize)
+	}
+	return nil
+}
+
+// WithDefaults fills in the unset limits
+func (p BatchPolicy) WithDefaults() BatchPolicy {
+	if p.MaxFileSize == 0 {
+		p.MaxFileSize = defaultBatchFileSize
+	}
+	if p.MaxBatchSize == 0 {
+		p.MaxBatchSize = defaultBatchSize
+	}
+	if p.MaxBatchFiles == 0 {
+		p.MaxBatchFiles = defaultBatchFileCount
+	}
+	return p
+}
+
+// ArchiveExtractor is implemented by destinations that can unpack a batch
+// archive after upload. ExtractArchive extracts the regular files of the tar
+// at archivePath into its directory and removes the archive.
+type ArchiveExtractor interface {
+	ExtractArchive(ctx context.Context, archivePath string) error
+}
+
+// BatchEntry is a file packed into a batch archive
+type BatchEntry struct {
+	Name     string `json:"name"`   // File name in the archive's directory
+	Source   string `json:"source"` // Path on the source
+	Size     int64  `json:"size"`
+	Modified int64  `json:"modified"` // Unix seconds
+	Offset   int64  `json:"offset"`   // Of the file data within the archive
+	MD5      string `json:"md5"`
+}
+
+// BatchIndex describes a batch archive. It is stored next to archives that
+// the destination cannot extract.
+type BatchIndex struct {
+	Archive string       `json:"archive"`
+	Size    int64        `json:"size"` // Of the archive
+	Created int64        `json:"created"`
+	Entries []BatchEntry `json:"entries"`
+}
+
+// Entry returns the entry for a file name, or nil
+func (idx *BatchIndex) Entry(name string) *BatchEntry {
+	for i := range idx.Entries {
+		if idx.Entries[i].Name == name {
+			return &idx.Entries[i]
+		}
+	}
+	return nil
+}
+
+// BatchIndexPath returns where the index of an archive is stored
+func BatchIndexPath(archivePath string) string {
+	return archivePath + ".index.json"
+}
+
+// WriteBatchArchive packs the files at paths on src into a tar written to w.
+// Entries are named after the file's base name. Files that cannot be read or
+// no longer fit maxFileSize are left out and returned in skipped, so the
+// caller can upload them on their own.
+func WriteBatchArchive(ctx context.Context, src Source, archivePath string, paths []string, maxFileSize int64, w io.Writer) (index *BatchIndex, skipped []string, err error) {
+	counter := &countingWriter{writer: w}
+	tw := tar.NewWriter(counter)
+	index = &BatchIndex{Archive: archivePath, Created: time.Now().Unix()}
+
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+
+		data, stat, err := readBatchFile(ctx, src, path, maxFileSize)
+		if err != nil {
+			common.Warnf("Not batching %s: %v", path, err)
+			skipped = append(skipped, path)
+			continue
+		}
+
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     filepath.Base(path),
+			Size:     int64(len(data)),
+			Mode:     0644,
+			ModTime:  stat.ModTime(),
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, nil, fmt.Errorf("failed to write archive header: %w", err)
+		}
+		offset := counter.n
+		if _, err := tw.Write(data); err != nil {
+			return nil, nil, fmt.Errorf("failed to write archive: %w", err)
+		}
+
+		index.Entries = append(index.Entries, BatchEntry{
+			Name:     header.Name,
+			Source:   path,
+			Size:     header.Size,
+			Modified: stat.ModTime().Unix(),
+			Offset:   offset,
+			MD5:      fmt.Sprintf("%x", md5.Sum(data)),
+		})
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, nil, fmt.Errorf("failed to finish archive: %w", err)
+	}
+	index.Size = counter.n
+	return index, skipped, nil
+}
+
+// readBatchFile reads a whole file, which must not be larger than maxFileSize
+func readBatchFile(ctx context.Context, src Source, path string, maxFileSize int64) ([]byte, fs.FileInfo, error) {
+	file, stat, err := openSourceFile(ctx, src, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxFileSize+1))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if int64(len(data)) > maxFileSize {
+		return nil, nil, fmt.Errorf("file grew beyond %d bytes", maxFileSize)
+	}
+	return data, stat, nil
+}
+
+type countingWriter struct {
+	writer io.Writer
+	n      int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// SpoolSource serves registered files of the local filesystem, such as batch
+// archives waiting for upload, in front of a task's source
+type SpoolSource struct {
+	Source
+	mu    sync.Mutex
+	files map[string]bool
+}
+
+func NewSpoolSource(src Source) *SpoolSource {
+	return &SpoolSource{Source: src, files: make(map[string]bool)}
+}
+
+// Add makes a local file readable through the source
+func (s *SpoolSource) Add(path string) {
+	s.mu.Lock()
+	s.files[path] = true
+	s.mu.Unlock()
+}
+
+func (s *SpoolSource) Remove(path string) {
+	s.mu.Lock()
+	delete(s.files, path)
+	s.mu.Unlock()
+}
+
+func (s *SpoolSource) spooled(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.files[path]
+}
+
+func (s *SpoolSource) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	if s.spooled(path) {
+		file, _, err := openSourceFile(ctx, nil, path)
+		return file, err
+	}
+	return s.Source.Open(ctx, path)
+}
+
+func (s *SpoolSource) Stat(path string) (fs.FileInfo, error) {
+	if s.spooled(path) {
+		return os.Stat(path)
+	}
+	return s.Source.Stat(path)
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+// Compression algorithms
+const CompressionGzip = "gzip"
+
+const (
+	defaultCompressMinSize = 4 << 10  // Smaller files gain nothing worth the CPU
+	compressSniffSize      = 64 << 10 // Bytes looked at by the entropy sniff
+	compressMaxEntropy     = 7.0      // Bits per byte; denser data rarely shrinks
+	compressMinSaving      = 0.05     // Send the original if gzip saves less
+)
+
+// CompressionPolicy enables transfer compression for a task. It needs a
+// destination that implements CompressingDestination.
+type CompressionPolicy struct {
+	Algorithm  string `json:"algorithm"`    // Only gzip
+	MinSize    int64  `json:"min_size"`     // Smaller files are sent as is, default 4 KiB
+	GzipAtRest bool   `json:"gzip_at_rest"` // Accepts that compressed files are stored as <name>.gz
+}
+
+// Validate checks the algorithm and size
+func (p CompressionPolicy) Validate() error {
+	if p.Algorithm != CompressionGzip {
+		return fmt.Errorf("algorithm %q is not supported, use %q", p.Algorithm, CompressionGzip)
+	}
+	if p.MinSize < 0 {
+		return fmt.Errorf("min_size must not be negative")
+	}
+	return nil
+}
+
+// CompressingDestination is implemented by destinations that can store
+// compressed uploads under a name that says so, so STOZ and other tools know
+// to decompress them.
+type CompressingDestination interface {
+	SetCompression(policy CompressionPolicy)
+}
+
+var _ CompressingDestination = (*S3Destination)(nil)
+
+// Types that are compressed without sniffing: text and the older,
+// uncompressed office formats
+var compressibleExtensions = map[string]bool{
+	".txt": true, ".csv": true, ".tsv": true, ".log": true, ".md": true, ".rtf": true,
+	".json": true, ".xml": true, ".html": true, ".htm": true, ".css": true, ".js": true,
+	".svg": true, ".sql": true, ".yaml": true, ".yml": true, ".ini": true, ".conf": true,
+	".doc": true, ".xls": true, ".ppt": true, ".bmp": true, ".tif": true, ".tiff": true,
+	".wav": true, ".tar": true,
+}
+
+// Types that are compressed already: media, archives and the zip-based
+// office formats
+var incompressibleExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true, ".heif": true,
+	".mp3": true, ".aac": true, ".m4a": true, ".flac": true, ".ogg": true, ".opus": true,
+	".mp4": true, ".m4v": true, ".mov": true, ".mkv": true, ".avi": true, ".webm": true,
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".7z": true, ".rar": true,
+	".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".ods": true, ".odp": true, ".epub": true,
+}
+
+// ShouldCompress decides by file type, or for unknown types by the Shannon
+// entropy of the first bytes
+func ShouldCompress(name string, head []byte) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	if compressibleExtensions[ext] {
+		return true
+	}
+	if incompressibleExtensions[ext] {
+		return false
+	}
+	return len(head) > 0 && entropy(head) < compressMaxEntropy
+}
+
+// entropy returns the Shannon entropy of data in bits per byte
+func entropy(data []byte) float64 {
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+	var bits float64
+	for _, count := range counts {
+		if count > 0 {
+			p := float64(count) / float64(len(data))
+			bits -= p * math.Log2(p)
+		}
+	}
+	return bits
+}
+
+// uploadSource is a source file opened for upload, possibly compressed
+type uploadSource struct {
+	file     io.ReadCloser // Close when done
+	reader   io.Reader     // Honours ctx and the limiters, reports progress
+	size     int64         // Bytes reader yields, -1 when compressed
+	stat     fs.FileInfo   // Of the original file
+	encoding string        // Content-Encoding of reader, empty when not compressed
+}
+
+// openCompressedUpload is openUploadSource for destinations that can store
+// compressed files. When policy selects the file, reader yields its gzip
+// stream, which is compressed while it is read, so limiters see what goes
+// over the wire. Its size is only known at the end. onProgress counts the
+// bytes of the original file taken by the compressor.
+func openCompressedUpload(ctx context.Context, src Source, localPath string, policy *CompressionPolicy, limiters []*BandwidthLimiter, onProgress func(delta int64)) (*uploadSource, error) {
+	if policy != nil {
+		stream, stat, err := compressFile(ctx, src, localPath, *policy, onProgress)
+		if err != nil {
+			return nil, err
+		}
+		if stream != nil {
+			var reader io.Reader = &cancelableReader{ctx: ctx, reader: stream}
+			if len(limiters) > 0 {
+				reader = &throttledReader{ctx: ctx, reader: reader, limiters: limiters}
+			}
+			return &uploadSource{file: stream, reader: reader, size: -1, stat: stat, encoding: CompressionGzip}, nil
+		}
+	}
+
+	file, reader, stat, err := openUploadSource(ctx, src, localPath, limiters, onProgress)
+	if err != nil {
+		return nil, err
+	}
+	return &uploadSource{file: file, reader: reader, size: stat.Size(), stat: stat}, nil
+}
+
+// compressFile starts gzipping localPath when policy selects it and gzip
+// saves enough on its first bytes. It returns a nil stream when the
+// original should be sent.
+func compressFile(ctx context.Context, src Source, localPath string, policy CompressionPolicy, onProgress func(delta int64)) (*gzipStream, fs.FileInfo, error) {
+	file, stat, err := openSourceFile(ctx, src, localPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	minSize := policy.MinSize
+	if minSize == 0 {
+		minSize = defaultCompressMinSize
+	}
+	if stat.Size() < minSize {
+		file.Close()
+		return nil, stat, nil
+	}
+
+	head := make([]byte, min(stat.Size(), compressSniffSize))
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		file.Close()
+		return nil, nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	head = head[:n]
+	if !ShouldCompress(localPath, head) || !compressesWell(head) {
+		file.Close()
+		return nil, stat, nil
+	}
+
+	reader := &progressReader{
+		reader:     &cancelableReader{ctx: ctx, reader: io.MultiReader(bytes.NewReader(head), file)},
+		onProgress: onProgress,
+	}
+	return newGzipStream(file, reader), stat, nil
+}
+
+// compressesWell reports whether gzip saves enough on sample
+func compressesWell(sample []byte) bool {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(sample)
+	gz.Close()
+	return float64(compressed.Len()) <= float64(len(sample))*(1-compressMinSaving)
+}
+
+// gzipStream yields the gzip compression of a file as it is read. The
+// compressor runs in its own goroutine and stops when the stream is closed.
+type gzipStream struct {
+	file   io.Closer
+	reader *io.PipeReader
+	done   chan struct{}
+}
+
+func newGzipStream(file io.Closer, source io.Reader) *gzipStream {
+	pr, pw := io.Pipe()
+	stream := &gzipStream{file: file, reader: pr, done: make(chan struct{})}
+	go func() {
+		defer close(stream.done)
+		gz := gzip.NewWriter(pw)
+		_, err := io.Copy(gz, source)
+		if err == nil {
+			err = gz.Close()
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to compress file: %w", err)
+		}
+		pw.CloseWithError(err)
+	}()
+	return stream
+}
+
+func (s *gzipStream) Read(p []byte) (int, error) {
+	return s.reader.Read(p)
+}
+
+// Close stops the compressor and waits for it, so no progress is reported
+// after the upload returned
+func (s *gzipStream) Close() error {
+	s.reader.Close()
+	<-s.done
+	return s.file.Close()
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/models"
+)
+
+// purgeCredentials drops the stored password of a finished task
+func (s *MigrationService) purgeCredentials(taskID string) error {
+	return models.DB.Model(&models.MigrationTask{}).
+		Where("task_id = ? AND dest_secret <> ''", taskID).
+		Update("dest_secret", "").Error
+}
+
+// SecureStoredCredentials runs at startup: it purges credentials of finished
+// tasks, encrypts legacy plaintext passwords and re-encrypts passwords sealed
+// with a previous key, so old keys can be retired after one restart.
+func SecureStoredCredentials() error {
+	purged := models.DB.Model(&models.MigrationTask{}).
+		Where("status IN ? AND dest_secret <> ''", []string{models.StatusCompleted, models.StatusFailed, models.StatusCancelled}).
+		Update("dest_secret", "")
+	if purged.Error != nil {
+		return fmt.Errorf("failed to purge task credentials: %w", purged.Error)
+	}
+
+	resealed := 0
+	for _, table := range []struct {
+		model  interface{}
+		column string
+	}{
+		{&models.MigrationTask{}, "dest_secret"},
+		{&models.Schedule{}, "zima_os_password"},
+		{&models.Target{}, "zima_os_password"},
+	} {
+		n, err := resealPasswords(table.model, table.column)
+		resealed += n
+		if err != nil {
+			return err
+		}
+	}
+
+	if purged.RowsAffected > 0 || resealed > 0 {
+		common.Infof("Secured stored credentials: purged %d, encrypted %d", purged.RowsAffected, resealed)
+	}
+	return nil
+}
+
+// credentialRow is the part of a task, schedule or target holding a password
+type credentialRow struct {
+	ID     uint
+	Secret string
+}
+
+// resealPasswords encrypts every password in column of model's table with the
+// current key
+func resealPasswords(model interface{}, column string) (int, error) {
+	var rows []credentialRow
+	if err := models.DB.Model(model).Select("id, " + column + " AS secret").
+		Where(column + " <> ''").Find(&rows).Error; err != nil {
+		return 0, fmt.Errorf("failed to load credentials: %w", err)
+	}
+
+	resealed := 0
+	for _, row := range rows {
+		if !common.NeedsReseal(row.Secret) {
+			continue
+		}
+		password, err := common.ResealSecret(row.Secret)
+		if err != nil {
+			common.Errorf("Failed to re-encrypt credentials of %T %d: %v", model, row.ID, err)
+			continue
+		}
+		if err := models.DB.Model(model).Where("id = ?", row.ID).
+			Update(column, password).Error; err != nil {
+			return resealed, fmt.Errorf("failed to store credentials: %w", err)
+		}
+		resealed++
+	}
+	return resealed, nil
+}
@@ -0,0 +1,126 @@
+package service_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/atopos31/stoz/service"
+)
+
+func TestParseCronRejectsInvalidExpressions(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"30-10 * * * *",
+		"a * * * *",
+		"* * * FOO *",
+		"@often",
+	} {
+		if _, err := service.ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// Thursday
+	from := time.Date(2026, 1, 15, 10, 7, 0, 0, time.UTC)
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", at(1, 15, 10, 8)},
+		{"7 10 * * *", at(1, 16, 10, 7)}, // Strictly after from
+		{"*/15 * * * *", at(1, 15, 10, 15)},
+		{"5/20 * * * *", at(1, 15, 10, 25)},
+		{"0,30 9-17 * * *", at(1, 15, 10, 30)},
+		{"0 8-18/4 * * *", at(1, 15, 12, 0)},
+		{"0 12 20 * *", at(1, 20, 12, 0)},
+		{"0 22 * * FRI", at(1, 16, 22, 0)},
+		{"0 0 * * 7", at(1, 18, 0, 0)}, // 7 is Sunday
+		{"0 9 * jan-mar sun", at(1, 18, 9, 0)},
+		{"0 0 1 JUN *", at(6, 1, 0, 0)},
+		// Both day fields restricted: either may match
+		{"0 12 1 * MON", at(1, 19, 12, 0)},
+		{"0 0 16 * MON", at(1, 16, 0, 0)},
+		// Only one restricted: it alone decides
+		{"0 0 ? * MON", at(1, 19, 0, 0)},
+		{"0 0 1 * ?", at(2, 1, 0, 0)},
+		{"@hourly", at(1, 15, 11, 0)},
+		{"@daily", at(1, 16, 0, 0)},
+		{"@midnight", at(1, 16, 0, 0)},
+		{"@weekly", at(1, 18, 0, 0)},
+		{"@monthly", at(2, 1, 0, 0)},
+		{"@yearly", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"@ANNUALLY", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 29 2 *", time.Date(2028, 2, 29, 12, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}}, // Never
+	}
+	for _, tt := range tests {
+		schedule, err := service.ParseCron(tt.expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := schedule.Next(from); !got.Equal(tt.want) {
+			t.Errorf("%q: Next = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestCronNextAcrossDaylightSaving(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	// Given in UTC: Berlin is UTC+1 in winter and UTC+2 in summer. The clock
+	// jumps from 02:00 to 03:00 on 2026-03-29 and from 03:00 back to 02:00
+	// on 2026-10-25.
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, time.UTC).In(berlin)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"skipped time runs at the end of the gap", "30 2 * * *", at(3, 28, 23, 0), at(3, 29, 1, 0)},
+		{"skipped time runs once", "30 2 * * *", at(3, 29, 1, 0), at(3, 30, 0, 30)},
+		{"skipped minutes run once", "0,30 2 * * *", at(3, 29, 0, 50), at(3, 29, 1, 0)},
+		{"wildcard minutes follow real time", "*/10 2 * * *", at(3, 29, 0, 50), at(3, 30, 0, 0)},
+		{"time before the gap", "30 1 * * *", at(3, 28, 23, 0), at(3, 29, 0, 30)},
+		{"time after the gap", "30 3 * * *", at(3, 28, 23, 0), at(3, 29, 1, 30)},
+		{"wildcard follows real time into the gap", "*/30 * * * *", at(3, 29, 0, 30), at(3, 29, 1, 0)},
+		{"repeated time runs once", "30 2 * * *", at(10, 24, 22, 0), at(10, 25, 1, 30)},
+		{"repeated time does not run again", "30 2 * * *", at(10, 25, 0, 30), at(10, 26, 1, 30)},
+		{"repeated minutes do not run again", "0,30 1,2 * * *", at(10, 25, 0, 30), at(10, 26, 0, 0)},
+		{"time after the repeated hour", "30 3 * * *", at(10, 25, 0, 30), at(10, 25, 2, 30)},
+		{"wildcard runs in the repeated hour", "0 * * * *", at(10, 25, 0, 0), at(10, 25, 1, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := service.ParseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := schedule.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("%q from %v: Next = %v, want %v", tt.expr, tt.from, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/models"
+)
+
+// Content deduplication finds files with identical content in three rounds:
+// files are bucketed by size, then by a hash of their first bytes, and only
+// the files that still collide are hashed in full.
+
+// Dedupe modes
+const (
+	DedupeCopy = "copy" // Upload once, copy on the destination
+	DedupeSkip = "skip" // Upload once, list the others in a manifest
+)
+
+const (
+	defaultDedupeMinSize = 4 << 10  // Smaller duplicates are not worth hashing
+	dedupePartialSize    = 64 << 10 // Bytes hashed in the second round
+)
+
+// DedupePolicy enables deduplication for a task
+type DedupePolicy struct {
+	Mode    string `json:"mode"`     // copy or skip
+	MinSize int64  `json:"min_size"` // Smaller files are always uploaded, default 4 KiB
+}
+
+// Validate checks the mode and size
+func (p DedupePolicy) Validate() error {
+	if p.Mode != DedupeCopy && p.Mode != DedupeSkip {
+		return fmt.Errorf("mode must be %q or %q", DedupeCopy, DedupeSkip)
+	}
+	if p.MinSize < 0 {
+		return fmt.Errorf("min_size must not be negative")
+	}
+	return nil
+}
+
+// DedupeMinSize returns the size below which files are not deduplicated
+func DedupeMinSize(minSize int64) int64 {
+	if minSize <= 0 {
+		return defaultDedupeMinSize
+	}
+	return minSize
+}
+
+// CopyingDestination is implemented by destinations that can copy a file
+// they already store without uploading it again. The copy gets modTime.
+type CopyingDestination interface {
+	CopyFile(ctx context.Context, srcPath, dstPath string, modTime time.Time) error
+}
+
+var (
+	_ CopyingDestination = (*LocalDestination)(nil)
+	_ CopyingDestination = (*S3Destination)(nil)
+)
+
+// DedupeEntry is a duplicate that was not uploaded
+type DedupeEntry struct {
+	Path     string `json:"path"`     // Where it would have been stored
+	Source   string `json:"source"`   // Path on the source
+	Original string `json:"original"` // Stored file with the same content
+	Size     int64  `json:"size"`
+}
+
+// DedupeManifest lists the duplicates a task skipped. It is stored in the
+// task's base path.
+type DedupeManifest struct {
+	TaskID  string        `json:"task_id"`
+	Created int64         `json:"created"`
+	Entries []DedupeEntry `json:"entries"`
+}
+
+// DedupeManifestPath returns where the manifest of a task is stored
+func DedupeManifestPath(basePath, taskID string) string {
+	return filepath.Join(basePath, "stoz-duplicates-"+taskID+".json")
+}
+
+// DedupeFile is a file considered by FindDuplicates
+type DedupeFile struct {
+	Path string
+	Size int64
+}
+
+// FindDuplicates returns the groups of files on src with identical content.
+// Paths within a group keep the order of files, and groups are ordered by
+// their first path's position in files. Empty files and files smaller than
+// minSize are ignored, and so are files that cannot be read. onProgress
+// gets the bytes read.
+func FindDuplicates(ctx context.Context, src Source, files []DedupeFile, minSize int64, onProgress func(delta int64)) ([]models.DuplicateGroup, error) {
+	bySize := make(map[int64][]int)
+	for i, file := range files {
+		if file.Size > 0 && file.Size >= minSize {
+			bySize[file.Size] = append(bySize[file.Size], i)
+		}
+	}
+
+	type group struct {
+		first int
+		value models.DuplicateGroup
+	}
+	var groups []group
+	for size, candidates := range bySize {
+		if len(candidates) < 2 {
+			continue
+		}
+		partial, err := groupByHash(ctx, src, files, candidates, min(size, dedupePartialSize), onProgress)
+		if err != nil {
+			return nil, err
+		}
+		for hash, same := range partial {
+			full := map[string][]int{hash: same}
+			if size > dedupePartialSize {
+				if full, err = groupByHash(ctx, src, files, same, size, onProgress); err != nil {
+					return nil, err
+				}
+			}
+			for hash, same := range full {
+				paths := make([]string, len(same))
+				for i, index := range same {
+					paths[i] = files[index].Path
+				}
+				groups = append(groups, group{same[0], models.DuplicateGroup{Size: size, Hash: hash, Paths: paths}})
+			}
+		}
+	}
+
+	sort.Slice(groups, func(a, b int) bool { return groups[a].first < groups[b].first })
+	result := make([]models.DuplicateGroup, len(groups))
+	for i, g := range groups {
+		result[i] = g.value
+	}
+	return result, nil
+}
+
+// groupByHash hashes the first n bytes of the given files and returns the
+// hashes shared by more than one file, each with its files in order
+func groupByHash(ctx context.Context, src Source, files []DedupeFile, indexes []int, n int64, onProgress func(delta int64)) (map[string][]int, error) {
+	byHash := make(map[string][]int)
+	for _, index := range indexes {
+		hash, err := hashSourceFile(ctx, src, files[index].Path, n, onProgress)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			common.Warnf("Not deduplicating %s: %v", files[index].Path, err)
+			continue
+		}
+		byHash[hash] = append(byHash[hash], index)
+	}
+	for hash, same := range byHash {
+		if len(same) < 2 {
+			delete(byHash, hash)
+		}
+	}
+	return byHash, nil
+}
+
+// hashSourceFile returns the hex SHA-256 of the first n bytes of path, which
+// must have at least n bytes
+func hashSourceFile(ctx context.Context, src Source, path string, n int64, onProgress func(delta int64)) (string, error) {
+	file, _, err := openSourceFile(ctx, src, path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	reader := &progressReader{reader: &cancelableReader{ctx: ctx, reader: file}, onProgress: onProgress}
+	read, err := io.Copy(hash, io.LimitReader(reader, n))
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	if read < n {
+		return "", fmt.Errorf("file shrank to %d bytes", read)
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// AnalyzeDuplicates walks folders on src like a migration would and reports
+// the duplicate files found in them
+func AnalyzeDuplicates(ctx context.Context, src Source, folders []string, includeRecycle bool, minSize int64, onProgress func(delta int64)) (*models.DedupeReport, error) {
+	report := &models.DedupeReport{}
+	var files []DedupeFile
+	for _, folder := range folders {
+		err := src.Walk(folder, func(path string, info fs.FileInfo) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if skipSourceDir(info.Name(), includeRecycle) {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			files = append(files, DedupeFile{Path: path, Size: info.Size()})
+			report.ScannedFiles++
+			report.ScannedSize += info.Size()
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	groups, err := FindDuplicates(ctx, src, files, minSize, onProgress)
+	if err != nil {
+		return nil, err
+	}
+	report.Groups = groups
+	for _, group := range groups {
+		report.DuplicateFiles += len(group.Paths) - 1
+		report.WastedSize += int64(len(group.Paths)-1) * group.Size
+	}
+	return report, nil
+}
@@ -0,0 +1,156 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/atopos31/stoz/service"
+)
+
+func randomBytes(n int) []byte {
+	data := make([]byte, n)
+	rand.Read(data)
+	return data
+}
+
+// withChange returns a copy of data with the byte at offset flipped
+func withChange(data []byte, offset int) []byte {
+	changed := bytes.Clone(data)
+	changed[offset] ^= 0xff
+	return changed
+}
+
+func TestFindDuplicates(t *testing.T) {
+	const partial = 64 << 10
+	big := randomBytes(100 << 10)
+	small := randomBytes(10 << 10)
+	exact := randomBytes(partial)
+
+	type file struct {
+		name string
+		data []byte
+	}
+	tests := []struct {
+		name   string
+		files  []file
+		groups [][]string
+		read   int64 // Bytes hashed over all rounds
+	}{
+		{
+			name:  "different sizes are never read",
+			files: []file{{"a", randomBytes(5000)}, {"b", randomBytes(6000)}},
+			read:  0,
+		},
+		{
+			name:  "identical large files are hashed in full",
+			files: []file{{"a", big}, {"b", randomBytes(5000)}, {"c", big}, {"d", big}},
+			groups: [][]string{
+				{"a", "c", "d"},
+			},
+			read: 3*partial + 3*int64(len(big)),
+		},
+		{
+			name:  "same size, different start stops after the partial hash",
+			files: []file{{"a", big}, {"b", withChange(big, 100)}},
+			read:  2 * partial,
+		},
+		{
+			name:  "same size, different end is found by the full hash",
+			files: []file{{"a", big}, {"b", withChange(big, len(big)-1)}},
+			read:  2*partial + 2*int64(len(big)),
+		},
+		{
+			name:   "small files: the partial hash is the full hash",
+			files:  []file{{"a", small}, {"b", small}, {"c", withChange(small, len(small)-1)}},
+			groups: [][]string{{"a", "b"}},
+			read:   3 * int64(len(small)),
+		},
+		{
+			name:   "files of exactly the partial size are read once",
+			files:  []file{{"a", exact}, {"b", exact}},
+			groups: [][]string{{"a", "b"}},
+			read:   2 * partial,
+		},
+		{
+			name:  "empty and too small files are ignored",
+			files: []file{{"a", nil}, {"b", nil}, {"c", []byte("tiny")}, {"d", []byte("tiny")}},
+			read:  0,
+		},
+		{
+			name: "groups follow their first file",
+			files: []file{
+				{"a", small}, {"b", big}, {"c", small}, {"d", big}, {"e", exact}, {"f", exact},
+			},
+			groups: [][]string{{"a", "c"}, {"b", "d"}, {"e", "f"}},
+			read:   2*int64(len(small)) + 2*partial + 2*int64(len(big)) + 2*partial,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			var files []service.DedupeFile
+			for _, f := range tt.files {
+				path := filepath.Join(dir, f.name)
+				if err := os.WriteFile(path, f.data, 0644); err != nil {
+					t.Fatal(err)
+				}
+				files = append(files, service.DedupeFile{Path: path, Size: int64(len(f.data))})
+			}
+
+			var read int64
+			groups, err := service.FindDuplicates(context.Background(), nil, files, 4<<10, func(delta int64) { read += delta })
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got [][]string
+			for _, group := range groups {
+				var names []string
+				for _, path := range group.Paths {
+					names = append(names, filepath.Base(path))
+				}
+				got = append(got, names)
+				if len(group.Hash) != 64 {
+					t.Errorf("group %v has hash %q", names, group.Hash)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.groups) {
+				t.Errorf("groups = %v, want %v", got, tt.groups)
+			}
+			if read != tt.read {
+				t.Errorf("read %d bytes, want %d", read, tt.read)
+			}
+		})
+	}
+}
+
+func TestFindDuplicatesSkipsUnreadableFiles(t *testing.T) {
+	dir := t.TempDir()
+	data := randomBytes(8 << 10)
+	var files []service.DedupeFile
+	for _, name := range []string{"a", "b"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, service.DedupeFile{Path: path, Size: int64(len(data))})
+	}
+	files = append(files,
+		service.DedupeFile{Path: filepath.Join(dir, "missing"), Size: int64(len(data))},
+		// Shrank since the scan
+		service.DedupeFile{Path: files[0].Path, Size: int64(len(data)) + 1},
+		service.DedupeFile{Path: files[1].Path, Size: int64(len(data)) + 1},
+	)
+
+	groups, err := service.FindDuplicates(context.Background(), nil, files, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || !reflect.DeepEqual(groups[0].Paths, []string{files[0].Path, files[1].Path}) {
+		t.Errorf("groups = %+v, want a and b", groups)
+	}
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/config"
+)
+
+// Destination types
+const (
+	DestinationZimaOS = "zimaos"
+	DestinationLocal  = "local"
+	DestinationWebDAV = "webdav"
+	DestinationSFTP   = "sftp"
+	DestinationS3     = "s3"
+)
+
+// Destination is where a migration writes files. Paths are absolute paths
+// below the task's base path; each backend maps them onto its storage.
+type Destination interface {
+	Login() error // Connect and check the credentials
+	SetBandwidthLimiters(limiters ...*BandwidthLimiter)
+	SetSource(source Source) // Where UploadFile reads localPath from; nil is the local filesystem
+	CreateFolder(path string) error
+	UploadFile(ctx context.Context, localPath, remotePath string, onProgress func(delta int64)) error
+	GetFileInfo(filePath string) (*FileMetadata, error)                // Wraps common.ErrFileNotFound when the file is missing
+	DownloadPartialFile(filePath string, size int64) ([]byte, error)   // First size bytes, for verification
+	DownloadRange(filePath string, offset, size int64) ([]byte, error) // size bytes at offset, for verifying archived files
+	Close() error
+}
+
+// DestinationOptions holds the settings of non-ZimaOS destinations that are
+// not secret. They are stored as JSON on the task.
+type DestinationOptions struct {
+	Bucket        string `json:"bucket,omitempty"`         // S3
+	Region        string `json:"region,omitempty"`         // S3, default us-east-1
+	VirtualHosted bool   `json:"virtual_hosted,omitempty"` // S3: bucket.endpoint instead of endpoint/bucket
+	HostKey       string `json:"host_key,omitempty"`       // SFTP: expected key, "SHA256:..." as printed by ssh-keygen -l
+	IgnoreHostKey bool   `json:"ignore_host_key,omitempty"`
+	TLSOptions           // WebDAV and S3 over HTTPS
+}
+
+// DestinationSpec selects and configures the destination of a task
+type DestinationSpec struct {
+	Type     string `json:"type"`
+	URL      string `json:"url"`      // WebDAV base URL, SFTP host[:port] or S3 endpoint; unused for local
+	Username string `json:"username"` // S3: access key
+	Password string `json:"password"` // S3: secret key; SFTP: password or PEM private key
+	DestinationOptions
+}
+
+// NewDestination builds the client for a destination without connecting.
+// The password is expected in plaintext.
+func NewDestination(destType, url, username, password string, opts DestinationOptions) (Destination, error) {
+	switch destType {
+	case DestinationZimaOS:
+		client := NewZimaOSClient(url, username, password)
+		if err := client.SetTLSOptions(opts.TLSOptions); err != nil {
+			return nil, err
+		}
+		return client, nil
+	case DestinationLocal:
+		return asDestination(NewLocalDestination())
+	case DestinationWebDAV:
+		return asDestination(NewWebDAVDestination(url, username, password, opts))
+	case DestinationSFTP:
+		return asDestination(NewSFTPDestination(url, username, password, opts))
+	case DestinationS3:
+		return asDestination(NewS3Destination(url, username, password, opts))
+	default:
+		return nil, fmt.Errorf("%w: unknown destination type %q", common.ErrInvalidRequest, destType)
+	}
+}
+
+// asDestination keeps a failed constructor from returning a typed nil
+func asDestination[T Destination](dest T, err error) (Destination, error) {
+	if err != nil {
+		return nil, err
+	}
+	return dest, nil
+}
+
+// normalizeDestinationURL validates the URL of a destination type. Local
+// destinations have none and are recorded as "local".
+func normalizeDestinationURL(destType, rawURL string) (string, error) {
+	switch destType {
+	case DestinationZimaOS, DestinationWebDAV:
+		return NormalizeHost(rawURL, "http")
+	case DestinationS3:
+		return NormalizeHost(rawURL, "https")
+	case DestinationLocal:
+		return DestinationLocal, nil
+	case DestinationSFTP:
+		addr := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(rawURL), "sftp://"), "/")
+		if addr == "" {
+			return "", fmt.Errorf("%w: SFTP host is required", common.ErrInvalidRequest)
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "22")
+		}
+		return addr, nil
+	default:
+		return "", fmt.Errorf("%w: unknown destination type %q", common.ErrInvalidRequest, destType)
+	}
+}
+
+// prepareDestination validates a non-ZimaOS destination of spec, normalizes
+// its URL and returns the options to store on the task
+func (spec *TaskSpec) prepareDestination() (string, error) {
+	if spec.TargetID != "" {
+		return "", fmt.Errorf("%w: target_id can only be used with ZimaOS destinations", common.ErrInvalidRequest)
+	}
+	host, err := normalizeDestinationURL(spec.DestinationType, spec.Host)
+	if err != nil {
+		return "", err
+	}
+	spec.Host = host
+
+	// Building the client checks the options without connecting
+	dest, err := NewDestination(spec.DestinationType, spec.Host, spec.Username, spec.Password, spec.DestinationOptions)
+	if err != nil {
+		return "", err
+	}
+	if local, ok := dest.(*LocalDestination); ok && spec.BasePath != "" {
+		if _, err := local.resolve(spec.BasePath); err != nil {
+			return "", err
+		}
+	}
+	if spec.DestinationType == DestinationSFTP && spec.Password == "" {
+		return "", fmt.Errorf("%w: password or private key is required for SFTP", common.ErrInvalidRequest)
+	}
+
+	options, err := json.Marshal(spec.DestinationOptions)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal destination options: %w", err)
+	}
+	return string(options), nil
+}
+
+// openUploadSource opens a source file for upload, wrapped so reads honour
+// ctx and the bandwidth limiters and report progress
+func openUploadSource(ctx context.Context, src Source, localPath string, limiters []*BandwidthLimiter, onProgress func(delta int64)) (io.ReadCloser, io.Reader, fs.FileInfo, error) {
+	file, stat, err := openSourceFile(ctx, src, localPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var source io.Reader = &cancelableReader{ctx: ctx, reader: file}
+	if len(limiters) > 0 {
+		source = &throttledReader{ctx: ctx, reader: source, limiters: limiters}
+	}
+	return file, &progressReader{reader: source, onProgress: onProgress}, stat, nil
+}
+
+// withStallWatch returns a context that is cancelled with errUploadStalled
+// once watch has seen no request body progress for ZIMAOS_STALL_TIMEOUT.
+// stop must be called when the upload is over.
+func withStallWatch(ctx context.Context, what string) (context.Context, *stallWatch, func()) {
+	uploadCtx, cancel := context.WithCancelCause(ctx)
+	watch := &stallWatch{}
+
+	stall := config.AppConfig.ZimaOS.StallTimeout
+	if stall <= 0 {
+		return uploadCtx, watch, func() { cancel(nil) }
+	}
+
+	done := make(chan struct{})
+	go watch.run(stall, done, func() {
+		common.Warnf("Upload of %s made no progress for %v, aborting", what, stall)
+		cancel(errUploadStalled)
+	})
+	return uploadCtx, watch, func() {
+		close(done)
+		cancel(nil)
+	}
+}
+
+// stalledError reports errUploadStalled if uploadCtx was aborted by the
+// stall detector rather than by the caller
+func stalledError(ctx, uploadCtx context.Context) error {
+	if ctx.Err() == nil && errors.Is(context.Cause(uploadCtx), errUploadStalled) {
+		return errUploadStalled
+	}
+	return nil
+}
+
+// fileMetadata converts local file information to the ZimaOS listing format
+func fileMetadata(path string, size int64, modTime time.Time, isDir bool) *FileMetadata {
+	name := path[strings.LastIndex(path, "/")+1:]
+	return &FileMetadata{
+		Name:     name,
+		Size:     size,
+		Modified: modTime.Unix(),
+		IsDir:    isDir,
+		Path:     path,
+	}
+}
+
+// readRange reads size bytes at offset from the response to a ranged GET. A
+// server that ignored the range answers 200 with the whole file.
+func readRange(resp *http.Response, offset, size int64) ([]byte, error) {
+	if resp.StatusCode == http.StatusOK && offset > 0 {
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			return nil, fmt.Errorf("failed to read response: %w", err)
+		}
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, size))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return data, nil
+}
+
+// rangeHeader returns the Range header value for size bytes at offset
+func rangeHeader(offset, size int64) string {
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+size-1)
+}
@@ -0,0 +1,11 @@
+package service
+
+import (
+	"context"
+	"io"
+)
+
+// NewThrottledReader exposes throttledReader to the tests
+func NewThrottledReader(ctx context.Context, reader io.Reader, limiters ...*BandwidthLimiter) io.Reader {
+	return &throttledReader{ctx: ctx, reader: reader, limiters: limiters}
+}
@@ -0,0 +1,436 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/config"
+	"github.com/atopos31/stoz/models"
+)
+
+const indexRetryDelay = time.Minute // After the shares could not be listed
+
+// FolderIndexer computes the size and file count of every share in the
+// background and stores them as models.FolderIndex, so scans and folder
+// details never walk a tree inside a request. Shares are walked one at a
+// time, the stalest first, once their entry is older than
+// SCAN_INDEX_INTERVAL, and each result is stored as soon as it is done.
+// A refresh walks the whole share again: directory times do not change when
+// a file below is rewritten, so they cannot tell which subtrees to skip.
+// Folders passed to Request go ahead of the periodic refresh.
+type FolderIndexer struct {
+	interval time.Duration
+
+	mu         sync.Mutex
+	requested  []string
+	cycle      []string // Shares left in the current refresh
+	cycleTotal int
+	force      bool // Refresh every share, not only the stale ones
+	current    string
+	fromQueue  bool // current was requested
+	lastCycle  *time.Time
+	nextCycle  time.Time
+
+	files atomic.Int64 // Counted so far in current
+	size  atomic.Int64
+
+	wake   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// IndexerStatus reports what the folder indexer is doing
+type IndexerStatus struct {
+	Running         bool       `json:"running"` // Walking CurrentPath
+	CurrentPath     string     `json:"current_path,omitempty"`
+	CurrentFiles    int64      `json:"current_files"` // Counted so far in CurrentPath
+	CurrentSize     int64      `json:"current_size"`
+	Queued          int        `json:"queued"`      // Requested folders waiting
+	CycleDone       int        `json:"cycle_done"`  // Shares refreshed in the current refresh
+	CycleTotal      int        `json:"cycle_total"` // Shares that were stale when it started
+	Indexed         int64      `json:"indexed"`     // Folders with a stored size
+	IntervalSeconds int64      `json:"interval_seconds"`
+	LastCycleAt     *time.Time `json:"last_cycle_at,omitempty"` // When every share was last up to date
+	NextCycleAt     *time.Time `json:"next_cycle_at,omitempty"` // Unset when refreshes only run on request
+}
+
+var folderIndexer *FolderIndexer
+var folderIndexerOnce sync.Once
+
+func GetFolderIndexer() *FolderIndexer {
+	folderIndexerOnce.Do(func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		folderIndexer = &FolderIndexer{
+			interval: config.AppConfig.Scan.IndexInterval,
+			wake:     make(chan struct{}, 1),
+			ctx:      ctx,
+			cancel:   cancel,
+		}
+	})
+	return folderIndexer
+}
+
+func (x *FolderIndexer) Start() {
+	x.wg.Add(1)
+	go x.run()
+	common.Info("Folder indexer started")
+}
+
+// Stop interrupts the walk in progress; its folder is walked again on the next start
+func (x *FolderIndexer) Stop() {
+	x.cancel()
+	x.wg.Wait()
+	common.Info("Folder indexer stopped")
+}
+
+// Request queues a folder to be indexed ahead of the periodic refresh
+func (x *FolderIndexer) Request(path string) {
+	x.mu.Lock()
+	if path == x.current {
+		x.mu.Unlock()
+		return
+	}
+	for _, queued := range x.requested {
+		if queued == path {
+			x.mu.Unlock()
+			return
+		}
+	}
+	x.requested = append(x.requested, path)
+	x.mu.Unlock()
+	x.notify()
+}
+
+// RefreshAll starts walking every share again, stale or not
+func (x *FolderIndexer) RefreshAll() {
+	x.mu.Lock()
+	x.force = true
+	x.cycle = nil
+	x.mu.Unlock()
+	x.notify()
+}
+
+func (x *FolderIndexer) notify() {
+	select {
+	case x.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Lookup returns the stored entries of paths, by path
+func (x *FolderIndexer) Lookup(paths []string) (map[string]*models.FolderIndex, error) {
+	var entries []*models.FolderIndex
+	if err := models.DB.Where("path IN ?", paths).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	byPath := make(map[string]*models.FolderIndex, len(entries))
+	for _, entry := range entries {
+		byPath[entry.Path] = entry
+	}
+	return byPath, nil
+}
+
+// Stale reports whether an entry is due for a refresh
+func (x *FolderIndexer) Stale(entry *models.FolderIndex) bool {
+	return x.interval > 0 && time.Since(entry.IndexedAt) >= x.interval
+}
+
+func (x *FolderIndexer) Status() *IndexerStatus {
+	var indexed int64
+	if err := models.DB.Model(&models.FolderIndex{}).Count(&indexed).Error; err != nil {
+		common.Errorf("Failed to count indexed folders: %v", err)
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	status := &IndexerStatus{
+		Running:         x.current != "",
+		CurrentPath:     x.current,
+		Queued:          len(x.requested),
+		CycleTotal:      x.cycleTotal,
+		CycleDone:       x.cycleTotal - len(x.cycle),
+		Indexed:         indexed,
+		IntervalSeconds: int64(x.interval / time.Second),
+		LastCycleAt:     x.lastCycle,
+	}
+	if status.Running {
+		status.CurrentFiles = x.files.Load()
+		status.CurrentSize = x.size.Load()
+		// The share being walked has already left the cycle
+		if !x.fromQueue && status.CycleDone > 0 {
+			status.CycleDone--
+		}
+	}
+	if x.interval > 0 && !x.nextCycle.IsZero() {
+		next := x.nextCycle
+		status.NextCycleAt = &next
+	}
+	return status
+}
+
+func (x *FolderIndexer) run() {
+	defer x.wg.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		if path, ok := x.next(); ok {
+			x.index(path)
+			continue
+		}
+		if x.cycleDue() {
+			x.planCycle()
+			continue
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		var timeout <-chan time.Time
+		if x.interval > 0 {
+			x.mu.Lock()
+			timer.Reset(time.Until(x.nextCycle))
+			x.mu.Unlock()
+			timeout = timer.C
+		}
+		select {
+		case <-x.ctx.Done():
+			return
+		case <-x.wake:
+		case <-timeout:
+		}
+	}
+}
+
+// next takes the next folder to walk: requested ones first, then the
+// shares of the current refresh
+func (x *FolderIndexer) next() (string, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.ctx.Err() != nil {
+		return "", false
+	}
+	switch {
+	case len(x.requested) > 0:
+		x.current, x.requested = x.requested[0], x.requested[1:]
+		x.fromQueue = true
+	case len(x.cycle) > 0:
+		x.current, x.cycle = x.cycle[0], x.cycle[1:]
+		x.fromQueue = false
+	default:
+		return "", false
+	}
+	return x.current, true
+}
+
+// cycleDue reports whether a refresh of the shares should start
+func (x *FolderIndexer) cycleDue() bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.ctx.Err() != nil {
+		return false
+	}
+	return x.force || (x.interval > 0 && !time.Now().Before(x.nextCycle))
+}
+
+// planCycle lists the shares and queues those whose entry is missing or
+// stale, missing ones first, then the oldest. Entries of folders that no
+// longer exist are removed.
+func (x *FolderIndexer) planCycle() {
+	x.mu.Lock()
+	force := x.force
+	x.force = false
+	x.mu.Unlock()
+
+	now := time.Now()
+	shares, err := listShares(GetSource())
+	if err != nil {
+		common.Errorf("Folder indexer failed to list shares: %v", err)
+		x.mu.Lock()
+		x.nextCycle = now.Add(indexRetryDelay)
+		x.mu.Unlock()
+		return
+	}
+	x.prune()
+
+	entries, err := x.Lookup(shares)
+	if err != nil {
+		common.Errorf("Folder indexer failed to load entries: %v", err)
+		entries = map[string]*models.FolderIndex{}
+	}
+
+	next := now.Add(x.interval)
+	var stale []string
+	for _, share := range shares {
+		entry, ok := entries[share]
+		if force || !ok || (x.interval > 0 && now.Sub(entry.IndexedAt) >= x.interval) {
+			stale = append(stale, share)
+		} else if expires := entry.IndexedAt.Add(x.interval); x.interval > 0 && expires.Before(next) {
+			next = expires
+		}
+	}
+	sort.SliceStable(stale, func(a, b int) bool {
+		return indexedAt(entries[stale[a]]).Before(indexedAt(entries[stale[b]]))
+	})
+
+	x.mu.Lock()
+	x.cycle = stale
+	x.cycleTotal = len(stale)
+	x.nextCycle = next
+	if len(stale) == 0 {
+		x.lastCycle = &now
+	}
+	x.mu.Unlock()
+	if len(stale) > 0 {
+		common.Infof("Folder indexer refreshing %d of %d shares", len(stale), len(shares))
+	}
+}
+
+// indexedAt returns when entry was indexed, the zero time for none
+func indexedAt(entry *models.FolderIndex) time.Time {
+	if entry == nil {
+		return time.Time{}
+	}
+	return entry.IndexedAt
+}
+
+// prune removes the entries of folders that are gone, e.g. after a share was
+// deleted or SOURCE_TYPE changed
+func (x *FolderIndexer) prune() {
+	var paths []string
+	if err := models.DB.Model(&models.FolderIndex{}).Pluck("path", &paths).Error; err != nil {
+		common.Errorf("Folder indexer failed to load entries: %v", err)
+		return
+	}
+	src := GetSource()
+	for _, path := range paths {
+		if _, err := src.Stat(path); err != nil && errors.Is(err, fs.ErrNotExist) {
+			x.remove(path)
+		}
+	}
+}
+
+func (x *FolderIndexer) remove(path string) {
+	if err := models.DB.Where("path = ?", path).Delete(&models.FolderIndex{}).Error; err != nil {
+		common.Errorf("Failed to remove index of %s: %v", path, err)
+		return
+	}
+	common.Infof("Removed index of %s, which no longer exists", path)
+}
+
+// index walks one folder and stores its entry
+func (x *FolderIndexer) index(path string) {
+	x.files.Store(0)
+	x.size.Store(0)
+	defer func() {
+		x.mu.Lock()
+		x.current = ""
+		if len(x.cycle) == 0 && x.cycleTotal > 0 && !x.fromQueue && !x.force {
+			now := time.Now()
+			x.lastCycle = &now
+		}
+		x.mu.Unlock()
+	}()
+
+	entry, err := walkFolderIndex(x.ctx, GetSource(), path, &x.files, &x.size)
+	if err != nil {
+		if x.ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			x.remove(path)
+			return
+		}
+		common.Warnf("Failed to index %s: %v", path, err)
+		return
+	}
+
+	// Find rather than First: a missing entry is expected for new folders
+	var existing models.FolderIndex
+	if err := models.DB.Where("path = ?", path).Limit(1).Find(&existing).Error; err == nil {
+		entry.ID = existing.ID
+	}
+	if err := models.DB.Save(entry).Error; err != nil {
+		common.Errorf("Failed to store index of %s: %v", path, err)
+		return
+	}
+	common.Infof("Indexed %s: %d files, %d bytes in %dms", path, entry.FileCount, entry.Size, entry.DurationMs)
+}
+
+// walkFolderIndex counts the files below path like a migration would see
+// them, with #recycle counted apart. files and size follow the walk.
+func walkFolderIndex(ctx context.Context, src Source, path string, files, size *atomic.Int64) (*models.FolderIndex, error) {
+	started := time.Now()
+	info, err := src.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fs.ErrInvalid
+	}
+
+	entry := &models.FolderIndex{Path: path}
+	root := strings.TrimSuffix(path, "/")
+	err = src.Walk(path, func(walked string, info fs.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if skipSourceDir(info.Name(), true) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if inRecycle(root, walked) {
+			entry.RecycleFiles++
+			entry.RecycleSize += info.Size()
+		} else {
+			entry.FileCount++
+			entry.Size += info.Size()
+		}
+		files.Add(1)
+		size.Add(info.Size())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entry.IndexedAt = time.Now()
+	entry.DurationMs = time.Since(started).Milliseconds()
+	return entry, nil
+}
+
+// inRecycle reports whether path below root lies in a #recycle folder
+func inRecycle(root, path string) bool {
+	rel := strings.TrimPrefix(path, root)
+	return strings.Contains(filepath.ToSlash(rel)+"/", "/#recycle/")
+}
+
+// listShares returns the top-level folders of all volumes
+func listShares(src Source) ([]string, error) {
+	volumes, err := src.ListVolumes()
+	if err != nil {
+		return nil, err
+	}
+	var shares []string
+	for _, volume := range volumes {
+		for _, folder := range volume.Folders {
+			shares = append(shares, folder.Path)
+		}
+	}
+	return shares, nil
+}
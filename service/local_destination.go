@@ -0,0 +1,274 @@
+package service
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/config"
+)
+
+// LocalDestination writes to a directory of the container, typically an NFS
+// or SMB share mounted below one of LOCAL_DEST_ROOTS
+type LocalDestination struct {
+	roots    []string
+	limiters []*BandwidthLimiter
+	source   Source
+}
+
+func NewLocalDestination() (*LocalDestination, error) {
+	var roots []string
+	for _, root := range config.AppConfig.Dest.LocalRoots {
+		if resolved, err := filepath.EvalSymlinks(filepath.Clean(root)); err == nil {
+			roots = append(roots, resolved)
+		}
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("%w: none of LOCAL_DEST_ROOTS exists", common.ErrInvalidRequest)
+	}
+	return &LocalDestination{roots: roots}, nil
+}
+
+// resolve checks that path, after resolving symlinks of its existing
+// parents, lies inside one of the allowed roots
+func (d *LocalDestination) resolve(path string) (string, error) {
+	if !filepath.IsAbs(path) || strings.ContainsRune(path, 0) {
+		return "", fmt.Errorf("%w: destination path must be absolute", common.ErrInvalidRequest)
+	}
+	path = filepath.Clean(path)
+
+	// Resolve the deepest part that exists; the rest is created by us
+	existing, rest := path, ""
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			existing = filepath.Join(resolved, rest)
+			break
+		}
+		if !errors.Is(err, os.ErrNotExist) || existing == "/" {
+			return "", fmt.Errorf("failed to resolve %s: %w", path, err)
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = filepath.Dir(existing)
+	}
+
+	for _, root := range d.roots {
+		if rel, err := filepath.Rel(root, existing); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return existing, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s is outside LOCAL_DEST_ROOTS", common.ErrInvalidRequest, path)
+}
+
+// Login checks that the roots are writable
+func (d *LocalDestination) Login() error {
+	for _, root := range d.roots {
+		probe, err := os.CreateTemp(root, ".stoz-probe-*")
+		if err != nil {
+			return fmt.Errorf("destination root %s is not writable: %w", root, err)
+		}
+		probe.Close()
+		os.Remove(probe.Name())
+	}
+	return nil
+}
+
+func (d *LocalDestination) SetBandwidthLimiters(limiters ...*BandwidthLimiter) {
+	d.limiters = limiters
+}
+
+func (d *LocalDestination) SetSource(source Source) {
+	d.source = source
+}
+
+func (d *LocalDestination) CreateFolder(path string) error {
+	resolved, err := d.resolve(path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(resolved, 0755); err != nil {
+		return fmt.Errorf("failed to create folder: %w", err)
+	}
+	return nil
+}
+
+// UploadFile copies into a temporary file next to the target and renames it,
+// so an interrupted copy never leaves a truncated file under the real name
+func (d *LocalDestination) UploadFile(ctx context.Context, localPath, remotePath string, onProgress func(delta int64)) error {
+	target, err := d.resolve(remotePath)
+	if err != nil {
+		return err
+	}
+
+	file, source, stat, err := openUploadSource(ctx, d.source, localPath, d.limiters, onProgress)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".stoz-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op after the rename
+
+	if _, err := io.Copy(tmp, source); err != nil {
+		tmp.Close()
+		if ctx.Err() != nil {
+			return fmt.Errorf("upload cancelled by user")
+		}
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := os.Chtimes(tmp.Name(), stat.ModTime(), stat.ModTime()); err != nil {
+		return fmt.Errorf("failed to set modification time: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return fmt.Errorf("failed to move file into place: %w", err)
+	}
+
+	common.Infof("File copied successfully: %s -> %s", localPath, target)
+	return nil
+}
+
+// ExtractArchive unpacks a batch archive next to it. Each file is written to
+// a temporary name and renamed, like UploadFile; only plain file names are
+// accepted, so entries cannot leave the archive's directory.
+func (d *LocalDestination) ExtractArchive(ctx context.Context, archivePath string) error {
+	archive, err := d.resolve(archivePath)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(archive)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer file.Close()
+
+	dir := filepath.Dir(archive)
+	tr := tar.NewReader(file)
+	for {
+		if ctx.Err() != nil {
+			return fmt.Errorf("extraction cancelled: %w", ctx.Err())
+		}
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		name := header.Name
+		if header.Typeflag != tar.TypeReg || name != filepath.Base(name) || name == "." || name == ".." {
+			return fmt.Errorf("unexpected archive entry %q", name)
+		}
+		if err := extractFile(tr, filepath.Join(dir, name), header.ModTime); err != nil {
+			return fmt.Errorf("failed to extract %s: %w", name, err)
+		}
+	}
+
+	if err := os.Remove(archive); err != nil {
+		return fmt.Errorf("failed to remove archive: %w", err)
+	}
+	common.Infof("Archive extracted: %s", archive)
+	return nil
+}
+
+// CopyFile copies a file already in the destination, through a temporary
+// file like UploadFile
+func (d *LocalDestination) CopyFile(ctx context.Context, srcPath, dstPath string, modTime time.Time) error {
+	source, err := d.resolve(srcPath)
+	if err != nil {
+		return err
+	}
+	target, err := d.resolve(dstPath)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(source)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	if err := extractFile(&cancelableReader{ctx: ctx, reader: file}, target, modTime); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("copy cancelled by user")
+		}
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	common.Infof("File copied successfully: %s -> %s", source, target)
+	return nil
+}
+
+func extractFile(r io.Reader, target string, modTime time.Time) error {
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".stoz-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op after the rename
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp.Name(), modTime, modTime); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+func (d *LocalDestination) GetFileInfo(filePath string) (*FileMetadata, error) {
+	resolved, err := d.resolve(filePath)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := os.Stat(resolved)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", common.ErrFileNotFound, filePath)
+		}
+		return nil, err
+	}
+	return fileMetadata(filePath, stat.Size(), stat.ModTime(), stat.IsDir()), nil
+}
+
+func (d *LocalDestination) DownloadPartialFile(filePath string, size int64) ([]byte, error) {
+	return d.DownloadRange(filePath, 0, size)
+}
+
+func (d *LocalDestination) DownloadRange(filePath string, offset, size int64) ([]byte, error) {
+	resolved, err := d.resolve(filePath)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(resolved)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(io.NewSectionReader(file, offset, size))
+}
+
+func (d *LocalDestination) Close() error {
+	return nil
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/config"
+	"github.com/atopos31/stoz/models"
+)
+
+// LocalSource reads the Synology volumes bind-mounted at HOST_PATH
+type LocalSource struct{}
+
+// ListVolumes lists the HOST_PATH/volume* directories in parallel
+func (s *LocalSource) ListVolumes() ([]models.VolumeInfo, error) {
+	hostPath := config.AppConfig.Scan.HostPath
+	entries, err := os.ReadDir(hostPath)
+	if err != nil {
+		common.Errorf("Failed to read host path %s: %v", hostPath, err)
+		return nil, err
+	}
+
+	var volumes []models.VolumeInfo
+	var wg sync.WaitGroup
+	volumeChan := make(chan models.VolumeInfo, 10)
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+		if !strings.HasPrefix(name, "volume") {
+			continue
+		}
+
+		wg.Add(1)
+		go func(volumeName string) {
+			defer wg.Done()
+
+			volumePath := filepath.Join(hostPath, volumeName)
+			folders, err := s.scanVolume(volumePath)
+			if err != nil {
+				common.Errorf("Failed to scan volume %s: %v", volumeName, err)
+				return
+			}
+
+			volumeChan <- models.VolumeInfo{
+				Name:    volumeName,
+				Path:    volumePath,
+				Folders: folders,
+			}
+		}(name)
+	}
+
+	go func() {
+		wg.Wait()
+		close(volumeChan)
+	}()
+
+	for volume := range volumeChan {
+		volumes = append(volumes, volume)
+	}
+	return volumes, nil
+}
+
+func (s *LocalSource) scanVolume(volumePath string) ([]models.FolderInfo, error) {
+	entries, err := os.ReadDir(volumePath)
+	if err != nil {
+		return nil, err
+	}
+
+	folders := make([]models.FolderInfo, 0)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+		if strings.HasPrefix(name, "@") {
+			continue
+		}
+
+		folderPath := filepath.Join(volumePath, name)
+		info, err := entry.Info()
+		if err != nil {
+			common.Warnf("Failed to get info for %s: %v", folderPath, err)
+			continue
+		}
+
+		folders = append(folders, models.FolderInfo{
+			Path:         folderPath,
+			Name:         name,
+			ModifiedTime: info.ModTime(),
+		})
+	}
+
+	return folders, nil
+}
+
+func (s *LocalSource) Resolve(path string) (string, error) {
+	return resolveLocalSourcePath(path)
+}
+
+// Walk follows symlinks only while they stay inside the Synology volumes;
+// devices, sockets and FIFOs are skipped
+func (s *LocalSource) Walk(root string, fn WalkFunc) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			common.Warnf("Failed to access path %s: %v", path, err)
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			common.Warnf("Failed to get file info for %s: %v", path, err)
+			return nil
+		}
+		if d.IsDir() {
+			return fn(path, info)
+		}
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			target, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				common.Warnf("Skipping broken symlink %s: %v", path, err)
+				return nil
+			}
+			if !IsWithinVolumes(target) {
+				common.SecurityEvent("symlink_escape_skipped", map[string]interface{}{
+					"path":   path,
+					"target": target,
+				})
+				return nil
+			}
+			if info, err = os.Stat(target); err != nil || !info.Mode().IsRegular() {
+				common.Infof("Skipping symlink to non-regular file: %s", path)
+				return nil
+			}
+		} else if !info.Mode().IsRegular() {
+			common.Infof("Skipping non-regular file: %s", path)
+			return nil
+		}
+
+		return fn(path, info)
+	})
+}
+
+func (s *LocalSource) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
+
+func (s *LocalSource) Stat(path string) (fs.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (s *LocalSource) Close() error {
+	return nil
+}
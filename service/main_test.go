@@ -0,0 +1,33 @@
+package service_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/config"
+	"github.com/atopos31/stoz/models"
+)
+
+func TestMain(m *testing.M) {
+	config.Load()
+	common.InitLogger("error")
+
+	// The task queue, schedules and indexes keep their state in the database
+	dir, err := os.MkdirTemp("", "stoz-service-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := models.InitDB(filepath.Join(dir, "stoz.db")); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
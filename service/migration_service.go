@@ -2,6 +2,7 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -9,6 +10,7 @@ import (
 	"github.com/atopos31/stoz/common"
 	"github.com/atopos31/stoz/models"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type MigrationService struct {
@@ -29,6 +31,9 @@ type TaskStatus struct {
 	TotalSize              int64   `json:"total_size"`
 	Progress               float64 `json:"progress"`
 	FailedFiles            int     `json:"failed_files"`
+	WireSize               int64   `json:"wire_size"`     // Bytes sent to the destination, after compression and including retries
+	DedupedFiles           int     `json:"deduped_files"` // Duplicates copied on the destination or skipped
+	DedupedSize            int64   `json:"deduped_size"`  // Bytes of those files, not uploaded
 	// Verification progress fields
 	VerifyingFiles    int       `json:"verifying_files"`     // Number of files verified
 	VerifyFailedFiles int       `json:"verify_failed_files"` // Number of verification failures
@@ -37,16 +42,81 @@ type TaskStatus struct {
 
 	// Path information fields
 	SourceFolders []string `json:"source_folders"` // Source folder paths
-	ZimaOSHost    string   `json:"zimaos_host"`    // ZimaOS host address
+	DestURL       string   `json:"dest_url"`       // Destination host or URL
+	ZimaOSHost    string   `json:"zimaos_host"`    // Deprecated: same as DestURL
 	BasePath      string   `json:"base_path"`      // Target base path
 	Error         string   `json:"error"`          // Error message when failed
+
+	// Queue information fields
+	Priority      int `json:"priority"`
+	QueuePosition int `json:"queue_position"` // 1-based position among pending tasks, 0 when not queued
+
+	wireBase    int64
+	wireLimiter *BandwidthLimiter
+}
+
+// TrackWire makes WireSize follow the bytes that pass limiter, on top of
+// base bytes sent by earlier runs of the task
+func (s *TaskStatus) TrackWire(base int64, limiter *BandwidthLimiter) {
+	s.wireBase = base
+	s.wireLimiter = limiter
+	s.WireSize = base
+}
+
+// RefreshWireSize updates and returns WireSize
+func (s *TaskStatus) RefreshWireSize() int64 {
+	if s.wireLimiter != nil {
+		s.WireSize = s.wireBase + s.wireLimiter.Sent()
+	}
+	return s.WireSize
 }
 
 type MigrationOptions struct {
-	OverwriteExisting bool `json:"overwrite_existing"`
-	SkipErrors        bool `json:"skip_errors"`
-	PreserveTimes     bool `json:"preserve_times"`
-	IncludeRecycle    bool `json:"include_recycle"`
+	OverwriteExisting bool               `json:"overwrite_existing"`
+	SkipErrors        bool               `json:"skip_errors"`
+	PreserveTimes     bool               `json:"preserve_times"`
+	IncludeRecycle    bool               `json:"include_recycle"`
+	Bandwidth         *BandwidthPolicy   `json:"bandwidth,omitempty"`   // Per-task upload limit
+	Batch             *BatchPolicy       `json:"batch,omitempty"`       // Upload small files in tar archives
+	Compression       *CompressionPolicy `json:"compression,omitempty"` // Opt-in; compressed files are stored as <name>.gz
+	Dedupe            *DedupePolicy      `json:"dedupe,omitempty"`      // Upload files with identical content once
+}
+
+// validateOptions checks the options of a task going to a destination of destType
+func validateOptions(options MigrationOptions, destType string) error {
+	if options.Bandwidth != nil {
+		if err := options.Bandwidth.Validate(); err != nil {
+			return fmt.Errorf("%w: invalid bandwidth: %v", common.ErrInvalidRequest, err)
+		}
+	}
+	if options.Batch != nil {
+		if err := options.Batch.Validate(); err != nil {
+			return fmt.Errorf("%w: invalid batch: %v", common.ErrInvalidRequest, err)
+		}
+	}
+	if options.Compression != nil {
+		if err := options.Compression.Validate(); err != nil {
+			return fmt.Errorf("%w: invalid compression: %v", common.ErrInvalidRequest, err)
+		}
+		// The others would store the compressed bytes as the file itself
+		if destType != DestinationS3 {
+			return fmt.Errorf("%w: compression is only supported for S3 destinations", common.ErrInvalidRequest)
+		}
+		// S3 readers do not decode objects, so the user has to accept .gz objects
+		if !options.Compression.GzipAtRest {
+			return fmt.Errorf("%w: compressed files are stored as .gz objects on S3, set gzip_at_rest to accept this", common.ErrInvalidRequest)
+		}
+	}
+	if options.Dedupe != nil {
+		if err := options.Dedupe.Validate(); err != nil {
+			return fmt.Errorf("%w: invalid dedupe: %v", common.ErrInvalidRequest, err)
+		}
+		// Only these implement CopyingDestination
+		if options.Dedupe.Mode == DedupeCopy && destType != DestinationLocal && destType != DestinationS3 {
+			return fmt.Errorf("%w: dedupe mode %q is only supported for local and S3 destinations, use %q", common.ErrInvalidRequest, DedupeCopy, DedupeSkip)
+		}
+	}
+	return nil
 }
 
 var migrationService *MigrationService
@@ -59,29 +129,115 @@ func GetMigrationService() *MigrationService {
 	return migrationService
 }
 
-func (s *MigrationService) CreateTask(sourceFolders []string, host, username, password, basePath string, options MigrationOptions) (string, error) {
+// TaskSpec describes a migration task to create
+type TaskSpec struct {
+	SourceFolders []string
+	Host          string
+	Username      string
+	Password      string
+	BasePath      string
+	Options       MigrationOptions
+	Priority      int    // Higher priority tasks are dequeued first
+	ScheduleID    string // Set when the task was created by a schedule
+	TargetID      string // Saved target to connect with, replaces Host/Username/Password
+
+	// Non-ZimaOS destinations use Host, Username and Password as the URL and
+	// credentials of the destination
+	DestinationType    string // Empty means ZimaOS
+	DestinationOptions DestinationOptions
+
+	SourceTargetID string // Saved ZimaOS target to read SourceFolders from, empty for the configured source
+}
+
+func (s *MigrationService) CreateTask(spec TaskSpec) (string, error) {
 	taskID := uuid.New().String()
 
-	optionsJSON, err := json.Marshal(options)
+	if spec.DestinationType == "" {
+		spec.DestinationType = DestinationZimaOS
+	}
+
+	var destinationOptions string
+	if spec.DestinationType != DestinationZimaOS {
+		options, err := spec.prepareDestination()
+		if err != nil {
+			return "", err
+		}
+		destinationOptions = options
+	} else if spec.TargetID != "" {
+		target, err := GetTargetService().GetTarget(spec.TargetID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return "", fmt.Errorf("%w: target %s not found", common.ErrInvalidRequest, spec.TargetID)
+			}
+			return "", fmt.Errorf("failed to load target: %w", err)
+		}
+
+		// Host and username are kept for display and per-target limits only;
+		// the worker always connects with the target's current credentials
+		spec.Host = target.ZimaOSHost
+		spec.Username = target.ZimaOSUsername
+		spec.Password = ""
+		if spec.BasePath == "" {
+			spec.BasePath = target.BasePath
+		}
+	} else if spec.Host == "" || spec.Username == "" || spec.Password == "" {
+		return "", fmt.Errorf("%w: either target_id or ZimaOS host, username and password are required", common.ErrInvalidRequest)
+	} else {
+		host, err := NormalizeHost(spec.Host, "http")
+		if err != nil {
+			return "", err
+		}
+		spec.Host = host
+	}
+	if spec.BasePath == "" {
+		return "", fmt.Errorf("%w: base_path is required", common.ErrInvalidRequest)
+	}
+	if err := validateOptions(spec.Options, spec.DestinationType); err != nil {
+		return "", err
+	}
+	if spec.SourceTargetID != "" {
+		if _, err := GetTargetService().GetTarget(spec.SourceTargetID); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return "", fmt.Errorf("%w: source target %s not found", common.ErrInvalidRequest, spec.SourceTargetID)
+			}
+			return "", fmt.Errorf("failed to load source target: %w", err)
+		}
+	}
+
+	optionsJSON, err := json.Marshal(spec.Options)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal options: %w", err)
 	}
 
-	sourceFoldersJSON, err := json.Marshal(sourceFolders)
+	sourceFoldersJSON, err := json.Marshal(spec.SourceFolders)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal source folders: %w", err)
 	}
 
+	// Schedules pass their already encrypted password through
+	password, err := common.ResealSecret(spec.Password)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt credentials: %w", err)
+	}
+
 	task := &models.MigrationTask{
-		TaskID:         taskID,
-		Status:         models.StatusPending,
-		SourceFolders:  string(sourceFoldersJSON),
-		ZimaOSHost:     host,
-		ZimaOSUsername: username,
-		ZimaOSPassword: password,
-		BasePath:       basePath,
-		Options:        string(optionsJSON),
-		Progress:       0,
+		TaskID:        taskID,
+		Status:        models.StatusPending,
+		SourceFolders: string(sourceFoldersJSON),
+		DestURL:       spec.Host,
+		DestUsername:  spec.Username,
+		DestSecret:    password,
+		BasePath:      spec.BasePath,
+		Options:       string(optionsJSON),
+		ScheduleID:    spec.ScheduleID,
+		TargetID:      spec.TargetID,
+		Priority:      spec.Priority,
+		QueueOrder:    time.Now().UnixNano(),
+		Progress:      0,
+
+		DestinationType:    spec.DestinationType,
+		DestinationOptions: destinationOptions,
+		SourceTargetID:     spec.SourceTargetID,
 	}
 
 	if err := models.DB.Create(task).Error; err != nil {
@@ -92,6 +248,56 @@ func (s *MigrationService) CreateTask(sourceFolders []string, host, username, pa
 	return taskID, nil
 }
 
+// CheckBasePath connects to the ZimaOS destination of spec and checks that
+// its base path is an existing folder. An unreachable instance is not an
+// error: the task waits in the queue and fails there if the path is wrong.
+func (s *MigrationService) CheckBasePath(spec TaskSpec) error {
+	if spec.DestinationType != "" && spec.DestinationType != DestinationZimaOS {
+		return nil
+	}
+
+	// Missing targets and fields are reported by CreateTask
+	var client *ZimaOSClient
+	if spec.TargetID != "" {
+		target, err := GetTargetService().GetTarget(spec.TargetID)
+		if err != nil {
+			return nil
+		}
+		if spec.BasePath == "" {
+			spec.BasePath = target.BasePath
+		}
+		if client, err = GetTargetService().NewClient(target); err != nil {
+			return nil
+		}
+	} else {
+		if spec.Host == "" {
+			return nil
+		}
+		// Plaintext from the API, or encrypted when passed on by a schedule
+		password := spec.Password
+		if common.IsEncryptedSecret(password) {
+			var err error
+			if password, err = common.DecryptSecret(password); err != nil {
+				return nil
+			}
+		}
+		client = NewZimaOSClient(spec.Host, spec.Username, password)
+	}
+	if spec.BasePath == "" {
+		return nil
+	}
+
+	err := client.CheckFolder(spec.BasePath)
+	if err != nil && !errors.Is(err, common.ErrInvalidRequest) {
+		common.Warnf("Could not check base path %s: %v", spec.BasePath, err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("base_path: %w", err)
+	}
+	return nil
+}
+
 func (s *MigrationService) GetTask(taskID string) (*models.MigrationTask, error) {
 	var task models.MigrationTask
 	if err := models.DB.Where("task_id = ?", taskID).First(&task).Error; err != nil {
@@ -119,9 +325,13 @@ func (s *MigrationService) GetTaskStatus(taskID string) (*TaskStatus, error) {
 		cachedStatus := status.(*TaskStatus)
 		// Fill path information into cached status
 		cachedStatus.SourceFolders = sourceFolders
-		cachedStatus.ZimaOSHost = task.ZimaOSHost
+		cachedStatus.DestURL = task.DestURL
+		cachedStatus.ZimaOSHost = task.DestURL
 		cachedStatus.BasePath = task.BasePath
 		cachedStatus.Error = task.Error
+		cachedStatus.Priority = task.Priority
+		cachedStatus.QueuePosition = s.queuePosition(task)
+		cachedStatus.RefreshWireSize()
 		return cachedStatus, nil
 	}
 
@@ -135,13 +345,21 @@ func (s *MigrationService) GetTaskStatus(taskID string) (*TaskStatus, error) {
 		TotalSize:       task.TotalSize,
 		Progress:        task.Progress,
 		FailedFiles:     task.FailedFiles,
+		WireSize:        task.WireSize,
+		DedupedFiles:    task.DedupedFiles,
+		DedupedSize:     task.DedupedSize,
 		UpdatedAt:       task.UpdatedAt,
 
 		// Fill path information
 		SourceFolders: sourceFolders,
-		ZimaOSHost:    task.ZimaOSHost,
+		DestURL:       task.DestURL,
+		ZimaOSHost:    task.DestURL,
 		BasePath:      task.BasePath,
 		Error:         task.Error,
+
+		// Fill queue information
+		Priority:      task.Priority,
+		QueuePosition: s.queuePosition(task),
 	}, nil
 }
 
@@ -149,8 +367,26 @@ func (s *MigrationService) UpdateTaskStatus(taskID string, status *TaskStatus) {
 	s.taskStatus.Store(taskID, status)
 }
 
+// UpdateTask saves the whole task row except options and credentials. Options
+// can be changed while a worker is running, so they are only written by
+// UpdateTaskOptions; credentials are never rewritten and are purged once the
+// task reaches a terminal state.
 func (s *MigrationService) UpdateTask(task *models.MigrationTask) error {
-	return models.DB.Save(task).Error
+	if err := models.DB.Omit("options", "dest_secret").Save(task).Error; err != nil {
+		return err
+	}
+	if models.IsTerminalStatus(task.Status) {
+		return s.purgeCredentials(task.TaskID)
+	}
+	return nil
+}
+
+func (s *MigrationService) UpdateTaskOptions(taskID string, options MigrationOptions) error {
+	optionsJSON, err := json.Marshal(options)
+	if err != nil {
+		return fmt.Errorf("failed to marshal options: %w", err)
+	}
+	return models.DB.Model(&models.MigrationTask{}).Where("task_id = ?", taskID).Update("options", string(optionsJSON)).Error
 }
 
 func (s *MigrationService) ListTasks(limit, offset int) ([]*models.MigrationTask, int64, error) {
@@ -168,6 +404,68 @@ func (s *MigrationService) ListTasks(limit, offset int) ([]*models.MigrationTask
 	return tasks, total, nil
 }
 
+// ListTasksFor lists the tasks visible to user. Users restricted to some
+// paths are filtered in memory, as visibility depends on the source folders.
+func (s *MigrationService) ListTasksFor(user *models.User, limit, offset int) ([]*models.MigrationTask, int64, error) {
+	if AllowedPaths(user) == nil {
+		return s.ListTasks(limit, offset)
+	}
+
+	var all []*models.MigrationTask
+	if err := models.DB.Order("created_at desc").Find(&all).Error; err != nil {
+		return nil, 0, err
+	}
+
+	visible := make([]*models.MigrationTask, 0, len(all))
+	for _, task := range all {
+		if CanAccessTask(user, task) {
+			visible = append(visible, task)
+		}
+	}
+
+	total := int64(len(visible))
+	if offset >= len(visible) {
+		return []*models.MigrationTask{}, total, nil
+	}
+	end := offset + limit
+	if end > len(visible) {
+		end = len(visible)
+	}
+	return visible[offset:end], total, nil
+}
+
+// UpdateTaskLimits persists a new per-task bandwidth policy and applies it
+// to the running upload, if any. It returns whether the live limiter was updated.
+func (s *MigrationService) UpdateTaskLimits(taskID string, policy BandwidthPolicy) (bool, error) {
+	if err := policy.Validate(); err != nil {
+		return false, fmt.Errorf("%w: %v", common.ErrInvalidRequest, err)
+	}
+
+	task, err := s.GetTask(taskID)
+	if err != nil {
+		return false, err
+	}
+
+	if models.IsTerminalStatus(task.Status) {
+		return false, fmt.Errorf("%w: task is already %s", common.ErrInvalidStatus, task.Status)
+	}
+
+	var options MigrationOptions
+	if task.Options != "" {
+		if err := json.Unmarshal([]byte(task.Options), &options); err != nil {
+			return false, fmt.Errorf("failed to parse options: %w", err)
+		}
+	}
+	options.Bandwidth = &policy
+	if err := s.UpdateTaskOptions(taskID, options); err != nil {
+		return false, err
+	}
+
+	applied := GetBandwidthManager().SetTaskPolicy(taskID, policy)
+	common.Infof("Updated bandwidth limits for task %s (live: %v)", taskID, applied)
+	return applied, nil
+}
+
 func (s *MigrationService) CancelTask(taskID string) error {
 	task, err := s.GetTask(taskID)
 	if err != nil {
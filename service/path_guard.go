@@ -0,0 +1,69 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/atopos31/stoz/config"
+)
+
+// Reasons a source path is rejected
+const (
+	PathInvalid        = "invalid_path"
+	PathNotFound       = "not_found"
+	PathOutsideVolumes = "outside_volumes"
+)
+
+// PathViolation is returned for source paths outside HOST_PATH/volume*
+type PathViolation struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+	Detail string `json:"detail"`
+}
+
+func (v *PathViolation) Error() string {
+	return "source path " + v.Path + " rejected (" + v.Reason + "): " + v.Detail
+}
+
+// hostRoot returns HOST_PATH with symlinks resolved
+func hostRoot() string {
+	root := filepath.Clean(config.AppConfig.Scan.HostPath)
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		return resolved
+	}
+	return root
+}
+
+// IsWithinVolumes reports whether a fully resolved path lies in a
+// HOST_PATH/volume* directory
+func IsWithinVolumes(resolved string) bool {
+	rel, err := filepath.Rel(hostRoot(), resolved)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	volume := strings.SplitN(filepath.ToSlash(rel), "/", 2)[0]
+	return strings.HasPrefix(volume, "volume")
+}
+
+// resolveLocalSourcePath resolves ".." and symlinks in path and returns the
+// real path if it lies under HOST_PATH/volume*
+func resolveLocalSourcePath(path string) (string, error) {
+	if path == "" || !filepath.IsAbs(path) || strings.ContainsRune(path, 0) {
+		return "", &PathViolation{Path: path, Reason: PathInvalid, Detail: "path must be absolute"}
+	}
+
+	resolved, err := filepath.EvalSymlinks(filepath.Clean(path))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", &PathViolation{Path: path, Reason: PathNotFound, Detail: "path does not exist"}
+		}
+		return "", &PathViolation{Path: path, Reason: PathInvalid, Detail: err.Error()}
+	}
+
+	if !IsWithinVolumes(resolved) {
+		return "", &PathViolation{Path: path, Reason: PathOutsideVolumes, Detail: "only folders inside the mounted Synology volumes can be used"}
+	}
+	return resolved, nil
+}
@@ -0,0 +1,157 @@
+package service_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/atopos31/stoz/config"
+	"github.com/atopos31/stoz/service"
+)
+
+// hostTree builds a HOST_PATH with Synology volumes next to folders that must
+// stay out of reach, points the config at it through a symlink and returns the
+// directory holding it all
+func hostTree(t *testing.T) string {
+	t.Helper()
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{
+		"host/volume1/photos",
+		"host/volumeUSB1/share",
+		"host/other",
+		"hostX/volume1/photos",
+		"outside",
+	} {
+		if err := os.MkdirAll(filepath.Join(base, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"host/volume1/photos/a.jpg", "outside/secret.txt", "hostX/volume1/photos/b.jpg"} {
+		if err := os.WriteFile(filepath.Join(base, file), []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"host/volume1/escape":            filepath.Join(base, "outside"),
+		"host/volume1/sibling":           filepath.Join(base, "hostX/volume1/photos"),
+		"host/volume1/inside":            filepath.Join(base, "host/volume1/photos"),
+		"host/volume1/photos/leak.txt":   filepath.Join(base, "outside/secret.txt"),
+		"host/volume1/photos/copy.jpg":   "a.jpg",
+		"host/volume1/photos/broken.jpg": "missing.jpg",
+		"hostlink":                       filepath.Join(base, "host"),
+	} {
+		if err := os.Symlink(target, filepath.Join(base, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hostPath := config.AppConfig.Scan.HostPath
+	config.AppConfig.Scan.HostPath = filepath.Join(base, "hostlink")
+	t.Cleanup(func() { config.AppConfig.Scan.HostPath = hostPath })
+	return base
+}
+
+func TestResolveLocalSourcePath(t *testing.T) {
+	base := hostTree(t)
+	host := filepath.Join(base, "host")
+	photos := filepath.Join(host, "volume1/photos")
+
+	tests := []struct {
+		path   string
+		want   string // Resolved path when accepted
+		reason string // PathViolation reason when rejected
+	}{
+		{path: photos, want: photos},
+		{path: filepath.Join(base, "hostlink/volume1/photos"), want: photos},
+		{path: host + "/volume1/photos/../photos/", want: photos},
+		{path: host + "/volume1", want: host + "/volume1"},
+		{path: host + "/volumeUSB1/share", want: host + "/volumeUSB1/share"},
+		{path: host + "/volume1/inside", want: photos},
+		// ".." is applied before symlinks, and the result is what gets used
+		{path: host + "/volume1/escape/../photos", want: photos},
+		// Traversal out of the volumes
+		{path: host + "/volume1/../other", reason: service.PathOutsideVolumes},
+		{path: host + "/volume1/../../outside", reason: service.PathOutsideVolumes},
+		{path: host + "/volume1/photos/../../../hostX/volume1/photos", reason: service.PathOutsideVolumes},
+		{path: host + "/volume1/..", reason: service.PathOutsideVolumes},
+		{path: "/", reason: service.PathOutsideVolumes},
+		// A sibling of HOST_PATH whose name starts the same
+		{path: filepath.Join(base, "hostX/volume1/photos"), reason: service.PathOutsideVolumes},
+		// Symlinks leading out of the volumes
+		{path: host + "/volume1/escape", reason: service.PathOutsideVolumes},
+		{path: host + "/volume1/sibling", reason: service.PathOutsideVolumes},
+		{path: host + "/volume1/missing", reason: service.PathNotFound},
+		{path: "volume1/photos", reason: service.PathInvalid},
+		{path: "", reason: service.PathInvalid},
+		{path: photos + "\x00", reason: service.PathInvalid},
+	}
+	source := &service.LocalSource{}
+	for _, tt := range tests {
+		got, err := source.Resolve(tt.path)
+		if tt.reason == "" {
+			if err != nil || got != tt.want {
+				t.Errorf("Resolve(%q) = %q, %v, want %q", tt.path, got, err, tt.want)
+			}
+			continue
+		}
+		var violation *service.PathViolation
+		if !errors.As(err, &violation) || violation.Reason != tt.reason {
+			t.Errorf("Resolve(%q) = %q, %v, want a %s violation", tt.path, got, err, tt.reason)
+		}
+	}
+}
+
+func TestIsWithinVolumes(t *testing.T) {
+	base := hostTree(t)
+	host := filepath.Join(base, "host")
+
+	for path, want := range map[string]bool{
+		host + "/volume1":             true,
+		host + "/volume1/photos":      true,
+		host + "/volume12/photos":     true,
+		host + "/volumeUSB1/share":    true, // Synology USB drives, listed like volumes
+		host:                          false,
+		host + "/other":               false,
+		host + "/xvolume1":            false,
+		host + "/@volume1":            false,
+		base + "/hostX/volume1":       false,
+		base + "/volume1":             false,
+		"/":                           false,
+		base + "/hostlink/volume1":    false, // Must already be resolved
+		host + "/volume1/../outside/": false,
+	} {
+		if got := service.IsWithinVolumes(filepath.Clean(path)); got != want {
+			t.Errorf("IsWithinVolumes(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestLocalSourceWalkSkipsSymlinkEscapes(t *testing.T) {
+	base := hostTree(t)
+	root := filepath.Join(base, "host/volume1")
+
+	var files []string
+	err := (&service.LocalSource{}).Walk(root, func(path string, info fs.FileInfo) error {
+		if !info.IsDir() {
+			rel, _ := filepath.Rel(root, path)
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+
+	// Directory symlinks are not followed, file symlinks only inside the volumes
+	want := []string{"photos/a.jpg", "photos/copy.jpg"}
+	if len(files) != len(want) || files[0] != want[0] || files[1] != want[1] {
+		t.Errorf("walked %v, want %v", files, want)
+	}
+}
@@ -0,0 +1,642 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/config"
+)
+
+const (
+	s3PartSize       = 64 << 20 // Multipart upload above this size
+	s3StreamPartSize = 16 << 20 // Buffered part of uploads of unknown size
+	s3MaxParts       = 10000
+	s3UnsignedBody   = "UNSIGNED-PAYLOAD"
+	s3MtimeMetadata  = "X-Amz-Meta-Mtime"     // Unix seconds, same convention as rclone
+	s3SizeMetadata   = "X-Amz-Meta-Stoz-Size" // Original size of an object stored compressed
+	s3GzipSuffix     = ".gz"                  // Appended to the keys of files stored compressed
+)
+
+// S3Destination uploads to an S3-compatible bucket (AWS, MinIO, ...) with
+// Signature Version 4. Folders do not exist in S3; keys are the remote
+// paths without the leading slash.
+type S3Destination struct {
+	endpoint       *url.URL
+	bucket         string
+	region         string
+	virtualHosted  bool
+	accessKey      string
+	secretKey      string
+	client         *http.Client
+	uploadClient   *http.Client
+	downloadClient *http.Client
+	copyClient     *http.Client
+	limiters       []*BandwidthLimiter
+	source         Source
+	compression    *CompressionPolicy
+}
+
+func NewS3Destination(endpoint, accessKey, secretKey string, opts DestinationOptions) (*S3Destination, error) {
+	base, err := normalizeDestinationURL(DestinationS3, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("%w: bucket is required for S3", common.ErrInvalidRequest)
+	}
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("%w: access key and secret key are required for S3", common.ErrInvalidRequest)
+	}
+	region := opts.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	transport, err := sharedTransport(base, opts.TLSOptions)
+	if err != nil {
+		return nil, err
+	}
+	if opts.InsecureSkipVerify {
+		common.Warnf("TLS certificate verification is disabled for %s", base)
+	}
+
+	// A server-side copy may only answer once the object is copied
+	copyTransport := transport.Clone()
+	copyTransport.ResponseHeaderTimeout = 0
+
+	u, _ := url.Parse(base) // Validated by normalizeDestinationURL
+	cfg := config.AppConfig.Dest
+	return &S3Destination{
+		endpoint:       u,
+		bucket:         opts.Bucket,
+		region:         region,
+		virtualHosted:  opts.VirtualHosted,
+		accessKey:      accessKey,
+		secretKey:      secretKey,
+		client:         &http.Client{Timeout: cfg.S3Timeout, Transport: transport},
+		uploadClient:   &http.Client{Transport: transport},
+		downloadClient: &http.Client{Timeout: cfg.S3DownloadTimeout, Transport: transport},
+		copyClient:     &http.Client{Timeout: cfg.S3CopyTimeout, Transport: copyTransport},
+	}, nil
+}
+
+// s3Escape encodes s as SigV4 requires: everything but unreserved
+// characters, and '/' unless it separates key segments
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (keepSlash && c == '/') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// objectURL returns the URL of key ("" for the bucket) with the canonical
+// query built from query
+func (d *S3Destination) objectURL(key string, query url.Values) *url.URL {
+	u := *d.endpoint
+	p := strings.TrimRight(u.Path, "/")
+	if d.virtualHosted {
+		u.Host = d.bucket + "." + u.Host
+	} else {
+		p += "/" + d.bucket
+	}
+	if key != "" {
+		p += "/" + strings.TrimPrefix(key, "/")
+	} else if !d.virtualHosted {
+		p += "/"
+	}
+	if p == "" {
+		p = "/"
+	}
+	u.Path = p
+	u.RawPath = s3Escape(p, true)
+
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, s3Escape(k, false)+"="+s3Escape(query.Get(k), false))
+	}
+	u.RawQuery = strings.Join(pairs, "&")
+	return &u
+}
+
+// sign adds SigV4 headers. payloadHash is the hex SHA-256 of the body or
+// UNSIGNED-PAYLOAD for streamed uploads.
+func (d *S3Destination) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" || lower == "content-md5" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + d.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+d.secretKey), date)
+	key = hmacSHA256(key, d.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		d.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// do sends a signed request with a small in-memory body
+func (d *S3Destination) do(ctx context.Context, client *http.Client, method, key string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, d.objectURL(key, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	sum := sha256.Sum256(body)
+	d.sign(req, hex.EncodeToString(sum[:]), time.Now())
+	return client.Do(req)
+}
+
+// s3Error formats a failed response, including the S3 error code if any
+func s3Error(action string, resp *http.Response) error {
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	var s3Err struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(bodyBytes, &s3Err) == nil && s3Err.Code != "" {
+		return fmt.Errorf("%s failed with status %d: %s: %s", action, resp.StatusCode, s3Err.Code, s3Err.Message)
+	}
+	return fmt.Errorf("%s failed with status %d: %s", action, resp.StatusCode, string(bodyBytes))
+}
+
+// Login checks that the bucket exists and the keys may access it
+func (d *S3Destination) Login() error {
+	resp, err := d.do(context.Background(), d.client, "HEAD", "", nil, nil, nil)
+	if err != nil {
+		return fmt.Errorf("S3 request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		common.Infof("Connected to S3 bucket %s at %s", d.bucket, d.endpoint.Host)
+		return nil
+	case http.StatusNotFound:
+		return fmt.Errorf("bucket %s does not exist", d.bucket)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: access to bucket %s denied", common.ErrAuthFailed, d.bucket)
+	case http.StatusMovedPermanently, http.StatusBadRequest:
+		if region := resp.Header.Get("X-Amz-Bucket-Region"); region != "" && region != d.region {
+			return fmt.Errorf("bucket %s is in region %s, not %s", d.bucket, region, d.region)
+		}
+	}
+	return fmt.Errorf("bucket check failed with status %d", resp.StatusCode)
+}
+
+func (d *S3Destination) SetBandwidthLimiters(limiters ...*BandwidthLimiter) {
+	d.limiters = limiters
+}
+
+func (d *S3Destination) SetSource(source Source) {
+	d.source = source
+}
+
+// SetCompression stores compressible files gzipped as <key>.gz objects
+// with their original size in metadata. S3 tools download them as gzip
+// files; STOZ finds and decodes them when it reads the file back.
+func (d *S3Destination) SetCompression(policy CompressionPolicy) {
+	d.compression = &policy
+}
+
+// CreateFolder is a no-op: S3 keys need no parent objects
+func (d *S3Destination) CreateFolder(path string) error {
+	return nil
+}
+
+// UploadFile uses a single PUT up to s3PartSize and a multipart upload above
+func (d *S3Destination) UploadFile(ctx context.Context, localPath, remotePath string, onProgress func(delta int64)) error {
+	upload, err := openCompressedUpload(ctx, d.source, localPath, d.compression, d.limiters, onProgress)
+	if err != nil {
+		return err
+	}
+	defer upload.file.Close()
+
+	uploadCtx, watch, stopWatch := withStallWatch(ctx, localPath)
+	defer stopWatch()
+
+	key, stale := remotePath, remotePath+s3GzipSuffix
+	header := http.Header{s3MtimeMetadata: {strconv.FormatInt(upload.stat.ModTime().Unix(), 10)}}
+	if upload.encoding != "" {
+		key, stale = stale, key
+		header.Set("Content-Type", "application/gzip")
+		header.Set(s3SizeMetadata, strconv.FormatInt(upload.stat.Size(), 10))
+	}
+	switch {
+	case upload.size < 0:
+		err = d.streamUpload(uploadCtx, watch, key, upload.reader, header)
+	case upload.size <= s3PartSize:
+		err = d.putStream(uploadCtx, watch, key, nil, upload.reader, upload.size, header, nil)
+	default:
+		err = d.multipartUpload(uploadCtx, watch, key, header, fixedParts(upload.reader, upload.size))
+	}
+	if err != nil {
+		if stalled := stalledError(ctx, uploadCtx); stalled != nil {
+			return fmt.Errorf("upload request failed: %w", stalled)
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("upload cancelled by user")
+		}
+		return err
+	}
+
+	// A copy stored the other way by an earlier run would be found instead
+	if d.compression != nil {
+		d.deleteStale(ctx, stale)
+	}
+
+	common.Infof("File uploaded successfully: %s -> s3://%s/%s", localPath, d.bucket, strings.TrimPrefix(key, "/"))
+	return nil
+}
+
+// deleteStale removes an outdated object, which is not an error if missing
+func (d *S3Destination) deleteStale(ctx context.Context, key string) {
+	resp, err := d.do(ctx, d.client, "DELETE", key, nil, nil, nil)
+	if err != nil {
+		common.Warnf("Failed to delete outdated object %s: %v", key, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		common.Warnf("Failed to delete outdated object %s: %v", key, s3Error("delete", resp))
+	}
+}
+
+// headObject finds the object holding filePath: its own key, or the .gz key
+// of a file STOZ stored compressed. It returns an empty key if neither exists.
+func (d *S3Destination) headObject(ctx context.Context, filePath string) (string, *http.Response, error) {
+	for _, key := range []string{filePath, filePath + s3GzipSuffix} {
+		resp, err := d.do(ctx, d.client, "HEAD", key, nil, nil, nil)
+		if err != nil {
+			return "", nil, fmt.Errorf("request failed: %w", err)
+		}
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			continue
+		case resp.StatusCode != http.StatusOK:
+			return "", nil, fmt.Errorf("HEAD object failed with status %d", resp.StatusCode)
+		case key != filePath && resp.Header.Get(s3SizeMetadata) == "":
+			continue // A .gz file of its own, not a compressed copy of filePath
+		}
+		return key, resp, nil
+	}
+	return "", nil, nil
+}
+
+// putStream PUTs size bytes of body and returns the ETag through etag
+func (d *S3Destination) putStream(ctx context.Context, watch *stallWatch, key string, query url.Values, body io.Reader, size int64, header http.Header, etag *string) error {
+	watch.touch(true)
+	req, err := http.NewRequestWithContext(ctx, "PUT", d.objectURL(key, query).String(), &watchedBody{reader: io.NopCloser(body), watch: watch})
+	if err != nil {
+		return fmt.Errorf("failed to create upload request: %w", err)
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	d.sign(req, s3UnsignedBody, time.Now())
+
+	resp, err := d.uploadClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("upload request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s3Error("upload", resp)
+	}
+	if etag != nil {
+		*etag = resp.Header.Get("ETag")
+	}
+	return nil
+}
+
+type s3CompleteMultipart struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []s3Part `xml:"Part"`
+}
+
+type s3Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// s3Parts returns the next part of a multipart upload, or a zero size at
+// the end
+type s3Parts func() (io.Reader, int64, error)
+
+// fixedParts splits size bytes of source into as few parts of at least
+// s3PartSize as the part limit allows
+func fixedParts(source io.Reader, size int64) s3Parts {
+	partSize := int64(s3PartSize)
+	if minimum := (size + s3MaxParts - 1) / s3MaxParts; minimum > partSize {
+		partSize = minimum
+	}
+	var offset int64
+	return func() (io.Reader, int64, error) {
+		n := min(partSize, size-offset)
+		offset += n
+		return io.LimitReader(source, n), n, nil
+	}
+}
+
+// streamUpload uploads source, whose size is unknown, with a single PUT
+// when it fits in one buffered part and as a multipart upload otherwise.
+// Parts start at s3StreamPartSize and double every 1000 parts.
+func (d *S3Destination) streamUpload(ctx context.Context, watch *stallWatch, key string, source io.Reader, header http.Header) error {
+	buf := make([]byte, s3StreamPartSize)
+	n, err := io.ReadFull(source, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return d.putStream(ctx, watch, key, nil, bytes.NewReader(buf[:n]), int64(n), header, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+
+	first, number := true, 0
+	return d.multipartUpload(ctx, watch, key, header, func() (io.Reader, int64, error) {
+		number++
+		if first {
+			first = false
+			return bytes.NewReader(buf), int64(len(buf)), nil
+		}
+		if number%1000 == 0 {
+			buf = make([]byte, 2*len(buf))
+		}
+		n, err := io.ReadFull(source, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, 0, fmt.Errorf("failed to read file: %w", err)
+		}
+		return bytes.NewReader(buf[:n]), int64(n), nil
+	})
+}
+
+// multipartUpload uploads the parts returned by next. header carries the
+// object's metadata and is sent when the upload is started.
+func (d *S3Destination) multipartUpload(ctx context.Context, watch *stallWatch, key string, header http.Header, next s3Parts) error {
+	resp, err := d.do(ctx, d.client, "POST", key, url.Values{"uploads": {""}}, nil, header)
+	if err != nil {
+		return fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return s3Error("start multipart upload", resp)
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil || initiated.UploadID == "" {
+		return fmt.Errorf("invalid multipart upload response: %v", err)
+	}
+
+	completed := false
+	defer func() {
+		if completed {
+			return
+		}
+		// Abort so the bucket does not keep the parts already stored, also
+		// when the upload was cancelled
+		resp, err := d.do(context.Background(), d.client, "DELETE", key, url.Values{"uploadId": {initiated.UploadID}}, nil, nil)
+		if err != nil {
+			common.Warnf("Failed to abort multipart upload of %s: %v", key, err)
+			return
+		}
+		resp.Body.Close()
+	}()
+
+	var complete s3CompleteMultipart
+	for number := 1; ; number++ {
+		part, n, err := next()
+		if err != nil {
+			return fmt.Errorf("part %d: %w", number, err)
+		}
+		if n == 0 {
+			break
+		}
+		if number > s3MaxParts {
+			return fmt.Errorf("file needs more than %d parts", s3MaxParts)
+		}
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {initiated.UploadID}}
+		var etag string
+		if err := d.putStream(ctx, watch, key, query, part, n, nil, &etag); err != nil {
+			return fmt.Errorf("part %d: %w", number, err)
+		}
+		complete.Parts = append(complete.Parts, s3Part{PartNumber: number, ETag: etag})
+	}
+
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return fmt.Errorf("failed to encode part list: %w", err)
+	}
+	resp, err = d.do(ctx, d.uploadClient, "POST", key, url.Values{"uploadId": {initiated.UploadID}}, body, http.Header{"Content-Type": {"application/xml"}})
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Completion can fail after a 200 status; the error is then in the body
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || bytes.Contains(respBody, []byte("<Error>")) {
+		return fmt.Errorf("complete multipart upload failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+	completed = true
+	return nil
+}
+
+// CopyFile copies an object within the bucket. A compressed object is
+// copied to the .gz key of dstPath with its original size, and the copy gets
+// its own modification time; objects above 5 GiB cannot be copied in one
+// request.
+func (d *S3Destination) CopyFile(ctx context.Context, srcPath, dstPath string, modTime time.Time) error {
+	srcKey, head, err := d.headObject(ctx, srcPath)
+	if err != nil {
+		return err
+	}
+	if srcKey == "" {
+		return fmt.Errorf("%w: %s", common.ErrFileNotFound, srcPath)
+	}
+	dstKey := dstPath
+	if srcKey != srcPath {
+		dstKey += s3GzipSuffix
+	}
+
+	header := http.Header{
+		"X-Amz-Copy-Source":        {"/" + d.bucket + "/" + s3Escape(strings.TrimPrefix(srcKey, "/"), true)},
+		"X-Amz-Metadata-Directive": {"REPLACE"},
+		s3MtimeMetadata:            {strconv.FormatInt(modTime.Unix(), 10)},
+	}
+	if size := head.Header.Get(s3SizeMetadata); size != "" {
+		header.Set(s3SizeMetadata, size)
+	}
+	if contentType := head.Header.Get("Content-Type"); contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	resp, err := d.do(ctx, d.copyClient, "PUT", dstKey, nil, nil, header)
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("copy cancelled by user")
+		}
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error("copy", resp)
+	}
+	// A copy can fail after the 200 status has been sent
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if bytes.Contains(body, []byte("<Error>")) {
+		return s3Error("copy", &http.Response{StatusCode: resp.StatusCode, Body: io.NopCloser(bytes.NewReader(body))})
+	}
+
+	common.Infof("Object copied successfully: s3://%s/%s -> %s", d.bucket, strings.TrimPrefix(srcKey, "/"), strings.TrimPrefix(dstKey, "/"))
+	return nil
+}
+
+// GetFileInfo reads size and the stored modification time with a HEAD
+// request. Compressed objects report their original size.
+func (d *S3Destination) GetFileInfo(filePath string) (*FileMetadata, error) {
+	key, resp, err := d.headObject(context.Background(), filePath)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return nil, fmt.Errorf("%w: %s", common.ErrFileNotFound, filePath)
+	}
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if mtime, err := strconv.ParseFloat(resp.Header.Get(s3MtimeMetadata), 64); err == nil {
+		modTime = time.Unix(int64(mtime), 0)
+	}
+	size := resp.ContentLength
+	if original, err := strconv.ParseInt(resp.Header.Get(s3SizeMetadata), 10, 64); err == nil {
+		size = original
+	}
+	return fileMetadata(filePath, size, modTime, false), nil
+}
+
+// DownloadPartialFile returns the first size bytes of the original file
+func (d *S3Destination) DownloadPartialFile(filePath string, size int64) ([]byte, error) {
+	return d.DownloadRange(filePath, 0, size)
+}
+
+// DownloadRange returns size bytes of the original file at offset. A file
+// stored compressed is read from the start of its .gz object and decoded.
+func (d *S3Destination) DownloadRange(filePath string, offset, size int64) ([]byte, error) {
+	if size <= 0 {
+		return []byte{}, nil
+	}
+	resp, err := d.do(context.Background(), d.downloadClient, "GET", filePath, nil, nil, http.Header{"Range": {rangeHeader(offset, size)}})
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		key, _, err := d.headObject(context.Background(), filePath)
+		if err != nil {
+			return nil, err
+		}
+		if key == "" || key == filePath {
+			return nil, fmt.Errorf("%w: %s", common.ErrFileNotFound, filePath)
+		}
+		if resp, err = d.do(context.Background(), d.downloadClient, "GET", key, nil, nil, nil); err != nil {
+			return nil, fmt.Errorf("request failed: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, s3Error("download", resp)
+		}
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress: %w", err)
+		}
+		resp.Body = gz
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil, s3Error("download", resp)
+	}
+	return readRange(resp, offset, size)
+}
+
+func (d *S3Destination) Close() error {
+	return nil
+}
@@ -1,26 +1,38 @@
 package service
 
 import (
-	"io/fs"
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/atopos31/stoz/common"
 	"github.com/atopos31/stoz/config"
 	"github.com/atopos31/stoz/models"
+	"github.com/google/uuid"
 )
 
+const dedupeJobTTL = time.Hour // Finished duplicate analyses are kept this long
+
 type ScannerService struct {
 	cache      *models.ScanResult
 	cacheTime  time.Time
 	cacheMutex sync.RWMutex
+
+	dedupeMutex sync.Mutex
+	dedupeJobs  map[string]*dedupeJob
+}
+
+type dedupeJob struct {
+	job    models.DedupeJob // Guarded by dedupeMutex
+	hashed atomic.Int64
 }
 
 func NewScannerService() *ScannerService {
-	return &ScannerService{}
+	return &ScannerService{dedupeJobs: make(map[string]*dedupeJob)}
 }
 
 func (s *ScannerService) Scan() (*models.ScanResult, error) {
@@ -28,7 +40,7 @@ func (s *ScannerService) Scan() (*models.ScanResult, error) {
 	if s.cache != nil && time.Since(s.cacheTime) < config.AppConfig.Scan.CacheTTL {
 		defer s.cacheMutex.RUnlock()
 		common.Info("Returning cached scan result")
-		return s.cache, nil
+		return withFolderSizes(s.cache), nil
 	}
 	s.cacheMutex.RUnlock()
 
@@ -43,57 +55,49 @@ func (s *ScannerService) Scan() (*models.ScanResult, error) {
 	s.cacheTime = time.Now()
 	s.cacheMutex.Unlock()
 
-	return result, nil
+	return withFolderSizes(result), nil
 }
 
-func (s *ScannerService) performScan() (*models.ScanResult, error) {
-	hostPath := config.AppConfig.Scan.HostPath
-	entries, err := os.ReadDir(hostPath)
+// withFolderSizes returns a copy of result with the sizes and file counts
+// stored by the folder indexer. Shares it has not seen yet are requested.
+func withFolderSizes(result *models.ScanResult) *models.ScanResult {
+	var paths []string
+	for _, volume := range result.Volumes {
+		for _, folder := range volume.Folders {
+			paths = append(paths, folder.Path)
+		}
+	}
+	indexer := GetFolderIndexer()
+	entries, err := indexer.Lookup(paths)
 	if err != nil {
-		common.Errorf("Failed to read host path %s: %v", hostPath, err)
-		return nil, err
+		common.Errorf("Failed to load folder sizes: %v", err)
+		return result
 	}
 
-	var volumes []models.VolumeInfo
-	var wg sync.WaitGroup
-	volumeChan := make(chan models.VolumeInfo, 10)
-
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
-		}
-
-		name := entry.Name()
-		if !strings.HasPrefix(name, "volume") {
-			continue
-		}
-
-		wg.Add(1)
-		go func(volumeName string) {
-			defer wg.Done()
-
-			volumePath := filepath.Join(hostPath, volumeName)
-			folders, err := s.scanVolume(volumePath)
-			if err != nil {
-				common.Errorf("Failed to scan volume %s: %v", volumeName, err)
-				return
-			}
-
-			volumeChan <- models.VolumeInfo{
-				Name:    volumeName,
-				Path:    volumePath,
-				Folders: folders,
+	filled := &models.ScanResult{ScannedAt: result.ScannedAt, Volumes: make([]models.VolumeInfo, len(result.Volumes))}
+	for i, volume := range result.Volumes {
+		folders := make([]models.FolderInfo, len(volume.Folders))
+		for j, folder := range volume.Folders {
+			if entry, ok := entries[folder.Path]; ok {
+				indexedAt := entry.IndexedAt
+				folder.Size = entry.Size
+				folder.FileCount = entry.FileCount
+				folder.IndexedAt = &indexedAt
+			} else {
+				indexer.Request(folder.Path)
 			}
-		}(name)
+			folders[j] = folder
+		}
+		volume.Folders = folders
+		filled.Volumes[i] = volume
 	}
+	return filled
+}
 
-	go func() {
-		wg.Wait()
-		close(volumeChan)
-	}()
-
-	for volume := range volumeChan {
-		volumes = append(volumes, volume)
+func (s *ScannerService) performScan() (*models.ScanResult, error) {
+	volumes, err := GetSource().ListVolumes()
+	if err != nil {
+		return nil, err
 	}
 
 	return &models.ScanResult{
@@ -102,91 +106,112 @@ func (s *ScannerService) performScan() (*models.ScanResult, error) {
 	}, nil
 }
 
-func (s *ScannerService) scanVolume(volumePath string) ([]models.FolderInfo, error) {
-	entries, err := os.ReadDir(volumePath)
+// GetFolderDetails returns the size of a folder from the folder index and
+// never walks it here. A folder without an entry is queued for the indexer
+// and returned without IndexedAt; a stale entry is returned and refreshed.
+func (s *ScannerService) GetFolderDetails(folderPath string, includeRecycle bool) (*models.FolderInfo, error) {
+	info, err := GetSource().Stat(folderPath)
 	if err != nil {
 		return nil, err
 	}
 
-	folders := make([]models.FolderInfo, 0)
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
-		}
-
-		name := entry.Name()
-		if strings.HasPrefix(name, "@") {
-			continue
-		}
-
-		folderPath := filepath.Join(volumePath, name)
-		info, err := entry.Info()
-		if err != nil {
-			common.Warnf("Failed to get info for %s: %v", folderPath, err)
-			continue
-		}
-
-		folderInfo := models.FolderInfo{
-			Path:         folderPath,
-			Name:         name,
-			ModifiedTime: info.ModTime(),
-			Size:         0,
-			FileCount:    0,
-		}
-
-		folders = append(folders, folderInfo)
+	if !info.IsDir() {
+		return nil, os.ErrInvalid
 	}
 
-	return folders, nil
-}
+	details := &models.FolderInfo{
+		Path:         folderPath,
+		Name:         filepath.Base(folderPath),
+		ModifiedTime: info.ModTime(),
+	}
 
-func (s *ScannerService) GetFolderDetails(folderPath string, includeRecycle bool) (*models.FolderInfo, error) {
-	info, err := os.Stat(folderPath)
+	indexer := GetFolderIndexer()
+	entries, err := indexer.Lookup([]string{folderPath})
 	if err != nil {
 		return nil, err
 	}
-
-	if !info.IsDir() {
-		return nil, os.ErrInvalid
+	entry, ok := entries[folderPath]
+	if !ok || indexer.Stale(entry) {
+		indexer.Request(folderPath)
+	}
+	if ok {
+		details.Size = entry.Size
+		details.FileCount = entry.FileCount
+		if includeRecycle {
+			details.Size += entry.RecycleSize
+			details.FileCount += entry.RecycleFiles
+		}
+		details.IndexedAt = &entry.IndexedAt
 	}
+	return details, nil
+}
 
-	var totalSize int64
-	var fileCount int
+// StartDedupe starts a duplicate analysis of folders in the background. Only
+// one analysis runs at a time, as it reads every candidate file.
+func (s *ScannerService) StartDedupe(folders []string, includeRecycle bool, minSize int64) (*models.DedupeJob, error) {
+	if minSize < 0 {
+		return nil, fmt.Errorf("%w: min_size must not be negative", common.ErrInvalidRequest)
+	}
 
-	err = filepath.WalkDir(folderPath, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return nil
+	s.dedupeMutex.Lock()
+	defer s.dedupeMutex.Unlock()
+	for id, job := range s.dedupeJobs {
+		if job.job.Status == models.StatusRunning {
+			return nil, fmt.Errorf("%w: duplicate analysis %s is still running", common.ErrInvalidStatus, id)
 		}
-
-		if d.IsDir() {
-			// Skip system directories starting with @
-			if strings.HasPrefix(d.Name(), "@") {
-				return filepath.SkipDir
-			}
-			// Skip #recycle directories if not including recycle bin
-			if !includeRecycle && d.Name() == "#recycle" {
-				return filepath.SkipDir
-			}
-			return nil
+		if time.Since(*job.job.CompletedAt) > dedupeJobTTL {
+			delete(s.dedupeJobs, id)
 		}
+	}
 
-		info, err := d.Info()
-		if err == nil {
-			totalSize += info.Size()
-			fileCount++
-		}
-		return nil
-	})
+	job := &dedupeJob{job: models.DedupeJob{
+		JobID:          uuid.New().String(),
+		Status:         models.StatusRunning,
+		Paths:          folders,
+		IncludeRecycle: includeRecycle,
+		MinSize:        DedupeMinSize(minSize),
+		StartedAt:      time.Now(),
+	}}
+	s.dedupeJobs[job.job.JobID] = job
+	go s.runDedupe(job)
+
+	common.Infof("Started duplicate analysis %s of %d folders", job.job.JobID, len(folders))
+	return s.snapshot(job), nil
+}
+
+func (s *ScannerService) runDedupe(job *dedupeJob) {
+	report, err := AnalyzeDuplicates(context.Background(), GetSource(), job.job.Paths, job.job.IncludeRecycle, job.job.MinSize,
+		func(delta int64) { job.hashed.Add(delta) })
 
+	s.dedupeMutex.Lock()
+	defer s.dedupeMutex.Unlock()
+	now := time.Now()
+	job.job.CompletedAt = &now
 	if err != nil {
-		return nil, err
+		common.Errorf("Duplicate analysis %s failed: %v", job.job.JobID, err)
+		job.job.Status = models.StatusFailed
+		job.job.Error = err.Error()
+		return
 	}
+	common.Infof("Duplicate analysis %s found %d duplicates wasting %d bytes", job.job.JobID, report.DuplicateFiles, report.WastedSize)
+	job.job.Status = models.StatusCompleted
+	job.job.Report = report
+}
 
-	return &models.FolderInfo{
-		Path:         folderPath,
-		Name:         filepath.Base(folderPath),
-		Size:         totalSize,
-		FileCount:    fileCount,
-		ModifiedTime: info.ModTime(),
-	}, nil
+// GetDedupe returns the state of a duplicate analysis
+func (s *ScannerService) GetDedupe(jobID string) (*models.DedupeJob, error) {
+	s.dedupeMutex.Lock()
+	defer s.dedupeMutex.Unlock()
+	job, ok := s.dedupeJobs[jobID]
+	if !ok {
+		return nil, fmt.Errorf("duplicate analysis %s not found", jobID)
+	}
+	return s.snapshot(job), nil
+}
+
+// snapshot copies a job; the caller holds dedupeMutex
+func (s *ScannerService) snapshot(job *dedupeJob) *models.DedupeJob {
+	copied := job.job
+	copied.HashedSize = job.hashed.Load()
+	return &copied
 }
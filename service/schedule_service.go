@@ -0,0 +1,250 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type ScheduleService struct{}
+
+// ScheduleSpec holds the user-editable fields of a schedule
+type ScheduleSpec struct {
+	Name          string           `json:"name" binding:"required"`
+	CronExpr      string           `json:"cron_expr" binding:"required"`
+	Enabled       *bool            `json:"enabled"`
+	Once          bool             `json:"once"`
+	SourceFolders []string         `json:"source_folders" binding:"required"`
+	TargetID      string           `json:"target_id"` // Saved target, replaces the ZimaOS fields
+	ZimaOSHost    string           `json:"zimaos_host"`
+	ZimaOSUser    string           `json:"zimaos_username"`
+	ZimaOSPass    string           `json:"zimaos_password"` // Optional on update: keeps the stored password
+	BasePath      string           `json:"base_path"`       // Defaults to the target's base path
+	Options       MigrationOptions `json:"options"`
+	Priority      int              `json:"priority"`
+}
+
+var scheduleService *ScheduleService
+var scheduleOnce sync.Once
+
+func GetScheduleService() *ScheduleService {
+	scheduleOnce.Do(func() {
+		scheduleService = &ScheduleService{}
+	})
+	return scheduleService
+}
+
+func (s *ScheduleService) CreateSchedule(spec ScheduleSpec) (*models.Schedule, error) {
+	schedule := &models.Schedule{
+		ScheduleID: uuid.New().String(),
+		Enabled:    true,
+	}
+	if err := s.apply(schedule, spec); err != nil {
+		return nil, err
+	}
+
+	if err := models.DB.Create(schedule).Error; err != nil {
+		return nil, fmt.Errorf("failed to create schedule: %w", err)
+	}
+
+	common.Infof("Created schedule %s (%s), next run at %v", schedule.ScheduleID, schedule.CronExpr, schedule.NextRunAt)
+	return schedule, nil
+}
+
+func (s *ScheduleService) UpdateSchedule(scheduleID string, spec ScheduleSpec) (*models.Schedule, error) {
+	schedule, err := s.GetSchedule(scheduleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.apply(schedule, spec); err != nil {
+		return nil, err
+	}
+
+	if err := models.DB.Save(schedule).Error; err != nil {
+		return nil, fmt.Errorf("failed to update schedule: %w", err)
+	}
+	return schedule, nil
+}
+
+// apply validates spec and copies it onto schedule, recomputing the next run
+func (s *ScheduleService) apply(schedule *models.Schedule, spec ScheduleSpec) error {
+	cron, err := ParseCron(spec.CronExpr)
+	if err != nil {
+		return fmt.Errorf("%w: invalid cron expression: %v", common.ErrInvalidRequest, err)
+	}
+	if len(spec.SourceFolders) == 0 {
+		return fmt.Errorf("%w: at least one source folder is required", common.ErrInvalidRequest)
+	}
+	// Scheduled tasks always go to ZimaOS
+	if err := validateOptions(spec.Options, DestinationZimaOS); err != nil {
+		return err
+	}
+
+	optionsJSON, err := json.Marshal(spec.Options)
+	if err != nil {
+		return fmt.Errorf("failed to marshal options: %w", err)
+	}
+	sourceFoldersJSON, err := json.Marshal(spec.SourceFolders)
+	if err != nil {
+		return fmt.Errorf("failed to marshal source folders: %w", err)
+	}
+
+	schedule.Name = spec.Name
+	schedule.CronExpr = spec.CronExpr
+	schedule.Once = spec.Once
+	if spec.Enabled != nil {
+		schedule.Enabled = *spec.Enabled
+	}
+	schedule.SourceFolders = string(sourceFoldersJSON)
+	schedule.BasePath = spec.BasePath
+	if err := s.applyConnection(schedule, spec); err != nil {
+		return err
+	}
+	if schedule.BasePath == "" {
+		return fmt.Errorf("%w: base_path is required", common.ErrInvalidRequest)
+	}
+	schedule.Options = string(optionsJSON)
+	schedule.Priority = spec.Priority
+
+	schedule.NextRunAt = nil
+	if schedule.Enabled {
+		if next := cron.Next(time.Now()); !next.IsZero() {
+			schedule.NextRunAt = &next
+		}
+	}
+	return nil
+}
+
+// applyConnection sets either a target reference or inline credentials
+func (s *ScheduleService) applyConnection(schedule *models.Schedule, spec ScheduleSpec) error {
+	if spec.TargetID != "" {
+		target, err := GetTargetService().GetTarget(spec.TargetID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("%w: target %s not found", common.ErrInvalidRequest, spec.TargetID)
+			}
+			return fmt.Errorf("failed to load target: %w", err)
+		}
+
+		schedule.TargetID = target.TargetID
+		schedule.ZimaOSHost = target.ZimaOSHost
+		schedule.ZimaOSUsername = target.ZimaOSUsername
+		schedule.ZimaOSPassword = ""
+		if schedule.BasePath == "" {
+			schedule.BasePath = target.BasePath
+		}
+		return nil
+	}
+
+	if spec.ZimaOSHost == "" || spec.ZimaOSUser == "" {
+		return fmt.Errorf("%w: either target_id or zimaos_host and zimaos_username are required", common.ErrInvalidRequest)
+	}
+	if spec.ZimaOSPass == "" && schedule.ZimaOSPassword == "" {
+		return fmt.Errorf("%w: zimaos_password is required", common.ErrInvalidRequest)
+	}
+
+	host, err := NormalizeHost(spec.ZimaOSHost, "http")
+	if err != nil {
+		return err
+	}
+
+	schedule.TargetID = ""
+	schedule.ZimaOSHost = host
+	schedule.ZimaOSUsername = spec.ZimaOSUser
+	if spec.ZimaOSPass != "" {
+		password, err := common.EncryptSecret(spec.ZimaOSPass)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt credentials: %w", err)
+		}
+		schedule.ZimaOSPassword = password
+	}
+	return nil
+}
+
+func (s *ScheduleService) GetSchedule(scheduleID string) (*models.Schedule, error) {
+	var schedule models.Schedule
+	if err := models.DB.Where("schedule_id = ?", scheduleID).First(&schedule).Error; err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+func (s *ScheduleService) ListSchedules() ([]*models.Schedule, error) {
+	var schedules []*models.Schedule
+	if err := models.DB.Order("created_at desc").Find(&schedules).Error; err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+func (s *ScheduleService) DeleteSchedule(scheduleID string) error {
+	result := models.DB.Where("schedule_id = ?", scheduleID).Delete(&models.Schedule{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	common.Infof("Deleted schedule %s", scheduleID)
+	return nil
+}
+
+// DueSchedules returns enabled schedules whose next run is at or before now
+func (s *ScheduleService) DueSchedules(now time.Time) ([]*models.Schedule, error) {
+	var schedules []*models.Schedule
+	err := models.DB.Where("enabled = ? AND next_run_at IS NOT NULL AND next_run_at <= ?", true, now).
+		Order("next_run_at asc").Find(&schedules).Error
+	return schedules, err
+}
+
+// Advance moves a schedule to its next run after now, disabling one-off
+// schedules and schedules whose expression never matches again
+func (s *ScheduleService) Advance(schedule *models.Schedule, now time.Time) error {
+	schedule.NextRunAt = nil
+	if schedule.Once {
+		schedule.Enabled = false
+	} else if cron, err := ParseCron(schedule.CronExpr); err != nil {
+		common.Errorf("Disabling schedule %s with invalid cron expression: %v", schedule.ScheduleID, err)
+		schedule.Enabled = false
+	} else if next := cron.Next(now); !next.IsZero() {
+		schedule.NextRunAt = &next
+	} else {
+		schedule.Enabled = false
+	}
+	return models.DB.Save(schedule).Error
+}
+
+// TaskSpec builds the spec of the task created by a schedule run
+func (s *ScheduleService) TaskSpec(schedule *models.Schedule) (TaskSpec, error) {
+	var sourceFolders []string
+	if err := json.Unmarshal([]byte(schedule.SourceFolders), &sourceFolders); err != nil {
+		return TaskSpec{}, fmt.Errorf("failed to parse source folders: %w", err)
+	}
+
+	var options MigrationOptions
+	if schedule.Options != "" {
+		if err := json.Unmarshal([]byte(schedule.Options), &options); err != nil {
+			return TaskSpec{}, fmt.Errorf("failed to parse options: %w", err)
+		}
+	}
+
+	return TaskSpec{
+		SourceFolders: sourceFolders,
+		Host:          schedule.ZimaOSHost,
+		Username:      schedule.ZimaOSUsername,
+		Password:      schedule.ZimaOSPassword,
+		BasePath:      schedule.BasePath,
+		Options:       options,
+		Priority:      schedule.Priority,
+		ScheduleID:    schedule.ScheduleID,
+		TargetID:      schedule.TargetID,
+	}, nil
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/config"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// sftpConn is an SSH connection with an SFTP session. It is opened by
+// connect, reopened on demand after drop, and shared by concurrent callers.
+type sftpConn struct {
+	addr      string
+	sshConfig *ssh.ClientConfig
+
+	mu     sync.Mutex
+	conn   *ssh.Client
+	client *sftp.Client
+}
+
+// newSFTPConn prepares a connection to addr ("host[:port]"). A PEM block in
+// password is used as the private key. The server key must match hostKey
+// ("SHA256:..." as printed by ssh-keygen -l) unless ignoreHostKey is set.
+func newSFTPConn(addr, username, password, hostKey string, ignoreHostKey bool) (*sftpConn, error) {
+	addr, err := normalizeDestinationURL(DestinationSFTP, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeyCallback, err := sftpHostKeyCallback(hostKey, ignoreHostKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var auth []ssh.AuthMethod
+	if strings.Contains(password, "-----BEGIN") {
+		signer, err := ssh.ParsePrivateKey([]byte(password))
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid SSH private key: %v", common.ErrInvalidRequest, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	} else {
+		auth = append(auth, ssh.Password(password), ssh.KeyboardInteractive(
+			func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range answers {
+					answers[i] = password
+				}
+				return answers, nil
+			}))
+	}
+
+	return &sftpConn{
+		addr: addr,
+		sshConfig: &ssh.ClientConfig{
+			User:            username,
+			Auth:            auth,
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         config.AppConfig.ZimaOS.DialTimeout,
+		},
+	}, nil
+}
+
+// sftpHostKeyCallback pins the server key to the configured fingerprint.
+// Skipping the check must be requested explicitly.
+func sftpHostKeyCallback(hostKey string, ignoreHostKey bool) (ssh.HostKeyCallback, error) {
+	if ignoreHostKey {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	expected := strings.TrimPrefix(strings.TrimSpace(hostKey), "SHA256:")
+	if expected == "" {
+		return nil, fmt.Errorf("%w: host_key is required for SFTP (or set ignore_host_key)", common.ErrInvalidRequest)
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if got := ssh.FingerprintSHA256(key); got != "SHA256:"+expected {
+			return fmt.Errorf("host key %s of %s does not match configured SHA256:%s", got, hostname, expected)
+		}
+		return nil
+	}, nil
+}
+
+// connect opens a new connection, replacing any previous one
+func (c *sftpConn) connect() error {
+	conn, err := ssh.Dial("tcp", c.addr, c.sshConfig)
+	if err != nil {
+		return fmt.Errorf("SSH connection to %s failed: %w", c.addr, err)
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SFTP session: %w", err)
+	}
+
+	c.mu.Lock()
+	oldConn := c.conn
+	c.conn, c.client = conn, client
+	c.mu.Unlock()
+
+	if oldConn != nil {
+		oldConn.Close()
+	}
+	common.Infof("Connected to SFTP server at %s", c.addr)
+	return nil
+}
+
+// session returns the current session, reconnecting if it was dropped
+func (c *sftpConn) session() (*sftp.Client, error) {
+	c.mu.Lock()
+	client := c.client
+	c.mu.Unlock()
+
+	if client != nil {
+		return client, nil
+	}
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.client, nil
+}
+
+// drop closes the connection of client so the next call reconnects
+func (c *sftpConn) drop(client *sftp.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != client {
+		return
+	}
+	c.client.Close()
+	c.conn.Close()
+	c.client, c.conn = nil, nil
+}
+
+func (c *sftpConn) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client == nil {
+		return nil
+	}
+	c.client.Close()
+	err := c.conn.Close()
+	c.client, c.conn = nil, nil
+	return err
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/atopos31/stoz/common"
+)
+
+// SFTPDestination uploads over an SSH connection that is opened by Login
+// and reopened after a stalled upload
+type SFTPDestination struct {
+	conn     *sftpConn
+	limiters []*BandwidthLimiter
+	source   Source
+}
+
+func NewSFTPDestination(addr, username, password string, opts DestinationOptions) (*SFTPDestination, error) {
+	conn, err := newSFTPConn(addr, username, password, opts.HostKey, opts.IgnoreHostKey)
+	if err != nil {
+		return nil, err
+	}
+	return &SFTPDestination{conn: conn}, nil
+}
+
+// Login opens a new connection, replacing any previous one
+func (d *SFTPDestination) Login() error {
+	return d.conn.connect()
+}
+
+func (d *SFTPDestination) SetBandwidthLimiters(limiters ...*BandwidthLimiter) {
+	d.limiters = limiters
+}
+
+func (d *SFTPDestination) SetSource(source Source) {
+	d.source = source
+}
+
+func (d *SFTPDestination) CreateFolder(p string) error {
+	client, err := d.conn.session()
+	if err != nil {
+		return err
+	}
+	if err := client.MkdirAll(p); err != nil {
+		return fmt.Errorf("failed to create folder %s: %w", p, err)
+	}
+	return nil
+}
+
+// UploadFile writes to a temporary name and renames it over the target. A
+// stalled upload closes the connection, which unblocks the pending write.
+func (d *SFTPDestination) UploadFile(ctx context.Context, localPath, remotePath string, onProgress func(delta int64)) error {
+	client, err := d.conn.session()
+	if err != nil {
+		return err
+	}
+
+	file, source, stat, err := openUploadSource(ctx, d.source, localPath, d.limiters, onProgress)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	uploadCtx, watch, stopWatch := withStallWatch(ctx, localPath)
+	defer stopWatch()
+	go func() {
+		<-uploadCtx.Done()
+		if stalledError(ctx, uploadCtx) != nil {
+			d.conn.drop(client)
+		}
+	}()
+
+	tmpPath := path.Join(path.Dir(remotePath), "."+path.Base(remotePath)+".stoz-tmp")
+	remote, err := client.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create remote file: %w", err)
+	}
+
+	watch.touch(true)
+	_, err = io.Copy(remote, &watchedBody{reader: io.NopCloser(source), watch: watch})
+	closeErr := remote.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if stalled := stalledError(ctx, uploadCtx); stalled != nil {
+			return fmt.Errorf("upload request failed: %w", stalled)
+		}
+		client.Remove(tmpPath)
+		if ctx.Err() != nil {
+			return fmt.Errorf("upload cancelled by user")
+		}
+		return fmt.Errorf("failed to write remote file: %w", err)
+	}
+
+	if err := client.Chtimes(tmpPath, stat.ModTime(), stat.ModTime()); err != nil {
+		common.Warnf("Failed to set modification time of %s: %v", remotePath, err)
+	}
+	if err := client.PosixRename(tmpPath, remotePath); err != nil {
+		// Servers without the posix-rename extension refuse to replace files
+		client.Remove(remotePath)
+		if err := client.Rename(tmpPath, remotePath); err != nil {
+			client.Remove(tmpPath)
+			return fmt.Errorf("failed to move file into place: %w", err)
+		}
+	}
+
+	common.Infof("File uploaded successfully: %s -> %s", localPath, remotePath)
+	return nil
+}
+
+func (d *SFTPDestination) GetFileInfo(filePath string) (*FileMetadata, error) {
+	client, err := d.conn.session()
+	if err != nil {
+		return nil, err
+	}
+	stat, err := client.Stat(filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", common.ErrFileNotFound, filePath)
+		}
+		return nil, err
+	}
+	return fileMetadata(filePath, stat.Size(), stat.ModTime(), stat.IsDir()), nil
+}
+
+func (d *SFTPDestination) DownloadPartialFile(filePath string, size int64) ([]byte, error) {
+	return d.DownloadRange(filePath, 0, size)
+}
+
+func (d *SFTPDestination) DownloadRange(filePath string, offset, size int64) ([]byte, error) {
+	client, err := d.conn.session()
+	if err != nil {
+		return nil, err
+	}
+	remote, err := client.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer remote.Close()
+	return io.ReadAll(io.NewSectionReader(remote, offset, size))
+}
+
+func (d *SFTPDestination) Close() error {
+	return d.conn.close()
+}
@@ -0,0 +1,271 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/config"
+	"github.com/atopos31/stoz/models"
+	"github.com/pkg/sftp"
+)
+
+// SFTPSource reads from a NAS over SFTP, e.g. a Synology with SFTP enabled
+// when stoz runs on the ZimaOS side. Each of SOURCE_ROOTS is listed as a
+// volume; paths outside them are rejected like paths outside HOST_PATH.
+type SFTPSource struct {
+	conn  *sftpConn
+	host  string
+	roots []string
+}
+
+func NewSFTPSource(cfg config.SourceConfig) (*SFTPSource, error) {
+	password := cfg.Password
+	if cfg.KeyFile != "" {
+		key, err := os.ReadFile(cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SOURCE_KEY_FILE: %w", err)
+		}
+		password = string(key)
+	}
+
+	conn, err := newSFTPConn(cfg.URL, cfg.Username, password, cfg.HostKey, cfg.IgnoreHostKey)
+	if err != nil {
+		return nil, err
+	}
+
+	roots := make([]string, 0, len(cfg.Roots))
+	for _, root := range cfg.Roots {
+		roots = append(roots, path.Clean("/"+root))
+	}
+	if len(roots) == 0 {
+		roots = []string{"/"}
+	}
+
+	host := conn.addr
+	if i := strings.LastIndex(host, ":"); i > 0 {
+		host = host[:i]
+	}
+	return &SFTPSource{conn: conn, host: host, roots: roots}, nil
+}
+
+// release drops the session after a lost connection so the next call
+// reconnects, and returns err
+func (s *SFTPSource) release(client *sftp.Client, err error) error {
+	if errors.Is(err, sftp.ErrSSHFxConnectionLost) {
+		s.conn.drop(client)
+	}
+	return err
+}
+
+// ListVolumes returns one volume per root with its sub-directories as folders
+func (s *SFTPSource) ListVolumes() ([]models.VolumeInfo, error) {
+	client, err := s.conn.session()
+	if err != nil {
+		return nil, err
+	}
+
+	var volumes []models.VolumeInfo
+	for _, root := range s.roots {
+		entries, err := client.ReadDir(root)
+		if err != nil {
+			s.release(client, err)
+			common.Errorf("Failed to read SFTP root %s: %v", root, err)
+			continue
+		}
+
+		folders := make([]models.FolderInfo, 0)
+		for _, entry := range entries {
+			if !entry.IsDir() || strings.HasPrefix(entry.Name(), "@") {
+				continue
+			}
+			folders = append(folders, models.FolderInfo{
+				Path:         path.Join(root, entry.Name()),
+				Name:         entry.Name(),
+				ModifiedTime: entry.ModTime(),
+			})
+		}
+
+		name := path.Base(root)
+		if root == "/" {
+			name = s.host
+		}
+		volumes = append(volumes, models.VolumeInfo{Name: name, Path: root, Folders: folders})
+	}
+	return volumes, nil
+}
+
+// within reports whether p is one of the roots or inside one
+func (s *SFTPSource) within(p string) bool {
+	for _, root := range s.roots {
+		if pathWithin(p, root) {
+			return true
+		}
+	}
+	return false
+}
+
+// realPath resolves every symlink in p on the server. Not all servers
+// follow symlinks in their realpath reply, so links are read one by one.
+func realPath(client *sftp.Client, p string) (string, error) {
+	resolved := "/"
+	pending := strings.Split(strings.Trim(path.Clean(p), "/"), "/")
+	for hops := 0; len(pending) > 0; {
+		name := pending[0]
+		pending = pending[1:]
+		if name == "" || name == "." {
+			continue
+		}
+		if name == ".." {
+			resolved = path.Dir(resolved)
+			continue
+		}
+
+		next := path.Join(resolved, name)
+		info, err := client.Lstat(next)
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		if hops++; hops > 40 {
+			return "", fmt.Errorf("too many levels of symbolic links: %s", p)
+		}
+		target, err := client.ReadLink(next)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		pending = append(strings.Split(target, "/"), pending...)
+	}
+	return resolved, nil
+}
+
+// Resolve resolves ".." and symlinks, then checks the roots
+func (s *SFTPSource) Resolve(p string) (string, error) {
+	if p == "" || !path.IsAbs(p) || strings.ContainsRune(p, 0) {
+		return "", &PathViolation{Path: p, Reason: PathInvalid, Detail: "path must be absolute"}
+	}
+
+	client, err := s.conn.session()
+	if err != nil {
+		return "", err
+	}
+	resolved, err := realPath(client, p)
+	if err != nil {
+		s.release(client, err)
+		if errors.Is(err, os.ErrNotExist) {
+			return "", &PathViolation{Path: p, Reason: PathNotFound, Detail: "path does not exist"}
+		}
+		return "", &PathViolation{Path: p, Reason: PathInvalid, Detail: err.Error()}
+	}
+
+	if !s.within(resolved) {
+		return "", &PathViolation{Path: p, Reason: PathOutsideVolumes, Detail: "only folders inside SOURCE_ROOTS can be used"}
+	}
+	return resolved, nil
+}
+
+// Walk follows symlinks to regular files inside the roots and skips others.
+// A directory that cannot be listed ends the walk with an error: skipping it
+// would leave its files out of the migration without anyone noticing.
+func (s *SFTPSource) Walk(root string, fn WalkFunc) error {
+	client, err := s.conn.session()
+	if err != nil {
+		return err
+	}
+
+	walker := client.Walk(root)
+	for walker.Step() {
+		p := walker.Path()
+		if err := walker.Err(); err != nil {
+			return s.release(client, fmt.Errorf("failed to access path %s: %w", p, err))
+		}
+
+		info := walker.Stat()
+		if info.Mode()&fs.ModeSymlink != 0 {
+			target, err := realPath(client, p)
+			if err != nil {
+				common.Warnf("Skipping broken symlink %s: %v", p, err)
+				continue
+			}
+			if !s.within(target) {
+				common.SecurityEvent("symlink_escape_skipped", map[string]interface{}{
+					"path":   p,
+					"target": target,
+				})
+				continue
+			}
+			if info, err = client.Stat(target); err != nil || !info.Mode().IsRegular() {
+				common.Infof("Skipping symlink to non-regular file: %s", p)
+				continue
+			}
+		} else if !info.IsDir() && !info.Mode().IsRegular() {
+			common.Infof("Skipping non-regular file: %s", p)
+			continue
+		}
+
+		if err := fn(p, info); err != nil {
+			if err == fs.SkipDir && info.IsDir() {
+				walker.SkipDir()
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Open closes the file when ctx is done, so a hung read returns
+func (s *SFTPSource) Open(ctx context.Context, p string) (io.ReadCloser, error) {
+	client, err := s.conn.session()
+	if err != nil {
+		return nil, err
+	}
+	file, err := client.Open(p)
+	if err != nil {
+		return nil, s.release(client, err)
+	}
+	stop := context.AfterFunc(ctx, func() { file.Close() })
+	return &sftpFile{File: file, stop: stop}, nil
+}
+
+// sftpFile is an open source file that is closed early on cancellation
+type sftpFile struct {
+	*sftp.File
+	stop func() bool
+}
+
+func (f *sftpFile) Close() error {
+	if !f.stop() {
+		return nil // Already closed on cancellation
+	}
+	return f.File.Close()
+}
+
+func (s *SFTPSource) Stat(p string) (fs.FileInfo, error) {
+	client, err := s.conn.session()
+	if err != nil {
+		return nil, err
+	}
+	info, err := client.Stat(p)
+	if err != nil {
+		return nil, s.release(client, err)
+	}
+	return info, nil
+}
+
+func (s *SFTPSource) Close() error {
+	return s.conn.close()
+}
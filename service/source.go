@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/config"
+	"github.com/atopos31/stoz/models"
+	"gorm.io/gorm"
+)
+
+// Source types
+const (
+	SourceLocal  = "local"
+	SourceSFTP   = "sftp"
+	SourceZimaOS = "zimaos" // Per task, from a saved target
+)
+
+// WalkFunc is called for every directory and regular file below a walk
+// root. Returning fs.SkipDir for a directory skips its contents.
+type WalkFunc func(path string, info fs.FileInfo) error
+
+// Source is where a migration reads files from. Paths are absolute paths in
+// the source's own namespace, as returned by ListVolumes.
+type Source interface {
+	ListVolumes() ([]models.VolumeInfo, error) // Volumes (or shares) with their top-level folders
+	Resolve(path string) (string, error)       // Canonical form of a user-supplied folder, or a *PathViolation
+	Walk(root string, fn WalkFunc) error       // Local sources skip unreadable entries, network sources fail on them
+	Open(ctx context.Context, path string) (io.ReadCloser, error)
+	Stat(path string) (fs.FileInfo, error)
+	Close() error
+}
+
+var (
+	sourceMu     sync.RWMutex
+	activeSource Source
+)
+
+// InitSource sets up the source configured with SOURCE_TYPE. Network
+// sources connect on first use, so an unreachable server is not fatal here.
+func InitSource(cfg config.SourceConfig) error {
+	var src Source
+	switch cfg.Type {
+	case "", SourceLocal:
+		src = &LocalSource{}
+	case SourceSFTP:
+		sftpSource, err := NewSFTPSource(cfg)
+		if err != nil {
+			return err
+		}
+		src = sftpSource
+	default:
+		return fmt.Errorf("%w: unknown SOURCE_TYPE %q", common.ErrInvalidRequest, cfg.Type)
+	}
+
+	sourceMu.Lock()
+	defer sourceMu.Unlock()
+	if activeSource != nil {
+		activeSource.Close()
+	}
+	activeSource = src
+	return nil
+}
+
+// GetSource returns the configured source, the local HOST_PATH mount by default
+func GetSource() Source {
+	sourceMu.RLock()
+	defer sourceMu.RUnlock()
+	if activeSource == nil {
+		return &LocalSource{}
+	}
+	return activeSource
+}
+
+// IsLocalSource reports whether sources are read from the HOST_PATH mount
+func IsLocalSource() bool {
+	_, ok := GetSource().(*LocalSource)
+	return ok
+}
+
+// OpenTaskSource returns the source a task reads from: the ZimaOS instance of
+// the saved target sourceTargetID when set, otherwise the configured source.
+// release closes a per-task source and leaves the shared one open.
+func OpenTaskSource(sourceTargetID string) (src Source, release func(), err error) {
+	if sourceTargetID == "" {
+		return GetSource(), func() {}, nil
+	}
+
+	target, err := GetTargetService().GetTarget(sourceTargetID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, fmt.Errorf("%w: source target %s not found", common.ErrInvalidRequest, sourceTargetID)
+		}
+		return nil, nil, fmt.Errorf("failed to load source target: %w", err)
+	}
+	client, err := GetTargetService().NewClient(target)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := client.Login(); err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to source target %s: %w", target.Name, err)
+	}
+
+	zimaSource := NewZimaOSSource(client)
+	return zimaSource, func() { zimaSource.Close() }, nil
+}
+
+// skipSourceDir reports whether a directory is left out of scans and
+// migrations: Synology system folders (@eaDir, ...) and, unless requested,
+// the #recycle bin
+func skipSourceDir(name string, includeRecycle bool) bool {
+	return strings.HasPrefix(name, "@") || (!includeRecycle && name == "#recycle")
+}
+
+// openSourceFile opens path for reading from src, or from the local
+// filesystem when src is nil
+func openSourceFile(ctx context.Context, src Source, path string) (io.ReadCloser, fs.FileInfo, error) {
+	if src == nil {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		stat, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, nil, fmt.Errorf("failed to stat file: %w", err)
+		}
+		return file, stat, nil
+	}
+
+	stat, err := src.Stat(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	file, err := src.Open(ctx, path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	return file, stat, nil
+}
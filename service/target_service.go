@@ -0,0 +1,255 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type TargetService struct{}
+
+// TargetSpec holds the user-editable fields of a target
+type TargetSpec struct {
+	Name       string `json:"name" binding:"required"`
+	ZimaOSHost string `json:"zimaos_host" binding:"required"`
+	ZimaOSUser string `json:"zimaos_username" binding:"required"`
+	ZimaOSPass string `json:"zimaos_password"` // Optional on update: keeps the stored password
+	BasePath   string `json:"base_path"`
+	TLSOptions
+}
+
+var targetService *TargetService
+var targetOnce sync.Once
+
+func GetTargetService() *TargetService {
+	targetOnce.Do(func() {
+		targetService = &TargetService{}
+	})
+	return targetService
+}
+
+func (s *TargetService) CreateTarget(spec TargetSpec) (*models.Target, error) {
+	if spec.ZimaOSPass == "" {
+		return nil, fmt.Errorf("%w: zimaos_password is required", common.ErrInvalidRequest)
+	}
+
+	target := &models.Target{
+		TargetID: uuid.New().String(),
+	}
+	if err := s.apply(target, spec); err != nil {
+		return nil, err
+	}
+
+	if err := models.DB.Create(target).Error; err != nil {
+		return nil, fmt.Errorf("failed to create target: %w", err)
+	}
+
+	common.Infof("Created target %s (%s)", target.TargetID, target.ZimaOSHost)
+	return target, nil
+}
+
+func (s *TargetService) UpdateTarget(targetID string, spec TargetSpec) (*models.Target, error) {
+	target, err := s.GetTarget(targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.apply(target, spec); err != nil {
+		return nil, err
+	}
+
+	if err := models.DB.Save(target).Error; err != nil {
+		return nil, fmt.Errorf("failed to update target: %w", err)
+	}
+	return target, nil
+}
+
+func (s *TargetService) apply(target *models.Target, spec TargetSpec) error {
+	// Hosts given without a scheme use HTTPS once TLS settings are configured
+	scheme := "http"
+	if spec.TLSOptions.IsSet() {
+		scheme = "https"
+	}
+	host, err := NormalizeHost(spec.ZimaOSHost, scheme)
+	if err != nil {
+		return err
+	}
+	spec.ZimaOSHost = host
+
+	if _, err := spec.TLSOptions.Config(); err != nil {
+		return err
+	}
+	fingerprint, err := NormalizeFingerprint(spec.Fingerprint)
+	if err != nil {
+		return err
+	}
+
+	// A different server, account or trust setting invalidates the cached test results
+	tlsChanged := target.TLSCACert != spec.CACert || target.TLSFingerprint != fingerprint || target.TLSInsecure != spec.InsecureSkipVerify
+	if target.ZimaOSHost != spec.ZimaOSHost || target.ZimaOSUsername != spec.ZimaOSUser || spec.ZimaOSPass != "" || tlsChanged {
+		target.LastTestedAt = nil
+		target.LastTestError = ""
+	}
+	if target.ZimaOSHost != spec.ZimaOSHost {
+		target.Storages = ""
+		target.StoragesUpdatedAt = nil
+	}
+
+	target.Name = spec.Name
+	target.ZimaOSHost = spec.ZimaOSHost
+	target.ZimaOSUsername = spec.ZimaOSUser
+	if spec.ZimaOSPass != "" {
+		password, err := common.EncryptSecret(spec.ZimaOSPass)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt credentials: %w", err)
+		}
+		target.ZimaOSPassword = password
+	}
+	target.BasePath = spec.BasePath
+	target.TLSCACert = spec.CACert
+	target.TLSFingerprint = fingerprint
+	target.TLSInsecure = spec.InsecureSkipVerify
+	return nil
+}
+
+func (s *TargetService) GetTarget(targetID string) (*models.Target, error) {
+	var target models.Target
+	if err := models.DB.Where("target_id = ?", targetID).First(&target).Error; err != nil {
+		return nil, err
+	}
+	return &target, nil
+}
+
+func (s *TargetService) ListTargets() ([]*models.Target, error) {
+	var targets []*models.Target
+	if err := models.DB.Order("name asc").Find(&targets).Error; err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+// DeleteTarget removes a target that no unfinished task or schedule uses
+func (s *TargetService) DeleteTarget(targetID string) error {
+	var activeTasks int64
+	if err := models.DB.Model(&models.MigrationTask{}).
+		Where("(target_id = ? OR source_target_id = ?) AND status NOT IN ?", targetID, targetID, []string{models.StatusCompleted, models.StatusFailed, models.StatusCancelled}).
+		Count(&activeTasks).Error; err != nil {
+		return err
+	}
+	if activeTasks > 0 {
+		return fmt.Errorf("%w: target is used by %d unfinished tasks", common.ErrInvalidStatus, activeTasks)
+	}
+
+	var schedules int64
+	if err := models.DB.Model(&models.Schedule{}).Where("target_id = ?", targetID).Count(&schedules).Error; err != nil {
+		return err
+	}
+	if schedules > 0 {
+		return fmt.Errorf("%w: target is used by %d schedules", common.ErrInvalidStatus, schedules)
+	}
+
+	result := models.DB.Where("target_id = ?", targetID).Delete(&models.Target{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	common.Infof("Deleted target %s", targetID)
+	return nil
+}
+
+// NewClient returns a client authenticated with the target's stored credentials
+func (s *TargetService) NewClient(target *models.Target) (*ZimaOSClient, error) {
+	password, err := common.DecryptSecret(target.ZimaOSPassword)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt target credentials: %w", err)
+	}
+	client := NewZimaOSClient(target.ZimaOSHost, target.ZimaOSUsername, password)
+	if err := client.SetTLSOptions(TLSOptions{
+		CACert:             target.TLSCACert,
+		Fingerprint:        target.TLSFingerprint,
+		InsecureSkipVerify: target.TLSInsecure,
+	}); err != nil {
+		return nil, fmt.Errorf("invalid TLS settings for target %s: %w", target.TargetID, err)
+	}
+	return client, nil
+}
+
+// TestTarget logs in with the stored credentials and refreshes the cached
+// storage list. The outcome is recorded on the target either way.
+func (s *TargetService) TestTarget(targetID string) (*models.Target, error) {
+	target, err := s.GetTarget(targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, testErr := s.fetchStorages(target)
+
+	now := time.Now()
+	target.LastTestedAt = &now
+	target.LastTestError = ""
+	if testErr != nil {
+		target.LastTestError = testErr.Error()
+	}
+	if err := models.DB.Save(target).Error; err != nil {
+		return nil, fmt.Errorf("failed to update target: %w", err)
+	}
+
+	if testErr != nil {
+		return target, fmt.Errorf("%w: %v", common.ErrConnectionFailed, testErr)
+	}
+	return target, nil
+}
+
+// RefreshStorages fetches and caches the storage list of a target
+func (s *TargetService) RefreshStorages(targetID string) ([]StorageDevice, error) {
+	target, err := s.GetTarget(targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	storages, err := s.fetchStorages(target)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", common.ErrConnectionFailed, err)
+	}
+
+	if err := models.DB.Model(target).Updates(map[string]interface{}{
+		"storages":            target.Storages,
+		"storages_updated_at": target.StoragesUpdatedAt,
+	}).Error; err != nil {
+		return nil, fmt.Errorf("failed to update target: %w", err)
+	}
+	return storages, nil
+}
+
+// fetchStorages logs in and stores the storage list on target (not saved)
+func (s *TargetService) fetchStorages(target *models.Target) ([]StorageDevice, error) {
+	client, err := s.NewClient(target)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Login(); err != nil {
+		return nil, err
+	}
+
+	storages, err := client.GetStorageList()
+	if err != nil {
+		return nil, err
+	}
+
+	storagesJSON, err := json.Marshal(storages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal storages: %w", err)
+	}
+	now := time.Now()
+	target.Storages = string(storagesJSON)
+	target.StoragesUpdatedAt = &now
+	return storages, nil
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/models"
+	"gorm.io/gorm"
+)
+
+// The task queue lives in the migration_tasks table: pending tasks are
+// dequeued by priority (highest first), then by queue_order (oldest first).
+const queueOrderClause = "priority desc, queue_order asc, id asc"
+
+// claimMu serialises claims so two workers never pick the same task or
+// overshoot a per-target limit between the count and the update
+var claimMu sync.Mutex
+
+// targetKey identifies the destination a task writes to: a ZimaOS host, a
+// WebDAV URL, an SFTP host, an S3 endpoint or the local destination
+func targetKey(host string) string {
+	return strings.TrimRight(strings.ToLower(strings.TrimSpace(host)), "/")
+}
+
+// ClaimNextTask atomically moves the first eligible pending task to running.
+// Tasks whose target already has maxPerTarget running tasks are passed over.
+// It returns nil when nothing can be started right now.
+func (s *MigrationService) ClaimNextTask(maxPerTarget int) (*models.MigrationTask, error) {
+	claimMu.Lock()
+	defer claimMu.Unlock()
+
+	running := make(map[string]int)
+	if maxPerTarget > 0 {
+		var active []models.MigrationTask
+		if err := models.DB.Select("dest_url").
+			Where("status IN ?", []string{models.StatusRunning, models.StatusVerifying}).
+			Find(&active).Error; err != nil {
+			return nil, fmt.Errorf("failed to count running tasks: %w", err)
+		}
+		for _, task := range active {
+			running[targetKey(task.DestURL)]++
+		}
+	}
+
+	var pending []*models.MigrationTask
+	if err := models.DB.Where("status = ?", models.StatusPending).Order(queueOrderClause).Find(&pending).Error; err != nil {
+		return nil, fmt.Errorf("failed to load queue: %w", err)
+	}
+
+	for _, task := range pending {
+		if maxPerTarget > 0 && running[targetKey(task.DestURL)] >= maxPerTarget {
+			continue
+		}
+
+		// Resumed tasks keep their original start time
+		result := models.DB.Model(&models.MigrationTask{}).
+			Where("task_id = ? AND status = ?", task.TaskID, models.StatusPending).
+			Updates(map[string]interface{}{
+				"status":     models.StatusRunning,
+				"started_at": gorm.Expr("COALESCE(started_at, ?)", time.Now()),
+			})
+		if result.Error != nil {
+			return nil, fmt.Errorf("failed to claim task %s: %w", task.TaskID, result.Error)
+		}
+		if result.RowsAffected == 0 {
+			// Cancelled between the read and the update
+			continue
+		}
+
+		return s.GetTask(task.TaskID)
+	}
+
+	return nil, nil
+}
+
+// CheckpointTask re-queues a running task so it resumes from its checkpoint.
+// A task cancelled in the meantime stays cancelled.
+func (s *MigrationService) CheckpointTask(task *models.MigrationTask) error {
+	result := models.DB.Model(&models.MigrationTask{}).
+		Where("task_id = ? AND status IN ?", task.TaskID, []string{models.StatusRunning, models.StatusVerifying}).
+		Updates(map[string]interface{}{
+			"status":                 models.StatusPending,
+			"processed_files":        task.ProcessedFiles,
+			"failed_files":           task.FailedFiles,
+			"transferred_size":       task.TransferredSize,
+			"progress":               task.Progress,
+			"checkpoint_index":       task.CheckpointIndex,
+			"checkpoint_path":        task.CheckpointPath,
+			"checkpoint_transferred": task.CheckpointTransferred,
+			"wire_size":              task.WireSize,
+			"deduped_files":          task.DedupedFiles,
+			"deduped_size":           task.DedupedSize,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		task.Status = models.StatusPending
+	}
+	return nil
+}
+
+// RecoverInterruptedTasks re-queues tasks left running by a crash or a
+// shutdown that timed out. They resume from their last persisted checkpoint.
+func (s *MigrationService) RecoverInterruptedTasks() (int64, error) {
+	result := models.DB.Model(&models.MigrationTask{}).
+		Where("status IN ?", []string{models.StatusRunning, models.StatusVerifying}).
+		Update("status", models.StatusPending)
+	return result.RowsAffected, result.Error
+}
+
+// MoveTask re-orders a pending task. position is 1-based within the whole
+// pending queue; priority, if given, is applied first. When moving across
+// priority boundaries the task's priority is adjusted to fit its new neighbours.
+func (s *MigrationService) MoveTask(taskID string, position, priority *int) (*models.MigrationTask, error) {
+	claimMu.Lock()
+	defer claimMu.Unlock()
+
+	var moved *models.MigrationTask
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		var task models.MigrationTask
+		if err := tx.Where("task_id = ?", taskID).First(&task).Error; err != nil {
+			return err
+		}
+		if task.Status != models.StatusPending {
+			return fmt.Errorf("%w: only pending tasks can be moved (task is %s)", common.ErrInvalidStatus, task.Status)
+		}
+
+		if priority != nil {
+			task.Priority = *priority
+		}
+
+		var queue []*models.MigrationTask
+		if err := tx.Where("status = ? AND task_id <> ?", models.StatusPending, taskID).Order(queueOrderClause).Find(&queue).Error; err != nil {
+			return err
+		}
+
+		index := len(queue)
+		if position != nil {
+			index = *position - 1
+			if index < 0 {
+				index = 0
+			}
+			if index > len(queue) {
+				index = len(queue)
+			}
+
+			// Keep the queue sorted by priority: clamp between the neighbours
+			if index < len(queue) && task.Priority < queue[index].Priority {
+				task.Priority = queue[index].Priority
+			}
+			if index > 0 && task.Priority > queue[index-1].Priority {
+				task.Priority = queue[index-1].Priority
+			}
+		} else {
+			// Priority change only: re-insert at the end of its priority band
+			for i, other := range queue {
+				if other.Priority < task.Priority {
+					index = i
+					break
+				}
+			}
+		}
+
+		ordered := make([]*models.MigrationTask, 0, len(queue)+1)
+		ordered = append(ordered, queue[:index]...)
+		ordered = append(ordered, &task)
+		ordered = append(ordered, queue[index:]...)
+
+		// Renumber the whole queue so the new order is stable
+		for i, t := range ordered {
+			updates := map[string]interface{}{"queue_order": int64(i + 1)}
+			if t.TaskID == taskID {
+				updates["priority"] = task.Priority
+			}
+			if err := tx.Model(&models.MigrationTask{}).Where("task_id = ?", t.TaskID).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+
+		task.QueueOrder = int64(index + 1)
+		moved = &task
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	common.Infof("Moved task %s to queue position %d (priority %d)", taskID, moved.QueueOrder, moved.Priority)
+	return moved, nil
+}
+
+// queuePosition returns the 1-based position of a pending task, or 0
+func (s *MigrationService) queuePosition(task *models.MigrationTask) int {
+	if task.Status != models.StatusPending {
+		return 0
+	}
+
+	var ahead int64
+	err := models.DB.Model(&models.MigrationTask{}).
+		Where("status = ?", models.StatusPending).
+		Where("priority > ? OR (priority = ? AND (queue_order < ? OR (queue_order = ? AND id < ?)))",
+			task.Priority, task.Priority, task.QueueOrder, task.QueueOrder, task.ID).
+		Count(&ahead).Error
+	if err != nil {
+		common.Warnf("Failed to compute queue position for task %s: %v", task.TaskID, err)
+		return 0
+	}
+	return int(ahead) + 1
+}
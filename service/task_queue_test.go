@@ -0,0 +1,234 @@
+package service_test
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/models"
+	"github.com/atopos31/stoz/service"
+	"gorm.io/gorm"
+)
+
+// queuedTask is a task row to create for a queue test
+type queuedTask struct {
+	id       string
+	status   string
+	priority int
+	host     string
+}
+
+// createQueue replaces all tasks with tasks, queued in the given order
+func createQueue(t *testing.T, tasks ...queuedTask) {
+	t.Helper()
+	if err := models.DB.Where("1 = 1").Delete(&models.MigrationTask{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	for i, task := range tasks {
+		if task.status == "" {
+			task.status = models.StatusPending
+		}
+		if task.host == "" {
+			task.host = "http://zimaos.local"
+		}
+		row := &models.MigrationTask{
+			TaskID:        task.id,
+			Status:        task.status,
+			SourceFolders: "[]",
+			DestURL:       task.host,
+			Priority:      task.priority,
+			QueueOrder:    int64(i + 1),
+		}
+		if err := models.DB.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// pendingOrder returns the pending tasks as "id:priority", in dequeue order
+func pendingOrder(t *testing.T) []string {
+	t.Helper()
+	var tasks []models.MigrationTask
+	if err := models.DB.Where("status = ?", models.StatusPending).
+		Order("priority desc, queue_order asc, id asc").Find(&tasks).Error; err != nil {
+		t.Fatal(err)
+	}
+	order := make([]string, len(tasks))
+	for i, task := range tasks {
+		if task.QueueOrder != int64(i+1) {
+			t.Errorf("%s has queue_order %d at position %d", task.TaskID, task.QueueOrder, i+1)
+		}
+		order[i] = fmt.Sprintf("%s:%d", task.TaskID, task.Priority)
+	}
+	return order
+}
+
+func TestMoveTask(t *testing.T) {
+	intp := func(v int) *int { return &v }
+	queue := []queuedTask{
+		{id: "a", priority: 5},
+		{id: "b", priority: 5},
+		{id: "c"},
+		{id: "d"},
+		{id: "e"},
+	}
+
+	tests := []struct {
+		name     string
+		task     string
+		position *int
+		priority *int
+		want     []string
+	}{
+		{"within a band", "d", intp(3), nil, []string{"a:5", "b:5", "d:0", "c:0", "e:0"}},
+		{"up into a higher band takes its priority", "e", intp(1), nil, []string{"e:5", "a:5", "b:5", "c:0", "d:0"}},
+		{"down into a lower band takes its priority", "a", intp(4), nil, []string{"b:5", "c:0", "d:0", "a:0", "e:0"}},
+		{"between bands keeps the priority", "c", intp(3), intp(3), []string{"a:5", "b:5", "c:3", "d:0", "e:0"}},
+		{"position before the first", "d", intp(-2), nil, []string{"d:5", "a:5", "b:5", "c:0", "e:0"}},
+		{"position after the last", "a", intp(99), nil, []string{"b:5", "c:0", "d:0", "e:0", "a:0"}},
+		{"no position goes to the end of its band", "a", nil, nil, []string{"b:5", "a:5", "c:0", "d:0", "e:0"}},
+		{"priority only goes to the end of its band", "e", nil, intp(5), []string{"a:5", "b:5", "e:5", "c:0", "d:0"}},
+		{"priority only into a new band", "c", nil, intp(9), []string{"c:9", "a:5", "b:5", "d:0", "e:0"}},
+		{"priority only to the lowest band", "a", nil, intp(0), []string{"b:5", "c:0", "d:0", "e:0", "a:0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			createQueue(t, queue...)
+			moved, err := service.GetMigrationService().MoveTask(tt.task, tt.position, tt.priority)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := pendingOrder(t)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("queue = %v, want %v", got, tt.want)
+			}
+			for i, entry := range tt.want {
+				if entry == fmt.Sprintf("%s:%d", moved.TaskID, moved.Priority) && moved.QueueOrder != int64(i+1) {
+					t.Errorf("moved task reports queue_order %d, want %d", moved.QueueOrder, i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestMoveTaskRejectsTasksOutsideTheQueue(t *testing.T) {
+	createQueue(t, queuedTask{id: "a"}, queuedTask{id: "run", status: models.StatusRunning})
+	position := 1
+
+	if _, err := service.GetMigrationService().MoveTask("run", &position, nil); !errors.Is(err, common.ErrInvalidStatus) {
+		t.Errorf("running task: got %v, want ErrInvalidStatus", err)
+	}
+	if _, err := service.GetMigrationService().MoveTask("missing", &position, nil); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("missing task: got %v, want ErrRecordNotFound", err)
+	}
+}
+
+func TestClaimNextTask(t *testing.T) {
+	svc := service.GetMigrationService()
+	claim := func(t *testing.T, maxPerTarget int) string {
+		t.Helper()
+		task, err := svc.ClaimNextTask(maxPerTarget)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task == nil {
+			return ""
+		}
+		if task.Status != models.StatusRunning || task.StartedAt == nil {
+			t.Errorf("claimed task %s is %s, started %v", task.TaskID, task.Status, task.StartedAt)
+		}
+		return task.TaskID
+	}
+
+	t.Run("priority then queue order", func(t *testing.T) {
+		createQueue(t,
+			queuedTask{id: "low"},
+			queuedTask{id: "done", status: models.StatusCompleted, priority: 9},
+			queuedTask{id: "high", priority: 5},
+			queuedTask{id: "high2", priority: 5},
+		)
+		for _, want := range []string{"high", "high2", "low", ""} {
+			if got := claim(t, 0); got != want {
+				t.Errorf("claimed %q, want %q", got, want)
+			}
+		}
+	})
+
+	t.Run("per target limit", func(t *testing.T) {
+		createQueue(t,
+			queuedTask{id: "running", status: models.StatusRunning, host: "http://A.local/"},
+			queuedTask{id: "verifying", status: models.StatusVerifying, host: "http://c.local"},
+			queuedTask{id: "a1", host: "http://a.local"},
+			queuedTask{id: "c1", host: "http://c.local"},
+			queuedTask{id: "b1", host: " http://b.local"},
+			queuedTask{id: "b2", host: "http://b.local/"},
+		)
+		// Hosts differing in case, spaces or a trailing slash are one target
+		for _, want := range []string{"b1", ""} {
+			if got := claim(t, 1); got != want {
+				t.Errorf("limit 1: claimed %q, want %q", got, want)
+			}
+		}
+		for _, want := range []string{"a1", "c1", "b2", ""} {
+			if got := claim(t, 2); got != want {
+				t.Errorf("limit 2: claimed %q, want %q", got, want)
+			}
+		}
+	})
+
+	t.Run("no limit", func(t *testing.T) {
+		createQueue(t,
+			queuedTask{id: "running", status: models.StatusRunning},
+			queuedTask{id: "a1"},
+			queuedTask{id: "a2"},
+		)
+		for _, want := range []string{"a1", "a2", ""} {
+			if got := claim(t, 0); got != want {
+				t.Errorf("claimed %q, want %q", got, want)
+			}
+		}
+	})
+
+	t.Run("cancelled tasks are skipped", func(t *testing.T) {
+		createQueue(t,
+			queuedTask{id: "cancelled", status: models.StatusCancelled, priority: 9},
+			queuedTask{id: "first", priority: 5},
+			queuedTask{id: "second"},
+		)
+
+		// Cancel "first" after the queue was read but before it is claimed
+		const hook = "test:cancel_first"
+		cancelled := false
+		err := models.DB.Callback().Update().Before("gorm:update").Register(hook, func(db *gorm.DB) {
+			if cancelled {
+				return
+			}
+			cancelled = true
+			db.Session(&gorm.Session{NewDB: true}).Model(&models.MigrationTask{}).
+				Where("task_id = ?", "first").Update("status", models.StatusCancelled)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := claim(t, 0)
+		if err := models.DB.Callback().Update().Remove(hook); err != nil {
+			t.Fatal(err)
+		}
+
+		if !cancelled {
+			t.Fatal("the claim did not update any task")
+		}
+		if got != "second" {
+			t.Errorf("claimed %q, want %q", got, "second")
+		}
+		first, err := svc.GetTask("first")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first.Status != models.StatusCancelled {
+			t.Errorf("cancelled task is %s", first.Status)
+		}
+	})
+}
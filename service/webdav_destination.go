@@ -0,0 +1,300 @@
+package service
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/config"
+)
+
+// WebDAVDestination uploads with plain PUT requests and HTTP Basic auth
+type WebDAVDestination struct {
+	base           string // Scheme, host and path prefix, without trailing slash
+	username       string
+	password       string
+	client         *http.Client
+	uploadClient   *http.Client
+	downloadClient *http.Client
+	limiters       []*BandwidthLimiter
+	source         Source
+
+	foldersMu sync.Mutex
+	folders   map[string]bool // Collections known to exist
+
+	// Set once the server confirmed it applied X-OC-Mtime (Nextcloud, ownCloud).
+	// Other servers stamp uploads with the upload time.
+	mtimeAccepted atomic.Bool
+}
+
+// davMultistatus is the subset of a PROPFIND response we read
+type davMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Status string `xml:"status"`
+			Prop   struct {
+				ContentLength int64  `xml:"getcontentlength"`
+				LastModified  string `xml:"getlastmodified"`
+				ResourceType  struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+const davPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:getcontentlength/><d:getlastmodified/><d:resourcetype/></d:prop></d:propfind>`
+
+func NewWebDAVDestination(rawURL, username, password string, opts DestinationOptions) (*WebDAVDestination, error) {
+	base, err := normalizeDestinationURL(DestinationWebDAV, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := sharedTransport(base, opts.TLSOptions)
+	if err != nil {
+		return nil, err
+	}
+	if opts.InsecureSkipVerify {
+		common.Warnf("TLS certificate verification is disabled for %s", base)
+	}
+
+	cfg := config.AppConfig.ZimaOS
+	return &WebDAVDestination{
+		base:           base,
+		username:       username,
+		password:       password,
+		client:         &http.Client{Timeout: cfg.Timeout, Transport: transport},
+		uploadClient:   &http.Client{Transport: transport},
+		downloadClient: &http.Client{Timeout: cfg.DownloadTimeout, Transport: transport},
+		folders:        make(map[string]bool),
+	}, nil
+}
+
+// url escapes every segment of an absolute destination path
+func (d *WebDAVDestination) url(p string) string {
+	segments := strings.Split(strings.Trim(path.Clean("/"+p), "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return d.base + "/" + strings.Join(segments, "/")
+}
+
+func (d *WebDAVDestination) newRequest(ctx context.Context, method, p string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, d.url(p), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s request: %w", method, err)
+	}
+	if d.username != "" || d.password != "" {
+		req.SetBasicAuth(d.username, d.password)
+	}
+	return req, nil
+}
+
+// propfind returns the properties of p, or nil if it does not exist
+func (d *WebDAVDestination) propfind(p string) (*davMultistatus, error) {
+	req, err := d.newRequest(context.Background(), "PROPFIND", p, strings.NewReader(davPropfindBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", "0")
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("PROPFIND request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusMultiStatus:
+	case http.StatusNotFound:
+		return nil, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, fmt.Errorf("%w: WebDAV server returned status %d", common.ErrAuthFailed, resp.StatusCode)
+	default:
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("PROPFIND failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var result davMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to parse PROPFIND response: %w", err)
+	}
+	return &result, nil
+}
+
+// Login checks the credentials against the base collection
+func (d *WebDAVDestination) Login() error {
+	result, err := d.propfind("/")
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		return fmt.Errorf("WebDAV collection %s does not exist", d.base)
+	}
+	common.Infof("Connected to WebDAV server at %s", d.base)
+	return nil
+}
+
+func (d *WebDAVDestination) SetBandwidthLimiters(limiters ...*BandwidthLimiter) {
+	d.limiters = limiters
+}
+
+func (d *WebDAVDestination) SetSource(source Source) {
+	d.source = source
+}
+
+// CreateFolder creates p and its missing parents, one MKCOL per level
+func (d *WebDAVDestination) CreateFolder(p string) error {
+	p = path.Clean("/" + p)
+
+	d.foldersMu.Lock()
+	defer d.foldersMu.Unlock()
+
+	if d.folders[p] || p == "/" {
+		return nil
+	}
+
+	current := ""
+	for _, segment := range strings.Split(strings.TrimPrefix(p, "/"), "/") {
+		current += "/" + segment
+		if d.folders[current] {
+			continue
+		}
+
+		req, err := d.newRequest(context.Background(), "MKCOL", current, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := d.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("MKCOL request failed: %w", err)
+		}
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		// 405 Method Not Allowed means the collection already exists
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+			return fmt.Errorf("create folder %s failed with status %d: %s", current, resp.StatusCode, string(bodyBytes))
+		}
+		d.folders[current] = true
+	}
+	return nil
+}
+
+func (d *WebDAVDestination) UploadFile(ctx context.Context, localPath, remotePath string, onProgress func(delta int64)) error {
+	file, source, stat, err := openUploadSource(ctx, d.source, localPath, d.limiters, onProgress)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	uploadCtx, watch, stopWatch := withStallWatch(ctx, localPath)
+	defer stopWatch()
+
+	watch.touch(true)
+	req, err := d.newRequest(uploadCtx, "PUT", remotePath, &watchedBody{reader: io.NopCloser(source), watch: watch})
+	if err != nil {
+		return err
+	}
+	req.ContentLength = stat.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-OC-Mtime", fmt.Sprintf("%d", stat.ModTime().Unix()))
+
+	resp, err := d.uploadClient.Do(req)
+	if err != nil {
+		if stalled := stalledError(ctx, uploadCtx); stalled != nil {
+			return fmt.Errorf("upload request failed: %w", stalled)
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("upload cancelled by user")
+		}
+		return fmt.Errorf("upload request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+	if resp.Header.Get("X-OC-Mtime") == "accepted" {
+		d.mtimeAccepted.Store(true)
+	}
+
+	common.Infof("File uploaded successfully: %s -> %s", localPath, remotePath)
+	return nil
+}
+
+// GetFileInfo reports Modified as 0 (unknown) unless the server is known to
+// keep the modification time sent with the upload
+func (d *WebDAVDestination) GetFileInfo(filePath string) (*FileMetadata, error) {
+	result, err := d.propfind(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil || len(result.Responses) == 0 {
+		return nil, fmt.Errorf("%w: %s", common.ErrFileNotFound, filePath)
+	}
+
+	for _, propstat := range result.Responses[0].Propstat {
+		if !strings.Contains(propstat.Status, " 200") {
+			continue
+		}
+		prop := propstat.Prop
+
+		var modTime time.Time
+		if d.mtimeAccepted.Load() {
+			if t, err := http.ParseTime(prop.LastModified); err == nil {
+				modTime = t
+			}
+		}
+		meta := fileMetadata(filePath, prop.ContentLength, modTime, prop.ResourceType.Collection != nil)
+		if modTime.IsZero() {
+			meta.Modified = 0
+		}
+		return meta, nil
+	}
+	return nil, fmt.Errorf("no properties returned for %s", filePath)
+}
+
+func (d *WebDAVDestination) DownloadPartialFile(filePath string, size int64) ([]byte, error) {
+	return d.DownloadRange(filePath, 0, size)
+}
+
+func (d *WebDAVDestination) DownloadRange(filePath string, offset, size int64) ([]byte, error) {
+	if size <= 0 {
+		return []byte{}, nil
+	}
+	req, err := d.newRequest(context.Background(), "GET", filePath, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", rangeHeader(offset, size))
+
+	resp, err := d.downloadClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("download failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+	return readRange(resp, offset, size)
+}
+
+func (d *WebDAVDestination) Close() error {
+	return nil
+}
@@ -4,18 +4,31 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"mime/multipart"
 	"net/http"
 	"net/url"
-	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/config"
 )
 
+// tokenRefreshMargin is how long before expiry the access token is refreshed
+const tokenRefreshMargin = 2 * time.Minute
+
+// errReplay aborts the body of a request that is about to be replayed
+var errReplay = errors.New("request replayed after re-authentication")
+
+// errUploadStalled aborts an upload that made no progress for ZIMAOS_STALL_TIMEOUT
+var errUploadStalled = errors.New("upload stalled")
+
 // cancelableReader wraps io.Reader and supports cancellation via context
 type cancelableReader struct {
 	ctx    context.Context
@@ -33,11 +46,21 @@ func (r *cancelableReader) Read(p []byte) (n int, err error) {
 }
 
 type ZimaOSClient struct {
-	host     string
-	username string
-	password string
-	token    string
-	client   *http.Client
+	host           string
+	username       string
+	password       string
+	client         *http.Client // API calls, limited by ZIMAOS_TIMEOUT
+	uploadClient   *http.Client // Uploads and streaming downloads: no overall limit, stalls are detected instead
+	downloadClient *http.Client // Verification downloads, limited by ZIMAOS_DOWNLOAD_TIMEOUT
+	limiters       []*BandwidthLimiter
+	source         Source // Where uploads are read from, nil for the local filesystem
+	listings       listingCache
+
+	// Token state, guarded by authMu
+	authMu       sync.Mutex
+	token        string
+	refreshToken string
+	expiresAt    time.Time // Zero if the server did not report an expiry
 }
 
 type LoginRequest struct {
@@ -60,6 +83,10 @@ type TokenObject struct {
 	ExpiresAt    int64  `json:"expires_at"`
 }
 
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
 type CreateFolderRequest struct {
 	Path string `json:"path"`
 }
@@ -97,14 +124,49 @@ type StorageDevice struct {
 }
 
 func NewZimaOSClient(host, username, password string) *ZimaOSClient {
-	return &ZimaOSClient{
+	// Invalid hosts are kept as given and fail on the first request
+	if normalized, err := NormalizeHost(host, "http"); err == nil {
+		host = normalized
+	}
+
+	c := &ZimaOSClient{
 		host:     host,
 		username: username,
 		password: password,
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
 	}
+	// Default TLS options always build a valid transport
+	transport, _ := sharedTransport(host, TLSOptions{})
+	c.useTransport(transport)
+	return c
+}
+
+// SetTLSOptions applies custom certificate verification to every request
+func (c *ZimaOSClient) SetTLSOptions(opts TLSOptions) error {
+	transport, err := sharedTransport(c.host, opts)
+	if err != nil {
+		return err
+	}
+	if opts.InsecureSkipVerify {
+		common.Warnf("TLS certificate verification is disabled for %s", c.host)
+	}
+	c.useTransport(transport)
+	return nil
+}
+
+func (c *ZimaOSClient) useTransport(transport *http.Transport) {
+	cfg := config.AppConfig.ZimaOS
+	c.client = &http.Client{Timeout: cfg.Timeout, Transport: transport}
+	c.uploadClient = &http.Client{Transport: transport}
+	c.downloadClient = &http.Client{Timeout: cfg.DownloadTimeout, Transport: transport}
+}
+
+// SetBandwidthLimiters throttles uploads through all given limiters
+func (c *ZimaOSClient) SetBandwidthLimiters(limiters ...*BandwidthLimiter) {
+	c.limiters = limiters
+}
+
+func (c *ZimaOSClient) SetSource(source Source) {
+	c.source = source
 }
 
 func (c *ZimaOSClient) Login() error {
@@ -143,10 +205,28 @@ func (c *ZimaOSClient) Login() error {
 		return fmt.Errorf("failed to read response body: %w", err)
 	}
 
+	token, err := parseTokenResponse(bodyBytes)
+	if err != nil {
+		return err
+	}
+	if token.AccessToken == "" {
+		return fmt.Errorf("no token received from login response")
+	}
+
+	c.setToken(token)
+	common.Infof("Successfully logged in to ZimaOS at %s", c.host)
+	return nil
+}
+
+// parseTokenResponse extracts the token from the different login/refresh
+// response formats used by ZimaOS versions
+func parseTokenResponse(bodyBytes []byte) (TokenObject, error) {
+	var result TokenObject
+
 	// Try to parse as structured response
 	var loginResp LoginResponse
 	if err := json.Unmarshal(bodyBytes, &loginResp); err != nil {
-		return fmt.Errorf("failed to decode login response: %w", err)
+		return result, fmt.Errorf("failed to decode login response: %w", err)
 	}
 
 	// Try to extract token from different response formats
@@ -154,45 +234,177 @@ func (c *ZimaOSClient) Login() error {
 		switch token := loginResp.Data.Token.(type) {
 		case string:
 			// Old format: token is a string
-			c.token = token
+			result.AccessToken = token
 		case map[string]interface{}:
 			// New format: token is an object with access_token
 			if accessToken, ok := token["access_token"].(string); ok {
-				c.token = accessToken
+				result.AccessToken = accessToken
 			} else if refreshToken, ok := token["refresh_token"].(string); ok {
-				c.token = refreshToken
+				result.AccessToken = refreshToken
+			}
+			if refreshToken, ok := token["refresh_token"].(string); ok {
+				result.RefreshToken = refreshToken
+			}
+			if expiresAt, ok := token["expires_at"].(float64); ok {
+				result.ExpiresAt = int64(expiresAt)
 			}
 		}
 	}
 
 	// Fallback: try to find token in raw response
-	if c.token == "" {
+	if result.AccessToken == "" {
 		var rawResp map[string]interface{}
 		if err := json.Unmarshal(bodyBytes, &rawResp); err == nil {
 			// Try data.token as string
 			if data, ok := rawResp["data"].(map[string]interface{}); ok {
 				if token, ok := data["token"].(string); ok {
-					c.token = token
+					result.AccessToken = token
 				} else if tokenObj, ok := data["token"].(map[string]interface{}); ok {
 					if accessToken, ok := tokenObj["access_token"].(string); ok {
-						c.token = accessToken
+						result.AccessToken = accessToken
 					}
 				}
 			}
 			// Try top-level token
-			if c.token == "" {
+			if result.AccessToken == "" {
 				if token, ok := rawResp["token"].(string); ok {
-					c.token = token
+					result.AccessToken = token
 				}
 			}
 		}
 	}
 
-	if c.token == "" {
-		return fmt.Errorf("no token received from login response")
+	return result, nil
+}
+
+func (c *ZimaOSClient) setToken(token TokenObject) {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
+	c.token = token.AccessToken
+	if token.RefreshToken != "" {
+		c.refreshToken = token.RefreshToken
+	}
+	c.expiresAt = time.Time{}
+	if token.ExpiresAt > 0 {
+		if token.ExpiresAt > 1e12 {
+			// Milliseconds
+			c.expiresAt = time.UnixMilli(token.ExpiresAt)
+		} else {
+			c.expiresAt = time.Unix(token.ExpiresAt, 0)
+		}
 	}
+}
+
+// Refresh exchanges the refresh token for a new access token
+func (c *ZimaOSClient) Refresh() error {
+	c.authMu.Lock()
+	refreshToken := c.refreshToken
+	c.authMu.Unlock()
 
-	common.Infof("Successfully logged in to ZimaOS, token: %s", c.token[:10]+"...")
+	if refreshToken == "" {
+		return fmt.Errorf("no refresh token available")
+	}
+
+	body, err := json.Marshal(RefreshRequest{RefreshToken: refreshToken})
+	if err != nil {
+		return fmt.Errorf("failed to marshal refresh request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/v1/users/refresh", c.host)
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("failed to create refresh request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("refresh request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("refresh failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	token, err := parseTokenResponse(bodyBytes)
+	if err != nil {
+		return err
+	}
+	if token.AccessToken == "" {
+		return fmt.Errorf("no token received from refresh response")
+	}
+
+	c.setToken(token)
+	common.Info("Refreshed ZimaOS access token")
+	return nil
+}
+
+// ensureToken logs in if needed and refreshes the access token shortly
+// before it expires, falling back to a full login if the refresh fails
+func (c *ZimaOSClient) ensureToken() error {
+	c.authMu.Lock()
+	token := c.token
+	expiresAt := c.expiresAt
+	c.authMu.Unlock()
+
+	if token == "" {
+		return c.Login()
+	}
+
+	if !expiresAt.IsZero() && time.Until(expiresAt) < tokenRefreshMargin {
+		if err := c.Refresh(); err != nil {
+			common.Warnf("Token refresh failed, logging in again: %v", err)
+			return c.Login()
+		}
+	}
+	return nil
+}
+
+// doWithAuth sends an authenticated request built by build. On 401 it logs in
+// again and replays the request once; beforeReplay lets the caller undo side
+// effects of the first attempt (e.g. progress already reported).
+func (c *ZimaOSClient) doWithAuth(httpClient *http.Client, build func(token string) (*http.Request, error), beforeReplay func()) (*http.Response, error) {
+	if err := c.ensureToken(); err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := build(c.GetToken())
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, nil
+		}
+
+		// Token expired or revoked: re-authenticate and replay once
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		common.Warnf("ZimaOS returned 401 for %s %s, logging in again", req.Method, req.URL.Path)
+
+		if beforeReplay != nil {
+			beforeReplay()
+		}
+		if err := c.Login(); err != nil {
+			return nil, fmt.Errorf("re-authentication failed: %w", err)
+		}
+	}
+}
+
+// Close is a no-op: connections belong to the shared transport
+func (c *ZimaOSClient) Close() error {
 	return nil
 }
 
@@ -204,11 +416,7 @@ func (c *ZimaOSClient) TestConnection() error {
 }
 
 func (c *ZimaOSClient) CreateFolder(path string) error {
-	if c.token == "" {
-		if err := c.Login(); err != nil {
-			return err
-		}
-	}
+	defer c.listings.invalidate(filepath.Dir(path))
 
 	createReq := CreateFolderRequest{
 		Path: path,
@@ -220,15 +428,16 @@ func (c *ZimaOSClient) CreateFolder(path string) error {
 	}
 
 	url := fmt.Sprintf("%s/v2_1/files/folder", c.host)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
-	if err != nil {
-		return fmt.Errorf("failed to create folder request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", c.token)
+	resp, err := c.doWithAuth(c.client, func(token string) (*http.Request, error) {
+		req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create folder request: %w", err)
+		}
 
-	resp, err := c.client.Do(req)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", token)
+		return req, nil
+	}, nil)
 	if err != nil {
 		return fmt.Errorf("create folder request failed: %w", err)
 	}
@@ -268,87 +477,131 @@ func (c *ZimaOSClient) CreateFolder(path string) error {
 }
 
 func (c *ZimaOSClient) UploadFile(ctx context.Context, localPath, remotePath string, onProgress func(delta int64)) error {
-	if c.token == "" {
-		if err := c.Login(); err != nil {
-			return err
+	// Even a failed upload may have left a partial file behind
+	defer c.listings.invalidate(filepath.Dir(remotePath))
+
+	// Progress of an attempt that gets replayed after a 401 is rolled back,
+	// so the caller never counts the same bytes twice
+	var progressMu sync.Mutex
+	var attemptSent int64
+	attemptActive := true
+	reportProgress := func(delta int64) {
+		progressMu.Lock()
+		defer progressMu.Unlock()
+		if !attemptActive {
+			return
+		}
+		attemptSent += delta
+		if onProgress != nil {
+			onProgress(delta)
 		}
 	}
 
-	file, err := os.Open(localPath)
-	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
-	}
-	defer file.Close()
+	var currentPipe *io.PipeReader
+	beforeReplay := func() {
+		if currentPipe != nil {
+			currentPipe.CloseWithError(errReplay)
+		}
 
-	stat, err := file.Stat()
-	if err != nil {
-		return fmt.Errorf("failed to stat file: %w", err)
-	}
+		progressMu.Lock()
+		attemptActive = false
+		rollback := attemptSent
+		progressMu.Unlock()
 
-	// Use pipe for streaming upload to avoid loading entire file into memory
-	pr, pw := io.Pipe()
-	mw := multipart.NewWriter(pw)
+		if rollback > 0 && onProgress != nil {
+			onProgress(-rollback)
+		}
 
-	// Write multipart data in a goroutine
-	go func() {
-		defer pw.Close() // Must close pw after mw to signal EOF
-		defer mw.Close() // Must close mw first to write ending boundary
+		progressMu.Lock()
+		attemptSent = 0
+		attemptActive = true
+		progressMu.Unlock()
+	}
 
-		// Write form fields first
-		if err := mw.WriteField("path", filepath.Dir(remotePath)); err != nil {
-			common.Errorf("Failed to write path field: %v", err)
-			return
-		}
+	// The stall detector cancels uploadCtx; ctx stays the caller's cancellation
+	uploadCtx, watch, stopWatch := withStallWatch(ctx, localPath)
+	defer stopWatch()
 
-		// Create form file part
-		part, err := mw.CreateFormFile("file", filepath.Base(localPath))
+	// Use context to create request (cancelable)
+	url := fmt.Sprintf("%s/v2_1/files/file/uploadV2", c.host)
+	resp, err := c.doWithAuth(c.uploadClient, func(token string) (*http.Request, error) {
+		file, stat, err := openSourceFile(uploadCtx, c.source, localPath)
 		if err != nil {
-			common.Errorf("Failed to create form file: %v", err)
-			return
+			return nil, err
 		}
 
-		// Use cancelable reader to wrap the file
-		cancelableFile := &cancelableReader{ctx: ctx, reader: file}
+		// Use pipe for streaming upload to avoid loading entire file into memory
+		pr, pw := io.Pipe()
+		mw := multipart.NewWriter(pw)
+		currentPipe = pr
+
+		// Write multipart data in a goroutine
+		go func() {
+			defer file.Close()
+			defer pw.Close() // Must close pw after mw to signal EOF
+			defer mw.Close() // Must close mw first to write ending boundary
+
+			// Write form fields first
+			if err := mw.WriteField("path", filepath.Dir(remotePath)); err != nil {
+				common.Errorf("Failed to write path field: %v", err)
+				return
+			}
 
-		progressReader := &progressReader{
-			reader:     cancelableFile,
-			onProgress: onProgress,
-		}
+			// Create form file part
+			part, err := mw.CreateFormFile("file", filepath.Base(remotePath))
+			if err != nil {
+				common.Errorf("Failed to create form file: %v", err)
+				return
+			}
+
+			// Use cancelable reader to wrap the file
+			cancelableFile := &cancelableReader{ctx: uploadCtx, reader: file}
 
-		// Copy file content (can be cancelled by context)
-		if _, err := io.Copy(part, progressReader); err != nil {
-			if err == context.Canceled || err == context.DeadlineExceeded {
-				common.Infof("File upload cancelled: %v", err)
-			} else {
-				common.Errorf("Failed to copy file: %v", err)
+			// Throttle before reporting progress so speed reflects the limited rate
+			var source io.Reader = cancelableFile
+			if len(c.limiters) > 0 {
+				source = &throttledReader{ctx: uploadCtx, reader: cancelableFile, limiters: c.limiters}
 			}
-			return
-		}
 
-		// Write modTime field
-		if err := mw.WriteField("modTime", fmt.Sprintf("%s:%d", filepath.Base(localPath), stat.ModTime().Unix())); err != nil {
-			common.Errorf("Failed to write modTime field: %v", err)
-			return
-		}
-	}()
+			progressReader := &progressReader{
+				reader:     source,
+				onProgress: reportProgress,
+			}
 
-	// Use context to create request (cancelable)
-	url := fmt.Sprintf("%s/v2_1/files/file/uploadV2", c.host)
-	req, err := http.NewRequestWithContext(ctx, "POST", url, pr)
-	if err != nil {
-		return fmt.Errorf("failed to create upload request: %w", err)
-	}
+			// Copy file content (can be cancelled by context)
+			if _, err := io.Copy(part, progressReader); err != nil {
+				if err == context.Canceled || err == context.DeadlineExceeded {
+					common.Infof("File upload cancelled: %v", err)
+				} else if !errors.Is(err, errReplay) {
+					common.Errorf("Failed to copy file: %v", err)
+				}
+				return
+			}
 
-	req.Header.Set("Content-Type", mw.FormDataContentType())
-	req.Header.Set("Authorization", c.token)
+			// Write modTime field
+			if err := mw.WriteField("modTime", fmt.Sprintf("%s:%d", filepath.Base(remotePath), stat.ModTime().Unix())); err != nil {
+				common.Errorf("Failed to write modTime field: %v", err)
+				return
+			}
+		}()
 
-	uploadClient := &http.Client{
-		Timeout: 0,
-	}
+		watch.touch(true)
+		req, err := http.NewRequestWithContext(uploadCtx, "POST", url, &watchedBody{reader: pr, watch: watch})
+		if err != nil {
+			pr.CloseWithError(err)
+			return nil, fmt.Errorf("failed to create upload request: %w", err)
+		}
+
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.Header.Set("Authorization", token)
+		return req, nil
+	}, beforeReplay)
 
 	// Send request (will be interrupted when context is cancelled)
-	resp, err := uploadClient.Do(req)
 	if err != nil {
+		if stalled := stalledError(ctx, uploadCtx); stalled != nil {
+			return fmt.Errorf("upload request failed: %w", stalled)
+		}
 		// Check if it's a cancellation error
 		if err == context.Canceled {
 			return fmt.Errorf("upload cancelled by user")
@@ -383,40 +636,37 @@ func (p *progressReader) Read(buf []byte) (int, error) {
 }
 
 func (c *ZimaOSClient) GetToken() string {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
 	return c.token
 }
 
-// GetFileInfo retrieves metadata for a specific file from ZimaOS
-// It queries the parent directory and finds the target file
-func (c *ZimaOSClient) GetFileInfo(filePath string) (*FileMetadata, error) {
-	if c.token == "" {
-		if err := c.Login(); err != nil {
-			return nil, err
-		}
-	}
-
-	// Get parent directory
-	parentDir := filepath.Dir(filePath)
-	fileName := filepath.Base(filePath)
+// listPageSize is the number of entries requested per listing page
+const listPageSize = 1000
 
-	// Query directory listing with all required parameters
+// listPage fetches one page of the listing of dir
+func (c *ZimaOSClient) listPage(dir string, index, size int) (*FileListResponse, error) {
 	// Use url.QueryEscape to properly encode the path (handles Chinese and special characters)
-	requestURL := fmt.Sprintf("%s/v2_1/files/file?path=%s&index=0&size=10000&sfz=true&sort=name&direction=asc",
-		c.host, url.QueryEscape(parentDir))
-	req, err := http.NewRequest("GET", requestURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Authorization", c.token)
-	req.Header.Set("Accept", "application/json, text/plain, */*")
+	requestURL := fmt.Sprintf("%s/v2_1/files/file?path=%s&index=%d&size=%d&sfz=true&sort=name&direction=asc",
+		c.host, url.QueryEscape(dir), index, size)
+	resp, err := c.doWithAuth(c.client, func(token string) (*http.Request, error) {
+		req, err := http.NewRequest("GET", requestURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
 
-	resp, err := c.client.Do(req)
+		req.Header.Set("Authorization", token)
+		req.Header.Set("Accept", "application/json, text/plain, */*")
+		return req, nil
+	}, nil)
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", common.ErrFileNotFound, dir)
+	}
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		return nil, fmt.Errorf("failed to get file list with status %d: %s", resp.StatusCode, string(bodyBytes))
@@ -431,87 +681,273 @@ func (c *ZimaOSClient) GetFileInfo(filePath string) (*FileMetadata, error) {
 	if err := json.Unmarshal(bodyBytes, &fileList); err != nil {
 		return nil, fmt.Errorf("failed to parse response: %w", err)
 	}
+	return &fileList, nil
+}
+
+// ListFolder returns every entry of dir, requesting further pages until the
+// listing's total is reached. The response echoes index and size, but ZimaOS
+// does not document whether index counts pages or entries, and it may cap
+// size. Later pages therefore ask for the echoed size, and entries are kept by
+// name: read either way, index 0, 1, 2... lists every entry once. A page that
+// adds no new entry ends the listing, so a wrong total cannot loop forever.
+func (c *ZimaOSClient) ListFolder(dir string) ([]FileMetadata, error) {
+	var entries []FileMetadata
+	seen := make(map[string]bool)
+	size := listPageSize
+	for index := 0; ; index++ {
+		page, err := c.listPage(dir, index, size)
+		if err != nil {
+			return nil, err
+		}
+		added := 0
+		for _, entry := range page.Content {
+			if !seen[entry.Name] {
+				seen[entry.Name] = true
+				entries = append(entries, entry)
+				added++
+			}
+		}
 
-	// Find the target file in the list
-	for _, file := range fileList.Content {
-		if file.Name == fileName {
-			return &file, nil
+		if len(entries) >= page.Total {
+			return entries, nil
+		}
+		if added == 0 {
+			common.Warnf("Listing of %s stopped at %d of %d entries: page %d added none", dir, len(entries), page.Total, index)
+			return entries, nil
+		}
+		if page.Size > 0 && page.Size < size {
+			size = page.Size
 		}
 	}
+}
 
-	return nil, fmt.Errorf("file not found: %s", filePath)
+// WalkFolder calls fn for every entry below root (not root itself),
+// directories before their contents. Entry paths are set to the full path.
+// A directory that cannot be listed is passed to fn again with the error;
+// returning fs.SkipDir for a directory skips its contents.
+func (c *ZimaOSClient) WalkFolder(root string, fn func(entry FileMetadata, err error) error) error {
+	entries, err := c.ListFolder(root)
+	if err != nil {
+		return err
+	}
+	return c.walkEntries(root, entries, fn)
 }
 
-// DownloadPartialFile downloads a portion of a file from ZimaOS
-// size: number of bytes to download from the beginning (e.g., 1MB = 1048576)
-func (c *ZimaOSClient) DownloadPartialFile(filePath string, size int64) ([]byte, error) {
-	if c.token == "" {
-		if err := c.Login(); err != nil {
-			return nil, err
+func (c *ZimaOSClient) walkEntries(dir string, entries []FileMetadata, fn func(entry FileMetadata, err error) error) error {
+	for _, entry := range entries {
+		entry.Path = filepath.Join(dir, entry.Name)
+		err := fn(entry, nil)
+		if entry.IsDir && errors.Is(err, fs.SkipDir) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir {
+			continue
+		}
+
+		children, err := c.ListFolder(entry.Path)
+		if err != nil {
+			if err := fn(entry, err); err != nil && !errors.Is(err, fs.SkipDir) {
+				return err
+			}
+			continue
+		}
+		if err := c.walkEntries(entry.Path, children, fn); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	// Use ZimaOS v3/file download API
-	// token, files, and action are query parameters
-	requestURL := fmt.Sprintf("%s/v3/file?token=%s&files=%s&action=download",
-		c.host, c.token, url.QueryEscape(filePath))
-	req, err := http.NewRequest("GET", requestURL, nil)
+// GetFileInfo retrieves metadata for a specific file from ZimaOS
+// It looks the file up in the cached listing of its parent directory
+func (c *ZimaOSClient) GetFileInfo(filePath string) (*FileMetadata, error) {
+	entries, err := c.cachedListing(filepath.Dir(filePath))
+	if errors.Is(err, common.ErrFileNotFound) {
+		return nil, fmt.Errorf("%w: %s", common.ErrFileNotFound, filePath)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
 
-	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7")
-	// Set Range header to request only the first 'size' bytes
-	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", size-1))
+	if file, ok := entries[filepath.Base(filePath)]; ok {
+		return &file, nil
+	}
+	return nil, fmt.Errorf("%w: %s", common.ErrFileNotFound, filePath)
+}
 
-	downloadClient := &http.Client{
-		Timeout: 60 * time.Second, // Longer timeout for downloads
+// cachedListing returns the entries of dir by name, listing it on a cache miss
+func (c *ZimaOSClient) cachedListing(dir string) (map[string]FileMetadata, error) {
+	entries, generation, ok := c.listings.get(dir)
+	if ok {
+		return entries, nil
 	}
 
-	resp, err := downloadClient.Do(req)
+	list, err := c.ListFolder(dir)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
+	return c.listings.put(dir, list, generation), nil
+}
 
-	// Accept both 200 (full content) and 206 (partial content) status codes
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("download failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+// Reasons a folder is rejected by CheckFolder
+var (
+	errOutsideStorages = fmt.Errorf("%w: not inside a ZimaOS storage", common.ErrInvalidRequest)
+	errFolderNotFound  = fmt.Errorf("%w: folder does not exist", common.ErrInvalidRequest)
+	errNotAFolder      = fmt.Errorf("%w: not a folder", common.ErrInvalidRequest)
+)
+
+// CheckFolder returns nil if folder is an existing folder inside one of the
+// storages, so mistyped base paths are caught before a task is created
+func (c *ZimaOSClient) CheckFolder(folder string) error {
+	if folder == "" || !filepath.IsAbs(folder) || strings.ContainsRune(folder, 0) {
+		return fmt.Errorf("%w: path must be absolute: %q", common.ErrInvalidRequest, folder)
 	}
+	folder = filepath.Clean(folder)
 
-	// Read the response body (will be limited by Range header if supported)
-	data, err := io.ReadAll(resp.Body)
+	storages, err := c.GetStorageList()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return err
 	}
+	for _, storage := range storages {
+		if folder == filepath.Clean(storage.Path) {
+			return nil
+		}
+		if !pathWithin(folder, storage.Path) {
+			continue
+		}
 
-	// If the server doesn't support Range requests, manually limit the data
-	if int64(len(data)) > size {
-		data = data[:size]
+		meta, err := c.GetFileInfo(folder)
+		if errors.Is(err, common.ErrFileNotFound) {
+			return fmt.Errorf("%w: %s", errFolderNotFound, folder)
+		}
+		if err != nil {
+			return err
+		}
+		if !meta.IsDir {
+			return fmt.Errorf("%w: %s", errNotAFolder, folder)
+		}
+		return nil
 	}
-
-	return data, nil
+	return fmt.Errorf("%w: %s", errOutsideStorages, folder)
 }
 
-// GetStorageList retrieves the list of storage devices from ZimaOS
-func (c *ZimaOSClient) GetStorageList() ([]StorageDevice, error) {
-	if c.token == "" {
-		if err := c.Login(); err != nil {
+// BrowseFolders returns the folders directly below folder, or the storages
+// when folder is empty or "/"
+func (c *ZimaOSClient) BrowseFolders(folder string) ([]FileMetadata, error) {
+	if folder == "" || folder == "/" {
+		storages, err := c.GetStorageList()
+		if err != nil {
 			return nil, err
 		}
+		folders := make([]FileMetadata, 0, len(storages))
+		for _, storage := range storages {
+			folders = append(folders, FileMetadata{Name: storage.Name, Path: storage.Path, IsDir: true})
+		}
+		return folders, nil
 	}
 
-	requestURL := fmt.Sprintf("%s/v2/local_storage/storages", c.host)
-	req, err := http.NewRequest("GET", requestURL, nil)
+	if err := c.CheckFolder(folder); err != nil {
+		return nil, err
+	}
+	entries, err := c.ListFolder(filepath.Clean(folder))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, err
+	}
+
+	folders := make([]FileMetadata, 0)
+	for _, entry := range entries {
+		if entry.IsDir {
+			entry.Path = filepath.Join(filepath.Clean(folder), entry.Name)
+			folders = append(folders, entry)
+		}
 	}
+	return folders, nil
+}
 
-	req.Header.Set("Authorization", c.token)
-	req.Header.Set("Accept", "application/json")
+// DownloadFile streams a whole file. Like uploads it has no overall time
+// limit; the caller must close the returned body.
+func (c *ZimaOSClient) DownloadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	resp, err := c.doWithAuth(c.uploadClient, func(token string) (*http.Request, error) {
+		requestURL := fmt.Sprintf("%s/v3/file?files=%s&action=download",
+			c.host, url.QueryEscape(filePath))
+		req, err := http.NewRequestWithContext(ctx, "GET", requestURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
 
-	resp, err := c.client.Do(req)
+		req.Header.Set("Authorization", token)
+		return req, nil
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("download failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+	return resp.Body, nil
+}
+
+// DownloadPartialFile downloads a portion of a file from ZimaOS
+// size: number of bytes to download from the beginning (e.g., 1MB = 1048576)
+func (c *ZimaOSClient) DownloadPartialFile(filePath string, size int64) ([]byte, error) {
+	return c.DownloadRange(filePath, 0, size)
+}
+
+// DownloadRange downloads size bytes of a file from ZimaOS, starting at offset
+func (c *ZimaOSClient) DownloadRange(filePath string, offset, size int64) ([]byte, error) {
+	if size <= 0 {
+		return []byte{}, nil
+	}
+	resp, err := c.doWithAuth(c.downloadClient, func(token string) (*http.Request, error) {
+		// Use ZimaOS v3/file download API. The token goes in the Authorization
+		// header rather than the query string, which proxies and servers log.
+		requestURL := fmt.Sprintf("%s/v3/file?files=%s&action=download",
+			c.host, url.QueryEscape(filePath))
+		req, err := http.NewRequest("GET", requestURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		req.Header.Set("Authorization", token)
+		req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7")
+		// Set Range header to request only the bytes needed
+		req.Header.Set("Range", rangeHeader(offset, size))
+		return req, nil
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Accept both 200 (full content) and 206 (partial content) status codes
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("download failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	// Read the response body (limited by the Range header if supported)
+	return readRange(resp, offset, size)
+}
+
+// GetStorageList retrieves the list of storage devices from ZimaOS
+func (c *ZimaOSClient) GetStorageList() ([]StorageDevice, error) {
+	requestURL := fmt.Sprintf("%s/v2/local_storage/storages", c.host)
+	resp, err := c.doWithAuth(c.client, func(token string) (*http.Request, error) {
+		req, err := http.NewRequest("GET", requestURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		req.Header.Set("Authorization", token)
+		req.Header.Set("Accept", "application/json")
+		return req, nil
+	}, nil)
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
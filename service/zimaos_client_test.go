@@ -0,0 +1,90 @@
+package service_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/service/zimaosfake"
+)
+
+func TestListFolderFollowsCappedPages(t *testing.T) {
+	tests := []struct {
+		name      string
+		listIndex int
+		listTotal int
+		want      int
+		pages     int
+	}{
+		{"page index", zimaosfake.IndexPage, 0, 25, 4},
+		{"offset index", zimaosfake.IndexOffset, 0, 25, 19},
+		{"total too high", zimaosfake.IndexPage, 40, 25, 5},
+		{"index ignored", zimaosfake.IndexIgnored, 0, 7, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, err := zimaosfake.New("admin", "secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fake.Close()
+			fake.PageSize = 7
+			fake.ListIndex = tt.listIndex
+			fake.ListTotal = tt.listTotal
+
+			dir := "/media/ZimaOS-HD/big"
+			if err := os.MkdirAll(fake.LocalPath(dir), 0755); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 25; i++ {
+				if err := os.WriteFile(filepath.Join(fake.LocalPath(dir), fmt.Sprintf("f%02d", i)), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			entries, err := fake.Client().ListFolder(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := make(map[string]bool)
+			for _, entry := range entries {
+				if names[entry.Name] {
+					t.Errorf("%s listed twice", entry.Name)
+				}
+				names[entry.Name] = true
+			}
+			if len(entries) != tt.want {
+				t.Errorf("got %d entries, want %d", len(entries), tt.want)
+			}
+			if pages := fake.Requests(zimaosfake.EndpointList); pages != tt.pages {
+				t.Errorf("listed %d pages, want %d", pages, tt.pages)
+			}
+		})
+	}
+}
+
+func TestGetFileInfoReportsMissingFiles(t *testing.T) {
+	fake, err := zimaosfake.New("admin", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+	if err := os.MkdirAll(fake.LocalPath("/media/ZimaOS-HD/dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	client := fake.Client()
+
+	for _, path := range []string{"/media/ZimaOS-HD/dir/missing", "/media/ZimaOS-HD/nodir/missing"} {
+		if _, err := client.GetFileInfo(path); !errors.Is(err, common.ErrFileNotFound) {
+			t.Errorf("%s: got %v, want ErrFileNotFound", path, err)
+		}
+	}
+
+	fake.InjectFault(zimaosfake.EndpointList, zimaosfake.Fault{Status: 500})
+	if _, err := fake.Client().GetFileInfo("/media/ZimaOS-HD/dir/other"); err == nil || errors.Is(err, common.ErrFileNotFound) {
+		t.Errorf("server error: got %v, want an error other than ErrFileNotFound", err)
+	}
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"sync"
+)
+
+// maxCachedListings bounds the memory held by a client's listing cache
+const maxCachedListings = 256
+
+// listingCache keeps complete directory listings so that checking many files
+// of one directory costs a single (paginated) listing. Uploads and folder
+// creation invalidate the directory they change.
+type listingCache struct {
+	mu       sync.Mutex
+	listings map[string]map[string]FileMetadata // Directory -> name -> entry
+	// Bumped by every invalidation, so a listing fetched while a directory
+	// changed is not stored
+	generation uint64
+}
+
+// get returns the cached listing of dir, or the current generation to pass
+// to put once the listing was fetched
+func (l *listingCache) get(dir string) (map[string]FileMetadata, uint64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries, ok := l.listings[dir]
+	return entries, l.generation, ok
+}
+
+// put indexes entries by name and stores them unless the cache was
+// invalidated since generation
+func (l *listingCache) put(dir string, entries []FileMetadata, generation uint64) map[string]FileMetadata {
+	byName := make(map[string]FileMetadata, len(entries))
+	for _, entry := range entries {
+		byName[entry.Name] = entry
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if generation != l.generation {
+		return byName
+	}
+	if l.listings == nil || len(l.listings) >= maxCachedListings {
+		l.listings = make(map[string]map[string]FileMetadata)
+	}
+	l.listings[dir] = byName
+	return byName
+}
+
+func (l *listingCache) invalidate(dir string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.generation++
+	delete(l.listings, dir)
+}
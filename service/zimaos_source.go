@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/models"
+)
+
+// ZimaOSSource reads from a ZimaOS instance through its file API, for
+// ZimaOS-to-ZimaOS transfers and for pulling data back to a local path.
+// Its volumes are the storages reported by the instance.
+type ZimaOSSource struct {
+	client *ZimaOSClient
+}
+
+// NewZimaOSSource reads through client, which must be logged in
+func NewZimaOSSource(client *ZimaOSClient) *ZimaOSSource {
+	return &ZimaOSSource{client: client}
+}
+
+// remoteFileInfo adapts a listing entry to fs.FileInfo
+type remoteFileInfo struct {
+	meta FileMetadata
+}
+
+func (i remoteFileInfo) Name() string       { return i.meta.Name }
+func (i remoteFileInfo) Size() int64        { return i.meta.Size }
+func (i remoteFileInfo) ModTime() time.Time { return time.Unix(i.meta.Modified, 0) }
+func (i remoteFileInfo) IsDir() bool        { return i.meta.IsDir }
+func (i remoteFileInfo) Sys() interface{}   { return nil }
+
+func (i remoteFileInfo) Mode() fs.FileMode {
+	if i.meta.IsDir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+// ListVolumes returns one volume per storage with its top-level folders
+func (s *ZimaOSSource) ListVolumes() ([]models.VolumeInfo, error) {
+	storages, err := s.client.GetStorageList()
+	if err != nil {
+		return nil, err
+	}
+
+	var volumes []models.VolumeInfo
+	for _, storage := range storages {
+		entries, err := s.client.ListFolder(storage.Path)
+		if err != nil {
+			common.Errorf("Failed to list storage %s: %v", storage.Path, err)
+			continue
+		}
+
+		folders := make([]models.FolderInfo, 0)
+		for _, entry := range entries {
+			if !entry.IsDir {
+				continue
+			}
+			folders = append(folders, models.FolderInfo{
+				Path:         filepath.Join(storage.Path, entry.Name),
+				Name:         entry.Name,
+				ModifiedTime: time.Unix(entry.Modified, 0),
+			})
+		}
+		volumes = append(volumes, models.VolumeInfo{Name: storage.Name, Path: storage.Path, Folders: folders})
+	}
+	return volumes, nil
+}
+
+// Resolve accepts existing folders inside one of the instance's storages
+func (s *ZimaOSSource) Resolve(path string) (string, error) {
+	err := s.client.CheckFolder(path)
+	switch {
+	case err == nil:
+		return filepath.Clean(path), nil
+	case errors.Is(err, errFolderNotFound):
+		return "", &PathViolation{Path: path, Reason: PathNotFound, Detail: "path does not exist"}
+	case errors.Is(err, errOutsideStorages):
+		return "", &PathViolation{Path: path, Reason: PathOutsideVolumes, Detail: "only folders inside the ZimaOS storages can be used"}
+	case errors.Is(err, common.ErrInvalidRequest):
+		return "", &PathViolation{Path: path, Reason: PathInvalid, Detail: err.Error()}
+	default:
+		return "", err
+	}
+}
+
+// Walk reports root as a directory without listing its parent, then walks it
+func (s *ZimaOSSource) Walk(root string, fn WalkFunc) error {
+	rootInfo := remoteFileInfo{meta: FileMetadata{Name: filepath.Base(root), IsDir: true, Path: root}}
+	if err := fn(root, rootInfo); err != nil {
+		if errors.Is(err, fs.SkipDir) {
+			return nil
+		}
+		return err
+	}
+
+	return s.client.WalkFolder(root, func(entry FileMetadata, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to access path %s: %w", entry.Path, err)
+		}
+		return fn(entry.Path, remoteFileInfo{meta: entry})
+	})
+}
+
+func (s *ZimaOSSource) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	return s.client.DownloadFile(ctx, path)
+}
+
+func (s *ZimaOSSource) Stat(path string) (fs.FileInfo, error) {
+	meta, err := s.client.GetFileInfo(path)
+	if err != nil {
+		if errors.Is(err, common.ErrFileNotFound) {
+			return nil, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+		}
+		return nil, err
+	}
+	return remoteFileInfo{meta: *meta}, nil
+}
+
+func (s *ZimaOSSource) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,73 @@
+package service_test
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/atopos31/stoz/service"
+	"github.com/atopos31/stoz/service/zimaosfake"
+)
+
+func TestZimaOSSourceWalkFailsOnListingErrors(t *testing.T) {
+	fake, err := zimaosfake.New("admin", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+	if err := os.MkdirAll(fake.LocalPath("/media/ZimaOS-HD/src/sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	source := service.NewZimaOSSource(fake.Client())
+
+	fake.InjectFault(zimaosfake.EndpointList, zimaosfake.Fault{Status: 500})
+	err = source.Walk("/media/ZimaOS-HD/src", func(path string, info fs.FileInfo) error { return nil })
+	if err == nil {
+		t.Fatal("walk succeeded although the folder could not be listed")
+	}
+}
+
+func TestZimaOSSourceOpenStopsWithContext(t *testing.T) {
+	fake, err := zimaosfake.New("admin", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+	if err := os.MkdirAll(fake.LocalPath("/media/ZimaOS-HD/src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fake.LocalPath("/media/ZimaOS-HD/src/f.bin"), make([]byte, 1<<20), 0644); err != nil {
+		t.Fatal(err)
+	}
+	source := service.NewZimaOSSource(fake.Client())
+
+	fake.InjectFault(zimaosfake.EndpointDownload, zimaosfake.Fault{Delay: time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		file, err := source.Open(ctx, "/media/ZimaOS-HD/src/f.bin")
+		if err == nil {
+			_, err = io.Copy(io.Discard, file)
+			file.Close()
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("read succeeded although the context was done")
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("read took %v after cancellation", elapsed)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("read did not stop when the context was done")
+	}
+}
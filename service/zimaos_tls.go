@@ -0,0 +1,106 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/atopos31/stoz/common"
+)
+
+// TLSOptions controls how HTTPS certificates of a ZimaOS host are verified.
+// Without options the system roots are used.
+type TLSOptions struct {
+	CACert             string `json:"tls_ca_cert"`              // PEM bundle trusted in addition to the system roots
+	Fingerprint        string `json:"tls_fingerprint"`          // SHA-256 of the server certificate (hex, colons allowed)
+	InsecureSkipVerify bool   `json:"tls_insecure_skip_verify"` // Accept any certificate
+}
+
+// IsSet reports whether any option differs from the defaults
+func (o TLSOptions) IsSet() bool {
+	return o.CACert != "" || o.Fingerprint != "" || o.InsecureSkipVerify
+}
+
+// NormalizeFingerprint converts "AB:CD:..." or "abcd..." to lowercase hex
+func NormalizeFingerprint(fingerprint string) (string, error) {
+	fingerprint = strings.ToLower(strings.NewReplacer(":", "", " ", "").Replace(strings.TrimSpace(fingerprint)))
+	if fingerprint == "" {
+		return "", nil
+	}
+	if raw, err := hex.DecodeString(fingerprint); err != nil || len(raw) != sha256.Size {
+		return "", fmt.Errorf("%w: tls_fingerprint must be a SHA-256 fingerprint (64 hex digits)", common.ErrInvalidRequest)
+	}
+	return fingerprint, nil
+}
+
+// Config builds the tls.Config for these options. A pinned fingerprint is
+// checked on top of normal verification when a CA is given, and replaces it
+// otherwise, so self-signed certificates can be pinned directly.
+func (o TLSOptions) Config() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if o.CACert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(o.CACert)) {
+			return nil, fmt.Errorf("%w: tls_ca_cert contains no PEM certificates", common.ErrInvalidRequest)
+		}
+		cfg.RootCAs = pool
+	}
+
+	pin, err := NormalizeFingerprint(o.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case o.InsecureSkipVerify:
+		cfg.InsecureSkipVerify = true
+	case pin != "":
+		// Chain verification is skipped only when the pin is the sole check
+		cfg.InsecureSkipVerify = o.CACert == ""
+		cfg.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return errors.New("server presented no certificate")
+			}
+			sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+			if got := hex.EncodeToString(sum[:]); got != pin {
+				return fmt.Errorf("certificate fingerprint %s does not match pinned %s", got, pin)
+			}
+			return nil
+		}
+	}
+	return cfg, nil
+}
+
+// NormalizeHost turns user input like "192.168.1.10:8080/" into a base URL.
+// defaultScheme is used when the input has none.
+func NormalizeHost(host, defaultScheme string) (string, error) {
+	host = strings.TrimSpace(host)
+	if host == "" {
+		return "", fmt.Errorf("%w: host is required", common.ErrInvalidRequest)
+	}
+	if !strings.Contains(host, "://") {
+		host = defaultScheme + "://" + host
+	}
+
+	u, err := url.Parse(host)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("%w: invalid host %q", common.ErrInvalidRequest, host)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("%w: host must use http or https", common.ErrInvalidRequest)
+	}
+	if u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "", fmt.Errorf("%w: host must not contain credentials, a query or a fragment", common.ErrInvalidRequest)
+	}
+	return u.Scheme + "://" + u.Host + strings.TrimRight(u.Path, "/"), nil
+}
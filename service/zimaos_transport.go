@@ -0,0 +1,126 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/atopos31/stoz/config"
+)
+
+// Transports are shared by every client of the same host and TLS settings,
+// so tasks, tests and listings against one target reuse pooled connections
+var (
+	transportsMu sync.Mutex
+	transports   = make(map[string]*http.Transport)
+)
+
+// sharedTransport returns the pooled transport for host with opts applied
+func sharedTransport(host string, opts TLSOptions) (*http.Transport, error) {
+	tlsConfig, err := opts.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	key := transportKey(host, opts)
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+
+	if transport, ok := transports[key]; ok {
+		return transport, nil
+	}
+
+	cfg := config.AppConfig.ZimaOS
+	dialer := &net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: cfg.KeepAlive,
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}
+	transports[key] = transport
+	return transport, nil
+}
+
+func transportKey(host string, opts TLSOptions) string {
+	fingerprint, _ := NormalizeFingerprint(opts.Fingerprint)
+	ca := sha256.Sum256([]byte(opts.CACert))
+	insecure := "0"
+	if opts.InsecureSkipVerify {
+		insecure = "1"
+	}
+	return host + "|" + hex.EncodeToString(ca[:8]) + "|" + fingerprint + "|" + insecure
+}
+
+// stallWatch aborts an upload whose request body has not been read for
+// timeout. The body counts as idle only while it is still being sent, so a
+// slow server response is left to ResponseHeaderTimeout.
+type stallWatch struct {
+	mu           sync.Mutex
+	lastActivity time.Time
+	sending      bool
+}
+
+func (w *stallWatch) touch(sending bool) {
+	w.mu.Lock()
+	w.lastActivity = time.Now()
+	w.sending = sending
+	w.mu.Unlock()
+}
+
+func (w *stallWatch) stalled(timeout time.Duration) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sending && time.Since(w.lastActivity) > timeout
+}
+
+// run calls abort once the body stalls, until done is closed
+func (w *stallWatch) run(timeout time.Duration, done <-chan struct{}, abort func()) {
+	interval := timeout / 4
+	if interval > time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if w.stalled(timeout) {
+				abort()
+				return
+			}
+		}
+	}
+}
+
+// watchedBody reports every read of a request body to a stallWatch
+type watchedBody struct {
+	reader io.ReadCloser
+	watch  *stallWatch
+}
+
+func (b *watchedBody) Read(p []byte) (int, error) {
+	n, err := b.reader.Read(p)
+	b.watch.touch(err == nil)
+	return n, err
+}
+
+func (b *watchedBody) Close() error {
+	b.watch.touch(false)
+	return b.reader.Close()
+}
@@ -0,0 +1,538 @@
+// Package zimaosfake is an in-process ZimaOS server for exercising the
+// migration pipeline offline. It implements the v1/v2/v2_1/v3 endpoints used
+// by service.ZimaOSClient on top of a temporary directory and can inject
+// faults per endpoint.
+package zimaosfake
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atopos31/stoz/service"
+)
+
+// Endpoints, used as keys for faults and request counts
+const (
+	EndpointLogin    = "/v1/users/login"
+	EndpointRefresh  = "/v1/users/refresh"
+	EndpointFolder   = "/v2_1/files/folder"
+	EndpointUpload   = "/v2_1/files/file/uploadV2"
+	EndpointList     = "/v2_1/files/file"
+	EndpointDownload = "/v3/file"
+	EndpointStorages = "/v2/local_storage/storages"
+)
+
+// chunkSize is the unit in which bodies are read and written, so Delay and
+// Truncate act on streaming transfers rather than whole requests
+const chunkSize = 32 * 1024
+
+// How the listing endpoint reads its index parameter
+const (
+	IndexPage    = iota // Zero-based page of size entries
+	IndexOffset         // Offset of the first entry
+	IndexIgnored        // Always the first page
+)
+
+// Fault changes how an endpoint answers
+type Fault struct {
+	Status   int           // Answer with this status without handling the request
+	Delay    time.Duration // Sleep before answering, and per chunk of upload and download bodies
+	Truncate int64         // Uploads store and downloads send only this many bytes (0 = off)
+	Times    int           // Number of requests affected, 0 = until cleared
+}
+
+// Server is a fake ZimaOS instance. Create it with New and Close it when done.
+type Server struct {
+	*httptest.Server
+
+	Root      string // Directory holding the remote file system
+	Username  string
+	Password  string
+	TokenTTL  time.Duration
+	Storages  []service.StorageDevice
+	PageSize  int // Largest listing page returned whatever the client asks for, 0 = no cap
+	ListIndex int // How listings read index: IndexPage (default), IndexOffset or IndexIgnored
+	ListTotal int // Total reported by listings, 0 = the real one
+
+	mu            sync.Mutex
+	tokens        map[string]time.Time // Access token -> expiry
+	refreshTokens map[string]bool
+	faults        map[string]*Fault
+	requests      map[string]int
+}
+
+// New starts a server accepting username/password on a fresh temporary directory
+func New(username, password string) (*Server, error) {
+	root, err := os.MkdirTemp("", "zimaosfake-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create fake root: %w", err)
+	}
+
+	s := &Server{
+		Root:          root,
+		Username:      username,
+		Password:      password,
+		TokenTTL:      time.Hour,
+		Storages:      []service.StorageDevice{{Name: "ZimaOS-HD", Path: "/media/ZimaOS-HD", Type: "HDD"}},
+		tokens:        make(map[string]time.Time),
+		refreshTokens: make(map[string]bool),
+		faults:        make(map[string]*Fault),
+		requests:      make(map[string]int),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(EndpointLogin, s.handleLogin)
+	mux.HandleFunc(EndpointRefresh, s.handleRefresh)
+	mux.HandleFunc(EndpointFolder, s.authenticated(s.handleFolder))
+	mux.HandleFunc(EndpointUpload, s.authenticated(s.handleUpload))
+	mux.HandleFunc(EndpointList, s.authenticated(s.handleList))
+	mux.HandleFunc(EndpointDownload, s.authenticated(s.handleDownload))
+	mux.HandleFunc(EndpointStorages, s.authenticated(s.handleStorages))
+
+	s.Server = httptest.NewServer(s.withFaults(mux))
+	return s, nil
+}
+
+// Close stops the server and removes its files. Open connections are closed
+// first, so handlers still sleeping on a Delay fault do not hold it up.
+func (s *Server) Close() {
+	s.Server.CloseClientConnections()
+	s.Server.Close()
+	os.RemoveAll(s.Root)
+}
+
+// Client returns a real client connected to the server
+func (s *Server) Client() *service.ZimaOSClient {
+	return service.NewZimaOSClient(s.URL, s.Username, s.Password)
+}
+
+// InjectFault makes endpoint misbehave as described by fault
+func (s *Server) InjectFault(endpoint string, fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[endpoint] = &fault
+}
+
+// ClearFaults restores normal behaviour on every endpoint
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = make(map[string]*Fault)
+}
+
+// RevokeTokens invalidates all issued tokens, as a ZimaOS restart would
+func (s *Server) RevokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = make(map[string]time.Time)
+	s.refreshTokens = make(map[string]bool)
+}
+
+// Requests returns how many requests endpoint has received, faulted ones included
+func (s *Server) Requests(endpoint string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[endpoint]
+}
+
+// LocalPath maps a remote path to its location under Root
+func (s *Server) LocalPath(remotePath string) string {
+	return filepath.Join(s.Root, filepath.Clean("/"+remotePath))
+}
+
+// ReadFile returns the content of an uploaded file
+func (s *Server) ReadFile(remotePath string) ([]byte, error) {
+	return os.ReadFile(s.LocalPath(remotePath))
+}
+
+// takeFault counts the request and returns the fault to apply, if any
+func (s *Server) takeFault(endpoint string) *Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests[endpoint]++
+	fault, ok := s.faults[endpoint]
+	if !ok {
+		return nil
+	}
+	if fault.Times > 0 {
+		fault.Times--
+		if fault.Times == 0 {
+			delete(s.faults, endpoint)
+		}
+	}
+	applied := *fault
+	return &applied
+}
+
+type faultKey struct{}
+
+func contextWithFault(r *http.Request, fault *Fault) context.Context {
+	return context.WithValue(r.Context(), faultKey{}, fault)
+}
+
+// faultFrom returns the fault a handler has to apply, or nil
+func faultFrom(r *http.Request) *Fault {
+	fault, _ := r.Context().Value(faultKey{}).(*Fault)
+	return fault
+}
+
+// sleepFault applies the delay of a fault to endpoints without a streamed body
+func sleepFault(r *http.Request) {
+	if fault := faultFrom(r); fault != nil {
+		time.Sleep(fault.Delay)
+	}
+}
+
+func (s *Server) withFaults(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fault := s.takeFault(r.URL.Path)
+		if fault == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if fault.Delay > 0 && fault.Status != 0 {
+			time.Sleep(fault.Delay)
+		}
+		if fault.Status != 0 {
+			io.Copy(io.Discard, r.Body)
+			writeJSON(w, fault.Status, map[string]string{"message": "injected fault"})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(contextWithFault(r, fault)))
+	})
+}
+
+func (s *Server) issueToken(w http.ResponseWriter) {
+	access, refresh := randomToken(), randomToken()
+	expiresAt := time.Now().Add(s.TokenTTL)
+
+	s.mu.Lock()
+	s.tokens[access] = expiresAt
+	s.refreshTokens[refresh] = true
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": 200,
+		"message": "ok",
+		"data": map[string]interface{}{
+			"token": map[string]interface{}{
+				"access_token":  access,
+				"refresh_token": refresh,
+				"expires_at":    expiresAt.Unix(),
+			},
+		},
+	})
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req service.LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "invalid request"})
+		return
+	}
+	if req.Username != s.Username || req.Password != s.Password {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "invalid username or password"})
+		return
+	}
+	s.issueToken(w)
+}
+
+func (s *Server) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	var req service.RefreshRequest
+	json.NewDecoder(r.Body).Decode(&req)
+
+	s.mu.Lock()
+	valid := s.refreshTokens[req.RefreshToken]
+	delete(s.refreshTokens, req.RefreshToken)
+	s.mu.Unlock()
+
+	if !valid {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "invalid refresh token"})
+		return
+	}
+	s.issueToken(w)
+}
+
+// authenticated rejects requests without a live token in the Authorization
+// header or, like older ZimaOS versions, the token query parameter
+func (s *Server) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("Authorization")
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+
+		s.mu.Lock()
+		expiresAt, ok := s.tokens[token]
+		s.mu.Unlock()
+
+		if !ok || time.Now().After(expiresAt) {
+			io.Copy(io.Discard, r.Body)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "unauthorized"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) handleFolder(w http.ResponseWriter, r *http.Request) {
+	sleepFault(r)
+
+	var req service.CreateFolderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "invalid request"})
+		return
+	}
+	if info, err := os.Stat(s.LocalPath(req.Path)); err == nil && info.IsDir() {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "path already exist"})
+		return
+	}
+	if err := os.MkdirAll(s.LocalPath(req.Path), 0755); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"message": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "ok"})
+}
+
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	fault := faultFrom(r)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "expected multipart body"})
+		return
+	}
+
+	var dir, stored string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+			return
+		}
+
+		switch part.FormName() {
+		case "path":
+			value, _ := io.ReadAll(part)
+			dir = string(value)
+		case "file":
+			if dir == "" {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"message": "path must precede file"})
+				return
+			}
+			stored = filepath.Join(dir, part.FileName())
+			if err := s.storeUpload(s.LocalPath(stored), part, fault); err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"message": err.Error()})
+				return
+			}
+		case "modTime":
+			// "<name>:<unix seconds>"
+			value, _ := io.ReadAll(part)
+			if i := strings.LastIndex(string(value), ":"); i >= 0 && stored != "" {
+				if sec, err := strconv.ParseInt(string(value[i+1:]), 10, 64); err == nil {
+					os.Chtimes(s.LocalPath(stored), time.Unix(sec, 0), time.Unix(sec, 0))
+				}
+			}
+		}
+	}
+
+	if stored == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "no file in upload"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "message": "ok"})
+}
+
+// storeUpload writes an uploaded file, applying per-chunk delays and
+// truncation. A truncated upload still succeeds, like a silently short write.
+func (s *Server) storeUpload(path string, body io.Reader, fault *Fault) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var written int64
+	buf := make([]byte, chunkSize)
+	for {
+		n, readErr := body.Read(buf)
+		if n > 0 {
+			chunk := buf[:n]
+			if fault != nil && fault.Truncate > 0 {
+				if remaining := fault.Truncate - written; remaining < int64(n) {
+					chunk = chunk[:max(remaining, 0)]
+				}
+			}
+			if _, err := file.Write(chunk); err != nil {
+				return err
+			}
+			written += int64(len(chunk))
+			if fault != nil && fault.Delay > 0 {
+				time.Sleep(fault.Delay)
+			}
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	sleepFault(r)
+
+	dir := r.URL.Query().Get("path")
+	entries, err := os.ReadDir(s.LocalPath(dir))
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "path not found"})
+		return
+	}
+
+	index, _ := strconv.Atoi(r.URL.Query().Get("index"))
+	size, err := strconv.Atoi(r.URL.Query().Get("size"))
+	if err != nil || size <= 0 {
+		size = 100
+	}
+	s.mu.Lock()
+	if s.PageSize > 0 && size > s.PageSize {
+		size = s.PageSize
+	}
+	start := index * size
+	switch s.ListIndex {
+	case IndexOffset:
+		start = index
+	case IndexIgnored:
+		start = 0
+	}
+	total := len(entries)
+	if s.ListTotal > 0 {
+		total = s.ListTotal
+	}
+	s.mu.Unlock()
+
+	content := []service.FileMetadata{}
+	for i, entry := range entries {
+		if i < start || i >= start+size {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		content = append(content, service.FileMetadata{
+			Name:     entry.Name(),
+			Size:     info.Size(),
+			Modified: info.ModTime().Unix(),
+			IsDir:    entry.IsDir(),
+			Path:     filepath.Join(dir, entry.Name()),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, service.FileListResponse{
+		Content: content,
+		Index:   index,
+		Size:    size,
+		Total:   total,
+	})
+}
+
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	fault := faultFrom(r)
+	if fault == nil || (fault.Delay == 0 && fault.Truncate == 0) {
+		http.ServeFile(w, r, s.LocalPath(r.URL.Query().Get("files")))
+		return
+	}
+
+	data, err := os.ReadFile(s.LocalPath(r.URL.Query().Get("files")))
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "file not found"})
+		return
+	}
+	if start, end, ok := parseRange(r.Header.Get("Range"), int64(len(data))); ok {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data = data[start : end+1]
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusOK)
+	}
+
+	// A truncated body is shorter than Content-Length, so the client sees
+	// the connection drop mid-transfer
+	if fault.Truncate > 0 && fault.Truncate < int64(len(data)) {
+		data = data[:fault.Truncate]
+	}
+	for len(data) > 0 {
+		n := min(len(data), chunkSize)
+		if _, err := w.Write(data[:n]); err != nil {
+			return
+		}
+		data = data[n:]
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		time.Sleep(fault.Delay)
+	}
+}
+
+func (s *Server) handleStorages(w http.ResponseWriter, r *http.Request) {
+	sleepFault(r)
+	writeJSON(w, http.StatusOK, s.Storages)
+}
+
+// parseRange understands the single "bytes=start-end" ranges the client sends
+func parseRange(header string, size int64) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return 0, 0, false
+	}
+	startStr, endStr, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if endStr != "" {
+		if e, err := strconv.ParseInt(endStr, 10, 64); err == nil && e < end {
+			end = e
+		}
+	}
+	return start, end, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func randomToken() string {
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	return hex.EncodeToString(raw)
+}
@@ -1,4 +1,4 @@
-import type { ScanResult, TaskStatus, MigrationTask, MigrationOptions, ZimaOSDevice, StorageListResponse } from '../types';
+import type { ScanResult, DedupeJob, IndexerStatus, TaskStatus, MigrationTask, MigrationOptions, ZimaOSDevice, StorageListResponse, BandwidthPolicy, Target, TargetSpec, AuthStatus, LoginResponse, User, UserSpec, AuditEntry, AuditFilter, DestinationSpec, ZimaOSConnection, BrowseResponse } from '../types';
 
 const API_BASE = '/api/v1';
 
@@ -8,6 +8,16 @@ interface ApiResponse<T> {
   data?: T;
 }
 
+function auditQuery(filter: AuditFilter): string {
+  const params = new URLSearchParams();
+  Object.entries(filter).forEach(([key, value]) => {
+    if (value !== undefined && value !== '') {
+      params.set(key, String(value));
+    }
+  });
+  return params.toString();
+}
+
 async function request<T>(url: string, options?: RequestInit): Promise<T> {
   const response = await fetch(`${API_BASE}${url}`, {
     ...options,
@@ -17,6 +27,14 @@ async function request<T>(url: string, options?: RequestInit): Promise<T> {
     },
   });
 
+  // Session expired or missing: send the user to the login page
+  if (response.status === 401 && !url.startsWith('/auth/')) {
+    if (window.location.pathname !== '/login') {
+      window.location.assign('/login');
+    }
+    throw new Error('Authentication required');
+  }
+
   const data: ApiResponse<T> = await response.json();
 
   if (data.code !== 0) {
@@ -31,6 +49,34 @@ export const api = {
     return request<{ status: string }>('/health');
   },
 
+  authStatus: async () => {
+    return request<AuthStatus>('/auth/status');
+  },
+
+  setup: async (username: string, password: string) => {
+    return request<LoginResponse>('/auth/setup', {
+      method: 'POST',
+      body: JSON.stringify({ username, password }),
+    });
+  },
+
+  login: async (username: string, password: string) => {
+    return request<LoginResponse>('/auth/login', {
+      method: 'POST',
+      body: JSON.stringify({ username, password }),
+    });
+  },
+
+  logout: async () => {
+    return request('/auth/logout', {
+      method: 'POST',
+    });
+  },
+
+  me: async () => {
+    return request<User>('/auth/me');
+  },
+
   scan: async () => {
     return request<ScanResult>('/scan');
   },
@@ -46,8 +92,30 @@ export const api = {
     });
   },
 
+  startDedupe: async (paths: string[], includeRecycle: boolean = false, minSize: number = 0) => {
+    return request<DedupeJob>('/scan/duplicates', {
+      method: 'POST',
+      body: JSON.stringify({ paths, include_recycle: includeRecycle, min_size: minSize }),
+    });
+  },
+
+  getDedupe: async (jobId: string) => {
+    return request<DedupeJob>(`/scan/duplicates/${jobId}`);
+  },
+
+  getIndexStatus: async () => {
+    return request<IndexerStatus>('/scan/index');
+  },
+
+  refreshIndex: async (paths: string[] = []) => {
+    return request<IndexerStatus>('/scan/index/refresh', {
+      method: 'POST',
+      body: JSON.stringify({ paths }),
+    });
+  },
+
   testConnection: async (host: string, username: string, password: string) => {
-    return request<{ token: string }>('/zimaos/test', {
+    return request<{ host: string }>('/zimaos/test', {
       method: 'POST',
       body: JSON.stringify({ host, username, password }),
     });
@@ -60,6 +128,21 @@ export const api = {
     });
   },
 
+  // Lists folders below path; an empty path lists the storages
+  browseZimaOS: async (connection: ZimaOSConnection, path: string) => {
+    return request<BrowseResponse>('/zimaos/browse', {
+      method: 'POST',
+      body: JSON.stringify({ ...connection, path }),
+    });
+  },
+
+  createZimaOSFolder: async (connection: ZimaOSConnection, path: string) => {
+    return request<{ path: string }>('/zimaos/folders', {
+      method: 'POST',
+      body: JSON.stringify({ ...connection, path }),
+    });
+  },
+
   createMigration: async (
     sourceFolders: string[],
     zimaosHost: string,
@@ -99,4 +182,127 @@ export const api = {
       method: 'POST',
     });
   },
+
+  createMigrationFromTarget: async (
+    sourceFolders: string[],
+    targetId: string,
+    basePath: string,
+    options: MigrationOptions,
+    sourceTargetId?: string // Copy from another ZimaOS target
+  ) => {
+    return request<{ task_id: string }>('/migration', {
+      method: 'POST',
+      body: JSON.stringify({
+        source_folders: sourceFolders,
+        target_id: targetId,
+        base_path: basePath,
+        options,
+        source_target_id: sourceTargetId,
+      }),
+    });
+  },
+
+  testDestination: async (spec: DestinationSpec) => {
+    return request<{ type: string; url: string }>('/destinations/test', {
+      method: 'POST',
+      body: JSON.stringify(spec),
+    });
+  },
+
+  createMigrationToDestination: async (
+    sourceFolders: string[],
+    destination: DestinationSpec,
+    basePath: string,
+    options: MigrationOptions,
+    sourceTargetId?: string // Pull from a ZimaOS target, e.g. back to a local path
+  ) => {
+    return request<{ task_id: string }>('/migration', {
+      method: 'POST',
+      body: JSON.stringify({
+        source_folders: sourceFolders,
+        destination,
+        base_path: basePath,
+        options,
+        source_target_id: sourceTargetId,
+      }),
+    });
+  },
+
+  listTargets: async () => {
+    return request<{ targets: Target[]; total: number }>('/targets');
+  },
+
+  createTarget: async (spec: TargetSpec) => {
+    return request<Target>('/targets', {
+      method: 'POST',
+      body: JSON.stringify(spec),
+    });
+  },
+
+  updateTarget: async (targetId: string, spec: TargetSpec) => {
+    return request<Target>(`/targets/${targetId}`, {
+      method: 'PUT',
+      body: JSON.stringify(spec),
+    });
+  },
+
+  deleteTarget: async (targetId: string) => {
+    return request(`/targets/${targetId}`, {
+      method: 'DELETE',
+    });
+  },
+
+  testTarget: async (targetId: string) => {
+    return request<Target>(`/targets/${targetId}/test`, {
+      method: 'POST',
+    });
+  },
+
+  getTargetStorages: async (targetId: string) => {
+    return request<StorageListResponse>(`/targets/${targetId}/storages`);
+  },
+
+  listUsers: async () => {
+    return request<{ users: User[]; total: number }>('/users');
+  },
+
+  createUser: async (spec: UserSpec) => {
+    return request<User>('/users', {
+      method: 'POST',
+      body: JSON.stringify(spec),
+    });
+  },
+
+  updateUser: async (userId: number, spec: UserSpec) => {
+    return request<User>(`/users/${userId}`, {
+      method: 'PUT',
+      body: JSON.stringify(spec),
+    });
+  },
+
+  deleteUser: async (userId: number) => {
+    return request(`/users/${userId}`, {
+      method: 'DELETE',
+    });
+  },
+
+  listAudit: async (filter: AuditFilter = {}) => {
+    return request<{ entries: AuditEntry[]; total: number; limit: number; offset: number }>(
+      `/audit?${auditQuery(filter)}`
+    );
+  },
+
+  auditExportUrl: (filter: AuditFilter = {}) => {
+    return `${API_BASE}/audit?${auditQuery({ ...filter, limit: undefined, offset: undefined })}&format=csv`;
+  },
+
+  updateLimits: async (taskId: string, policy: BandwidthPolicy) => {
+    return request<{ bandwidth: BandwidthPolicy; current_limit: number; applied: boolean }>(
+      `/migration/${taskId}/limits`,
+      {
+        method: 'PATCH',
+        body: JSON.stringify(policy),
+      }
+    );
+  },
 };
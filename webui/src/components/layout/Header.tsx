@@ -1,6 +1,15 @@
 import { Link } from 'react-router-dom'
 import { Button } from '@/components/ui/button'
-import { History } from 'lucide-react'
+import { History, LogOut } from 'lucide-react'
+import { api } from '@/api/client'
+
+async function handleLogout() {
+  try {
+    await api.logout()
+  } finally {
+    window.location.assign('/login')
+  }
+}
 
 export default function Header() {
   return (
@@ -12,12 +21,18 @@ export default function Header() {
               STOZ - Synology To ZimaOS Migration
             </h1>
           </Link>
-          <Button variant="outline" asChild>
-            <Link to="/history">
-              <History className="mr-2 h-4 w-4" />
-              History
-            </Link>
-          </Button>
+          <div className="flex items-center gap-2">
+            <Button variant="outline" asChild>
+              <Link to="/history">
+                <History className="mr-2 h-4 w-4" />
+                History
+              </Link>
+            </Button>
+            <Button variant="ghost" onClick={handleLogout}>
+              <LogOut className="mr-2 h-4 w-4" />
+              Sign out
+            </Button>
+          </div>
         </div>
       </div>
     </header>
@@ -225,7 +225,7 @@ export default function MigrationPage() {
               </h4>
               <div className="bg-blue-50 p-3 rounded">
                 <p className="text-sm font-mono text-gray-700">
-                  {status.zimaos_host}
+                  {status.dest_url}
                   <span className="text-blue-600 font-semibold">{status.base_path}</span>
                 </p>
               </div>
@@ -6,8 +6,13 @@ import MigrationPage from './pages/MigrationPage'
 import HistoryPage from './pages/HistoryPage'
 import TaskDetailPage from './pages/TaskDetailPage'
 import NotFoundPage from './pages/NotFoundPage'
+import LoginPage from './pages/LoginPage'
 
 export const router = createBrowserRouter([
+  {
+    path: '/login',
+    element: <LoginPage />,
+  },
   {
     path: '/',
     element: <Layout />,
@@ -4,6 +4,7 @@ export interface FolderInfo {
   size: number;
   file_count: number;
   modified_time: string;
+  indexed_at?: string; // Unset until the folder indexer has walked it
   children?: FolderInfo[];
 }
 
@@ -18,22 +19,76 @@ export interface ScanResult {
   scanned_at: string;
 }
 
+export interface DuplicateGroup {
+  size: number;    // Of each file
+  hash: string;    // SHA-256 of the content
+  paths: string[]; // The first is kept, the others are duplicates
+}
+
+export interface DedupeReport {
+  groups: DuplicateGroup[];
+  scanned_files: number;
+  scanned_size: number;
+  duplicate_files: number; // Files beyond the first of each group
+  wasted_size: number;     // Bytes those files take
+}
+
+export interface DedupeJob {
+  job_id: string;
+  status: 'running' | 'completed' | 'failed';
+  paths: string[];
+  include_recycle: boolean;
+  min_size: number;
+  hashed_size: number; // Bytes read so far
+  report?: DedupeReport;
+  error?: string;
+  started_at: string;
+  completed_at?: string;
+}
+
+export interface IndexerStatus {
+  running: boolean;
+  current_path?: string;
+  current_files: number; // Counted so far in current_path
+  current_size: number;
+  queued: number;
+  cycle_done: number;
+  cycle_total: number;
+  indexed: number;
+  interval_seconds: number;
+  last_cycle_at?: string;
+  next_cycle_at?: string;
+}
+
 export interface MigrationTask {
   id: number;
   task_id: string;
   status: string;
   error: string;
   source_folders: string;
+  dest_url: string; // ZimaOS host, WebDAV URL, SFTP host or S3 endpoint
+  dest_username: string;
+  /** @deprecated Same as dest_url */
   zimaos_host: string;
+  /** @deprecated Same as dest_username */
   zimaos_username: string;
+  target_id?: string;
+  destination_type: DestinationType;
+  destination_options?: string; // JSON-encoded DestinationOptions
+  source_target_id?: string; // Read from this ZimaOS target instead of the Synology
   base_path: string;
   total_files: number;
   processed_files: number;
   failed_files: number;
   total_size: number;
   transferred_size: number;
+  wire_size: number; // Bytes sent, after compression
+  deduped_files: number; // Duplicates copied on the destination or skipped
+  deduped_size: number;
   progress: number;
   options: string;
+  priority: number;
+  queue_order: number;
   created_at: string;
   started_at?: string;
   completed_at?: string;
@@ -55,6 +110,9 @@ export interface TaskStatus {
   total_size: number;
   progress: number;
   failed_files: number;
+  wire_size: number; // Bytes sent to the destination, after compression and including retries
+  deduped_files: number; // Duplicates copied on the destination or skipped
+  deduped_size: number;  // Bytes of those files, not uploaded
   // Verification progress fields
   verifying_files: number;
   verify_failed_files: number;
@@ -63,8 +121,42 @@ export interface TaskStatus {
 
   // Path information fields
   source_folders: string[];  // Source folder paths
-  zimaos_host: string;       // ZimaOS host address
+  dest_url: string;          // Destination host or URL
+  /** @deprecated Same as dest_url */
+  zimaos_host: string;
   base_path: string;         // Target base path
+
+  // Queue information fields
+  priority: number;
+  queue_position: number;    // 1-based position among pending tasks, 0 when not queued
+}
+
+export interface BandwidthRule {
+  start: string; // HH:MM
+  end: string;   // HH:MM
+  limit: number; // Bytes per second, 0 = unlimited
+}
+
+export interface BandwidthPolicy {
+  limit: number; // Bytes per second, 0 = unlimited
+  schedule?: BandwidthRule[];
+}
+
+export interface BatchPolicy {
+  max_file_size?: number;   // Bytes, default 1 MiB
+  max_batch_size?: number;  // Bytes per archive, default 64 MiB
+  max_batch_files?: number; // Default 1000
+}
+
+export interface CompressionPolicy {
+  algorithm: 'gzip';
+  min_size?: number; // Bytes, default 4 KiB
+  gzip_at_rest: boolean; // Must be true: compressed files are stored as <name>.gz
+}
+
+export interface DedupePolicy {
+  mode: 'copy' | 'skip'; // copy: local and S3 destinations only
+  min_size?: number;     // Bytes, default 4 KiB
 }
 
 export interface MigrationOptions {
@@ -72,6 +164,10 @@ export interface MigrationOptions {
   skip_errors: boolean;
   preserve_times: boolean;
   include_recycle: boolean;
+  bandwidth?: BandwidthPolicy;
+  batch?: BatchPolicy; // Upload small files in tar archives
+  compression?: CompressionPolicy; // S3 destinations only; compressed files are stored as <name>.gz
+  dedupe?: DedupePolicy; // Upload files with identical content once
 }
 
 export interface ZimaOSDevice {
@@ -103,3 +199,131 @@ export interface StorageListResponse {
   storages: StorageDevice[];
   count: number;
 }
+
+// Saved target or inline credentials of a ZimaOS instance
+export interface ZimaOSConnection {
+  target_id?: string;
+  host?: string;
+  username?: string;
+  password?: string;
+}
+
+export interface RemoteFolder {
+  name: string;
+  path: string;
+  modified: number;
+  is_dir: boolean;
+}
+
+export interface BrowseResponse {
+  path: string;
+  folders: RemoteFolder[];
+  count: number;
+}
+
+export interface Target {
+  id: number;
+  target_id: string;
+  name: string;
+  zimaos_host: string;
+  zimaos_username: string;
+  base_path: string;
+  tls_ca_cert: string;
+  tls_fingerprint: string;
+  tls_insecure_skip_verify: boolean;
+  storages: string; // JSON-encoded StorageDevice[]
+  storages_updated_at?: string;
+  last_tested_at?: string;
+  last_test_error: string;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface TargetSpec {
+  name: string;
+  zimaos_host: string;
+  zimaos_username: string;
+  zimaos_password?: string;
+  base_path: string;
+  tls_ca_cert?: string;
+  tls_fingerprint?: string; // SHA-256, hex with or without colons
+  tls_insecure_skip_verify?: boolean;
+}
+
+export type DestinationType = 'zimaos' | 'local' | 'webdav' | 'sftp' | 's3';
+
+export interface DestinationOptions {
+  bucket?: string;          // S3
+  region?: string;          // S3, default us-east-1
+  virtual_hosted?: boolean; // S3: bucket.endpoint instead of endpoint/bucket
+  host_key?: string;        // SFTP: SHA256:... fingerprint
+  ignore_host_key?: boolean;
+  tls_ca_cert?: string;
+  tls_fingerprint?: string;
+  tls_insecure_skip_verify?: boolean;
+}
+
+export interface DestinationSpec extends DestinationOptions {
+  type: DestinationType;
+  url: string;       // WebDAV base URL, SFTP host[:port] or S3 endpoint; unused for local
+  username: string;  // S3: access key
+  password: string;  // S3: secret key; SFTP: password or PEM private key
+}
+
+export type Role = 'admin' | 'operator' | 'viewer';
+
+export interface User {
+  id: number;
+  username: string;
+  role: Role;
+  allowed_paths: string; // JSON-encoded string[], empty = all paths
+  last_login_at?: string;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface AuthStatus {
+  setup_required: boolean;
+  authenticated: boolean;
+  username: string;
+}
+
+export interface LoginResponse {
+  token: string;
+  expires_at: string;
+  user: User;
+}
+
+export interface AuditEntry {
+  id: number;
+  created_at: string;
+  actor: string;
+  actor_id?: number;
+  client_ip?: string;
+  action: string;
+  resource_type?: string;
+  resource_id?: string;
+  target_id?: string;
+  target_host?: string;
+  outcome: 'success' | 'failure';
+  detail?: string;
+}
+
+export interface AuditFilter {
+  actor?: string;
+  action?: string;
+  resource_id?: string;
+  target?: string;
+  outcome?: 'success' | 'failure';
+  since?: string;
+  until?: string;
+  limit?: number;
+  offset?: number;
+}
+
+export interface UserSpec {
+  username: string;
+  password?: string;
+  role: Role;
+  allowed_paths: string[];
+}
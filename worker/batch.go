@@ -0,0 +1,403 @@
+package worker
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/config"
+	"github.com/atopos31/stoz/models"
+	"github.com/atopos31/stoz/service"
+)
+
+// batchArchiveName names the n-th batch archive of a destination directory
+func batchArchiveName(dir string, n int) string {
+	return filepath.Join(dir, fmt.Sprintf("stoz-batch-%04d.tar", n))
+}
+
+// planBatches groups the small files of each destination directory into
+// archives and sets their Archive. fileList is reordered by directory, small
+// files first, so the files of a batch are adjacent; the order only depends
+// on the file list, which keeps checkpoints valid on resume. Duplicates from
+// planDedupe stay after the other files; neither they nor their originals,
+// which must stay copyable, are batched.
+func planBatches(fileList []FileInfo, policy service.BatchPolicy) int {
+	type sortKey struct {
+		duplicate bool
+		dir       string
+		small     bool
+		file      FileInfo
+	}
+	originals := make(map[string]bool)
+	for _, file := range fileList {
+		if file.DuplicateOf != "" {
+			originals[file.DuplicateOf] = true
+		}
+	}
+	keys := make([]sortKey, len(fileList))
+	for i, file := range fileList {
+		duplicate := file.DuplicateOf != ""
+		small := !duplicate && !originals[file.RemotePath] && file.Size <= policy.MaxFileSize
+		keys[i] = sortKey{duplicate, filepath.Dir(file.RemotePath), small, file}
+	}
+	sort.SliceStable(keys, func(a, b int) bool {
+		if keys[a].duplicate != keys[b].duplicate {
+			return !keys[a].duplicate
+		}
+		if keys[a].dir != keys[b].dir {
+			return keys[a].dir < keys[b].dir
+		}
+		return keys[a].small && !keys[b].small
+	})
+
+	batches := 0
+	for i := 0; i < len(keys); {
+		dir := keys[i].dir
+		n := 0
+		for i < len(keys) && keys[i].dir == dir && keys[i].small {
+			start := i
+			var size int64
+			for i < len(keys) && keys[i].dir == dir && keys[i].small && i-start < policy.MaxBatchFiles &&
+				(i == start || size+keys[i].file.Size <= policy.MaxBatchSize) {
+				size += keys[i].file.Size
+				i++
+			}
+			if i-start > 1 {
+				n++
+				batches++
+				archive := batchArchiveName(dir, n)
+				for k := start; k < i; k++ {
+					keys[k].file.Archive = archive
+				}
+			}
+		}
+		for i < len(keys) && keys[i].dir == dir {
+			i++
+		}
+	}
+
+	for i := range keys {
+		fileList[i] = keys[i].file
+	}
+	return batches
+}
+
+// uploadBatch packs batch into a spooled archive, uploads it and unpacks or
+// indexes it. Files that could not be packed, or all of them when the
+// archive cannot be built, are uploaded on their own. Per-file failures are
+// counted and logged; an error means the task has to stop.
+func (p *WorkerPool) uploadBatch(ctx context.Context, task *models.MigrationTask, status *service.TaskStatus, client service.Destination, spool *service.SpoolSource, batch []FileInfo, options service.MigrationOptions) error {
+	archivePath := batch[0].Archive
+	remoteDir := filepath.Dir(archivePath)
+	if err := client.CreateFolder(remoteDir); err != nil {
+		common.Errorf("Failed to create remote folder %s: %v", remoteDir, err)
+		return p.failFiles(task.TaskID, status, batch, fmt.Errorf("failed to create folder: %w", err), options)
+	}
+
+	paths := make([]string, len(batch))
+	for i, file := range batch {
+		paths[i] = file.LocalPath
+	}
+
+	spoolFile, err := os.CreateTemp("", "stoz-batch-*.tar")
+	if err != nil {
+		common.Warnf("Failed to create batch archive, uploading %d files one by one: %v", len(batch), err)
+		return p.uploadEach(ctx, task, status, client, batch, options)
+	}
+	defer os.Remove(spoolFile.Name())
+
+	index, skipped, err := service.WriteBatchArchive(ctx, spool, archivePath, paths, options.Batch.WithDefaults().MaxFileSize, spoolFile)
+	if closeErr := spoolFile.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write archive: %w", closeErr)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		common.Warnf("Failed to build batch archive %s, uploading %d files one by one: %v", archivePath, len(batch), err)
+		return p.uploadEach(ctx, task, status, client, batch, options)
+	}
+
+	left := make(map[string]bool, len(skipped))
+	for _, path := range skipped {
+		left[path] = true
+	}
+	var packed, unpacked []FileInfo
+	var packedSize int64
+	for _, file := range batch {
+		if left[file.LocalPath] {
+			unpacked = append(unpacked, file)
+		} else {
+			packed = append(packed, file)
+			packedSize += file.Size
+		}
+	}
+
+	if len(packed) > 0 {
+		spool.Add(spoolFile.Name())
+		defer spool.Remove(spoolFile.Name())
+
+		baseTransferred := status.TransferredSize
+		var sent int64
+		lastUpdate := time.Now()
+		status.CurrentFile = archivePath
+		status.CurrentFileSize = index.Size
+
+		onReset := func() {
+			sent = 0
+			status.CurrentFileTransferred = 0
+			status.CurrentFileProgress = 0
+			status.TransferredSize = baseTransferred
+			status.UpdatedAt = time.Now()
+			p.migrationSvc.UpdateTaskStatus(task.TaskID, status)
+		}
+		onProgress := func(delta int64) {
+			sent += delta
+			status.CurrentFileTransferred = sent
+			if index.Size > 0 {
+				status.CurrentFileProgress = float64(sent) / float64(index.Size) * 100
+			}
+			// Headers and padding are not part of the task's size
+			status.TransferredSize = baseTransferred + min(sent, packedSize)
+			if status.TotalSize > 0 {
+				status.Progress = float64(status.TransferredSize) / float64(status.TotalSize) * 100
+			}
+			if now := time.Now(); now.Sub(lastUpdate) >= 200*time.Millisecond {
+				status.UpdatedAt = now
+				p.migrationSvc.UpdateTaskStatus(task.TaskID, status)
+				lastUpdate = now
+			}
+		}
+
+		err := p.uploadFileWithRetry(ctx, client, spoolFile.Name(), archivePath, config.AppConfig.Worker.MaxRetries, onProgress, onReset)
+		if err == nil {
+			err = p.finishBatch(ctx, client, spool, index)
+		}
+		if err != nil {
+			status.TransferredSize = baseTransferred
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			common.Errorf("Failed to upload batch %s: %v", archivePath, err)
+			if err := p.failFiles(task.TaskID, status, packed, fmt.Errorf("batch %s: %w", archivePath, err), options); err != nil {
+				return err
+			}
+		} else {
+			status.TransferredSize = baseTransferred + packedSize
+			common.Infof("Uploaded %d files as batch %s", len(packed), archivePath)
+		}
+	}
+
+	return p.uploadEach(ctx, task, status, client, unpacked, options)
+}
+
+// finishBatch unpacks an uploaded archive. When the destination cannot do
+// that, or unpacking fails, the archive is kept and its index stored next to it.
+func (p *WorkerPool) finishBatch(ctx context.Context, client service.Destination, spool *service.SpoolSource, index *service.BatchIndex) error {
+	if extractor, ok := client.(service.ArchiveExtractor); ok {
+		err := extractor.ExtractArchive(ctx, index.Archive)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		common.Warnf("Failed to extract %s, keeping the archive: %v", index.Archive, err)
+	}
+
+	if err := p.uploadJSON(ctx, client, spool, index, service.BatchIndexPath(index.Archive)); err != nil {
+		return fmt.Errorf("failed to upload index: %w", err)
+	}
+	return nil
+}
+
+// uploadJSON stores value as a JSON file at remotePath
+func (p *WorkerPool) uploadJSON(ctx context.Context, client service.Destination, spool *service.SpoolSource, value interface{}, remotePath string) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	file, err := os.CreateTemp("", "stoz-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	spool.Add(file.Name())
+	defer spool.Remove(file.Name())
+	return p.uploadFileWithRetry(ctx, client, file.Name(), remotePath, config.AppConfig.Worker.MaxRetries, func(int64) {}, nil)
+}
+
+// uploadEach uploads files of a batch that were not packed, one at a time
+func (p *WorkerPool) uploadEach(ctx context.Context, task *models.MigrationTask, status *service.TaskStatus, client service.Destination, files []FileInfo, options service.MigrationOptions) error {
+	for _, file := range files {
+		baseTransferred := status.TransferredSize
+		status.CurrentFile = file.LocalPath
+		status.CurrentFileSize = file.Size
+		status.UpdatedAt = time.Now()
+		p.migrationSvc.UpdateTaskStatus(task.TaskID, status)
+
+		var sent int64
+		var lastUpdate time.Time
+		onReset := func() {
+			sent = 0
+			status.CurrentFileTransferred = 0
+			status.CurrentFileProgress = 0
+			status.TransferredSize = baseTransferred
+			status.UpdatedAt = time.Now()
+			p.migrationSvc.UpdateTaskStatus(task.TaskID, status)
+		}
+		onProgress := func(delta int64) {
+			sent += delta
+			status.CurrentFileTransferred = sent
+			if file.Size > 0 {
+				status.CurrentFileProgress = float64(sent) / float64(file.Size) * 100
+			} else {
+				status.CurrentFileProgress = 100
+			}
+			status.TransferredSize = baseTransferred + sent
+			if status.TotalSize > 0 {
+				status.Progress = float64(status.TransferredSize) / float64(status.TotalSize) * 100
+			} else {
+				status.Progress = 100
+			}
+			if now := time.Now(); now.Sub(lastUpdate) >= 200*time.Millisecond || sent == file.Size {
+				status.UpdatedAt = now
+				p.migrationSvc.UpdateTaskStatus(task.TaskID, status)
+				lastUpdate = now
+			}
+		}
+		if err := p.uploadFileWithRetry(ctx, client, file.LocalPath, file.RemotePath, config.AppConfig.Worker.MaxRetries, onProgress, onReset); err != nil {
+			status.TransferredSize = baseTransferred
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			common.Errorf("Failed to upload file %s: %v", file.LocalPath, err)
+			if err := p.failFiles(task.TaskID, status, []FileInfo{file}, err, options); err != nil {
+				return err
+			}
+			continue
+		}
+		status.TransferredSize = baseTransferred + file.Size
+	}
+	return nil
+}
+
+// failFiles records files as failed, or returns err when errors stop the task
+func (p *WorkerPool) failFiles(taskID string, status *service.TaskStatus, files []FileInfo, err error, options service.MigrationOptions) error {
+	if !options.SkipErrors {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+	for _, file := range files {
+		status.FailedFiles++
+		p.logError(taskID, file.LocalPath, err)
+	}
+	return nil
+}
+
+// verifyArchivedFile checks a file that was kept in a batch archive: the
+// archive must have the indexed size, the entry the size and time of the
+// source file, and the entry's first and last chunk in the uploaded archive
+// the same content as the source file
+func (p *WorkerPool) verifyArchivedFile(ctx context.Context, source service.Source, file FileInfo, client service.Destination, indexes map[string]*service.BatchIndex) error {
+	index, ok := indexes[file.Archive]
+	if !ok {
+		loaded, err := loadBatchIndex(client, file.Archive)
+		if err != nil {
+			return err
+		}
+		index = loaded
+		indexes[file.Archive] = index
+	}
+
+	entry := index.Entry(filepath.Base(file.RemotePath))
+	if entry == nil {
+		return fmt.Errorf("not found in archive")
+	}
+
+	localStat, err := source.Stat(file.LocalPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat local file: %w", err)
+	}
+	if localStat.Size() != entry.Size {
+		return fmt.Errorf("size mismatch: local=%d, archived=%d", localStat.Size(), entry.Size)
+	}
+	if timeDiff := localStat.ModTime().Unix() - entry.Modified; timeDiff < -1 || timeDiff > 1 {
+		return fmt.Errorf("modified time mismatch: local=%d, archived=%d", localStat.ModTime().Unix(), entry.Modified)
+	}
+
+	chunkSize := min(config.AppConfig.Worker.VerifyChunkSize, entry.Size)
+	offsets := []int64{0}
+	if entry.Size > chunkSize {
+		offsets = append(offsets, entry.Size-chunkSize)
+	}
+	for _, offset := range offsets {
+		localHash, err := p.calculateRangeMD5(ctx, source, file.LocalPath, offset, chunkSize)
+		if err != nil {
+			return fmt.Errorf("failed to calculate local MD5: %w", err)
+		}
+		remoteData, err := client.DownloadRange(file.Archive, entry.Offset+offset, chunkSize)
+		if err != nil {
+			return fmt.Errorf("failed to download archived data: %w", err)
+		}
+		if remoteHash := fmt.Sprintf("%x", md5.Sum(remoteData)); localHash != remoteHash {
+			return fmt.Errorf("MD5 mismatch at offset %d: local=%s, archived=%s", offset, localHash, remoteHash)
+		}
+	}
+	return nil
+}
+
+// calculateRangeMD5 calculates the MD5 hash of size bytes of a file at offset
+func (p *WorkerPool) calculateRangeMD5(ctx context.Context, source service.Source, filePath string, offset, size int64) (string, error) {
+	file, err := source.Open(ctx, filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := io.CopyN(io.Discard, file, offset); err != nil {
+		return "", err
+	}
+	hash := md5.New()
+	if _, err := io.CopyN(hash, file, size); err != nil && err != io.EOF {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// loadBatchIndex downloads the index of an archive and checks the archive's size
+func loadBatchIndex(client service.Destination, archivePath string) (*service.BatchIndex, error) {
+	indexPath := service.BatchIndexPath(archivePath)
+	meta, err := client.GetFileInfo(indexPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get archive index: %w", err)
+	}
+	data, err := client.DownloadPartialFile(indexPath, meta.Size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download archive index: %w", err)
+	}
+	var index service.BatchIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse archive index: %w", err)
+	}
+
+	archive, err := client.GetFileInfo(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get archive info: %w", err)
+	}
+	if archive.Size != index.Size {
+		return nil, fmt.Errorf("archive size mismatch: indexed=%d, remote=%d", index.Size, archive.Size)
+	}
+	return &index, nil
+}
@@ -0,0 +1,139 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/stoz/config"
+	"github.com/atopos31/stoz/models"
+	"github.com/atopos31/stoz/service"
+	"github.com/atopos31/stoz/service/zimaosfake"
+)
+
+func TestVerifyArchivedFileReadsArchive(t *testing.T) {
+	fake, err := zimaosfake.New("admin", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+	client := fake.Client()
+
+	dir := t.TempDir()
+	big := make([]byte, 3<<20) // Spans a first and a last verification chunk
+	rand.Read(big)
+	files := map[string][]byte{"big.bin": big, "small.txt": []byte("small file")}
+	var paths []string
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	remoteDir := "/media/ZimaOS-HD/batch"
+	archivePath := batchArchiveName(remoteDir, 1)
+	var archive bytes.Buffer
+	index, skipped, err := service.WriteBatchArchive(context.Background(), nil, archivePath, paths, 16<<20, &archive)
+	if err != nil || len(skipped) > 0 {
+		t.Fatalf("failed to build archive: %v, skipped %v", err, skipped)
+	}
+	indexData, err := json.Marshal(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(fake.LocalPath(remoteDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fake.LocalPath(service.BatchIndexPath(archivePath)), indexData, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(name string) error {
+		file := FileInfo{LocalPath: filepath.Join(dir, name), RemotePath: filepath.Join(remoteDir, name), Archive: archivePath}
+		return (&WorkerPool{}).verifyArchivedFile(context.Background(), &service.LocalSource{}, file, client, map[string]*service.BatchIndex{})
+	}
+
+	if err := os.WriteFile(fake.LocalPath(archivePath), archive.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name := range files {
+		if err := verify(name); err != nil {
+			t.Errorf("%s: intact archive failed verification: %v", name, err)
+		}
+	}
+
+	// Same size, so only reading the archive can notice
+	corrupt := bytes.Clone(archive.Bytes())
+	entry := index.Entry("big.bin")
+	corrupt[entry.Offset+entry.Size-10] ^= 0xff
+	if err := os.WriteFile(fake.LocalPath(archivePath), corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify("big.bin"); err == nil || !strings.Contains(err.Error(), "MD5 mismatch") {
+		t.Errorf("corrupt archive: got %v, want an MD5 mismatch", err)
+	}
+	if err := verify("small.txt"); err != nil {
+		t.Errorf("small.txt: untouched entry failed verification: %v", err)
+	}
+}
+
+// progressDestination records the task's progress after every progress report
+type progressDestination struct {
+	*service.LocalDestination
+	status *service.TaskStatus
+	seen   *[]float64
+}
+
+func (d *progressDestination) UploadFile(ctx context.Context, localPath, remotePath string, onProgress func(delta int64)) error {
+	return d.LocalDestination.UploadFile(ctx, localPath, remotePath, func(delta int64) {
+		onProgress(delta)
+		*d.seen = append(*d.seen, d.status.Progress)
+	})
+}
+
+func TestUploadEachUpdatesProgress(t *testing.T) {
+	src, dest := t.TempDir(), t.TempDir()
+	roots := config.AppConfig.Dest.LocalRoots
+	config.AppConfig.Dest.LocalRoots = []string{dest}
+	t.Cleanup(func() { config.AppConfig.Dest.LocalRoots = roots })
+
+	var files []FileInfo
+	for _, name := range []string{"a.bin", "b.bin"} {
+		path := filepath.Join(src, name)
+		if err := os.WriteFile(path, randomData(1<<20), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, FileInfo{LocalPath: path, RemotePath: filepath.Join(dest, name), Size: 1 << 20})
+	}
+
+	task := &models.MigrationTask{TaskID: t.Name()}
+	status := &service.TaskStatus{TaskID: task.TaskID, TotalSize: 4 << 20}
+	local, err := service.NewLocalDestination()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seen []float64
+	client := &progressDestination{LocalDestination: local, status: status, seen: &seen}
+	if err := testPool(nil).uploadEach(context.Background(), task, status, client, files, service.MigrationOptions{}); err != nil {
+		t.Fatalf("uploadEach: %v", err)
+	}
+
+	if len(seen) < 2 {
+		t.Fatalf("%d progress reports, want several", len(seen))
+	}
+	for i := 1; i < len(seen); i++ {
+		if seen[i] < seen[i-1] {
+			t.Errorf("progress went back from %.1f%% to %.1f%%", seen[i-1], seen[i])
+		}
+	}
+	if last := seen[len(seen)-1]; last != 50 {
+		t.Errorf("progress %.1f%% after uploading half of the task, want 50%%", last)
+	}
+}
@@ -0,0 +1,130 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/service"
+)
+
+// planDedupe finds files with identical content and points all but the
+// first of each group at that first file through DuplicateOf. Duplicates
+// are moved to the end of fileList so their originals are uploaded first;
+// like planBatches, the order only depends on the file list and contents.
+func planDedupe(ctx context.Context, source service.Source, fileList []FileInfo, policy service.DedupePolicy) (int, int64, error) {
+	files := make([]service.DedupeFile, len(fileList))
+	for i, file := range fileList {
+		files[i] = service.DedupeFile{Path: file.LocalPath, Size: file.Size}
+	}
+	groups, err := service.FindDuplicates(ctx, source, files, service.DedupeMinSize(policy.MinSize), nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	byPath := make(map[string]int, len(fileList))
+	for i, file := range fileList {
+		byPath[file.LocalPath] = i
+	}
+	var count int
+	var size int64
+	for _, group := range groups {
+		original := fileList[byPath[group.Paths[0]]].RemotePath
+		for _, path := range group.Paths[1:] {
+			fileList[byPath[path]].DuplicateOf = original
+			count++
+			size += group.Size
+		}
+	}
+
+	unique := make([]FileInfo, 0, len(fileList))
+	duplicates := make([]FileInfo, 0, count)
+	for _, file := range fileList {
+		if file.DuplicateOf != "" {
+			duplicates = append(duplicates, file)
+		} else {
+			unique = append(unique, file)
+		}
+	}
+	copy(fileList, append(unique, duplicates...))
+	return count, size, nil
+}
+
+// dedupeFile stands in for uploading a duplicate: it copies the original on
+// the destination, or in skip mode does nothing. It returns false when the
+// duplicate has to be uploaded after all, e.g. because its original failed.
+func (p *WorkerPool) dedupeFile(ctx context.Context, source service.Source, client service.Destination, file FileInfo, mode string) bool {
+	original, err := client.GetFileInfo(file.DuplicateOf)
+	if err != nil && !errors.Is(err, common.ErrFileNotFound) {
+		common.Warnf("Uploading duplicate %s: failed to check original %s: %v", file.LocalPath, file.DuplicateOf, err)
+		return false
+	}
+	if err != nil || original.Size != file.Size {
+		common.Warnf("Uploading duplicate %s: original %s is not on the destination", file.LocalPath, file.DuplicateOf)
+		return false
+	}
+	if mode == service.DedupeSkip {
+		common.Infof("Skipped duplicate %s of %s", file.LocalPath, file.DuplicateOf)
+		return true
+	}
+
+	copier, ok := client.(service.CopyingDestination)
+	if !ok {
+		return false
+	}
+	stat, err := source.Stat(file.LocalPath)
+	if err != nil {
+		common.Warnf("Uploading duplicate %s: %v", file.LocalPath, err)
+		return false
+	}
+	if err := client.CreateFolder(filepath.Dir(file.RemotePath)); err != nil {
+		common.Warnf("Uploading duplicate %s: failed to create folder: %v", file.LocalPath, err)
+		return false
+	}
+	if err := copier.CopyFile(ctx, file.DuplicateOf, file.RemotePath, stat.ModTime()); err != nil {
+		if ctx.Err() == nil {
+			common.Warnf("Uploading duplicate %s: %v", file.LocalPath, err)
+		}
+		return false
+	}
+	return true
+}
+
+// writeDedupeManifest stores the manifest of the duplicates that were
+// skipped. They are the ones missing on the destination, which also covers
+// the files skipped before a resume.
+func (p *WorkerPool) writeDedupeManifest(ctx context.Context, client service.Destination, spool *service.SpoolSource, taskID, basePath string, fileList []FileInfo) error {
+	manifest := &service.DedupeManifest{TaskID: taskID, Created: time.Now().Unix(), Entries: []service.DedupeEntry{}}
+	for _, file := range fileList {
+		if file.DuplicateOf == "" {
+			continue
+		}
+		if _, err := client.GetFileInfo(file.RemotePath); err == nil {
+			continue
+		} else if !errors.Is(err, common.ErrFileNotFound) {
+			return fmt.Errorf("failed to check %s: %w", file.RemotePath, err)
+		}
+		manifest.Entries = append(manifest.Entries, service.DedupeEntry{
+			Path:     file.RemotePath,
+			Source:   file.LocalPath,
+			Original: file.DuplicateOf,
+			Size:     file.Size,
+		})
+	}
+	if len(manifest.Entries) == 0 {
+		return nil
+	}
+
+	manifestPath := service.DedupeManifestPath(basePath, taskID)
+	if err := client.CreateFolder(basePath); err != nil {
+		return fmt.Errorf("failed to create folder: %w", err)
+	}
+	if err := p.uploadJSON(ctx, client, spool, manifest, manifestPath); err != nil {
+		return fmt.Errorf("failed to upload duplicate manifest: %w", err)
+	}
+	common.Infof("Task %s: listed %d skipped duplicates in %s", taskID, len(manifest.Entries), manifestPath)
+	return nil
+}
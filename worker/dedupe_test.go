@@ -0,0 +1,197 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/atopos31/stoz/config"
+	"github.com/atopos31/stoz/models"
+	"github.com/atopos31/stoz/service"
+	"github.com/atopos31/stoz/service/zimaosfake"
+)
+
+func TestPlanDedupeMovesDuplicatesLast(t *testing.T) {
+	dir := t.TempDir()
+	big := randomData(100 << 10)
+	other := bytes.Clone(big)
+	other[len(other)-1] ^= 0xff // Same size and start, different content
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"a", big}, {"b", randomData(5000)}, {"c", big}, {"d", other}, {"e", big}, {"f", []byte("tiny")}, {"g", []byte("tiny")},
+	}
+	var fileList []FileInfo
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		if err := os.WriteFile(path, f.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		fileList = append(fileList, FileInfo{LocalPath: path, RemotePath: "/remote/" + f.name, Size: int64(len(f.data))})
+	}
+
+	count, size, err := planDedupe(context.Background(), &service.LocalSource{}, fileList, service.DedupePolicy{Mode: service.DedupeSkip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 || size != 2*int64(len(big)) {
+		t.Errorf("planned %d duplicates of %d bytes, want 2 of %d", count, size, 2*len(big))
+	}
+
+	var order, duplicateOf []string
+	for _, file := range fileList {
+		order = append(order, filepath.Base(file.LocalPath))
+		duplicateOf = append(duplicateOf, file.DuplicateOf)
+	}
+	// Originals keep their order, then the duplicates in theirs; files below
+	// the minimum size are uploaded even if they are identical
+	if want := []string{"a", "b", "d", "f", "g", "c", "e"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+	if want := []string{"", "", "", "", "", "/remote/a", "/remote/a"}; !reflect.DeepEqual(duplicateOf, want) {
+		t.Errorf("duplicate of = %v, want %v", duplicateOf, want)
+	}
+}
+
+func TestProcessTaskSkipsDuplicates(t *testing.T) {
+	fake := newFake(t)
+	data := randomData(200000)
+	task := testTask(t, map[string][]byte{"a.bin": data, "b.bin": data, "c.bin": randomData(200000)},
+		service.MigrationOptions{Dedupe: &service.DedupePolicy{Mode: service.DedupeSkip}})
+
+	if err := testPool(fake).processTask(task); err != nil {
+		t.Fatalf("processTask: %v", err)
+	}
+	got := finishedTask(t, task.TaskID)
+	if got.Status != models.StatusCompleted {
+		t.Fatalf("status %s (%s), want completed", got.Status, got.Error)
+	}
+	if got.DedupedFiles != 1 || got.DedupedSize != int64(len(data)) {
+		t.Errorf("deduped %d files of %d bytes, want 1 of %d", got.DedupedFiles, got.DedupedSize, len(data))
+	}
+	if n := fake.Requests(zimaosfake.EndpointUpload); n != 3 {
+		t.Errorf("%d uploads, want the two originals and the manifest", n)
+	}
+
+	remote := task.BasePath + "/" + t.Name()
+	if _, err := fake.ReadFile(remote + "/b.bin"); err == nil {
+		t.Error("the skipped duplicate was uploaded")
+	}
+	manifestData, err := fake.ReadFile(service.DedupeManifestPath(task.BasePath, task.TaskID))
+	if err != nil {
+		t.Fatalf("manifest: %v", err)
+	}
+	var manifest service.DedupeManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	want := []service.DedupeEntry{{
+		Path:     remote + "/b.bin",
+		Source:   filepath.Join(config.AppConfig.Scan.HostPath, "volume1", t.Name(), "b.bin"),
+		Original: remote + "/a.bin",
+		Size:     int64(len(data)),
+	}}
+	if manifest.TaskID != task.TaskID || !reflect.DeepEqual(manifest.Entries, want) {
+		t.Errorf("manifest = %+v, want entries %+v", manifest, want)
+	}
+}
+
+func TestProcessTaskCopiesDuplicates(t *testing.T) {
+	dest := t.TempDir()
+	roots := config.AppConfig.Dest.LocalRoots
+	config.AppConfig.Dest.LocalRoots = []string{dest}
+	t.Cleanup(func() { config.AppConfig.Dest.LocalRoots = roots })
+
+	data := randomData(200000)
+	task := testTask(t, map[string][]byte{"a.bin": data, "b.bin": data},
+		service.MigrationOptions{Dedupe: &service.DedupePolicy{Mode: service.DedupeCopy}})
+	task.BasePath = dest
+	task.DestinationType = service.DestinationLocal
+	if err := models.DB.Save(task).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var uploads int
+	pool := testPool(nil)
+	pool.clientFactory = func(task *models.MigrationTask) (service.Destination, error) {
+		client, err := service.NewLocalDestination()
+		return &countingDestination{LocalDestination: client, uploads: &uploads}, err
+	}
+	if err := pool.processTask(task); err != nil {
+		t.Fatalf("processTask: %v", err)
+	}
+	got := finishedTask(t, task.TaskID)
+	if got.Status != models.StatusCompleted {
+		t.Fatalf("status %s (%s), want completed", got.Status, got.Error)
+	}
+	if got.DedupedFiles != 1 {
+		t.Errorf("deduped %d files, want 1", got.DedupedFiles)
+	}
+	if uploads != 1 {
+		t.Errorf("%d uploads, want only the original", uploads)
+	}
+	for _, name := range []string{"a.bin", "b.bin"} {
+		stored, err := os.ReadFile(filepath.Join(dest, t.Name(), name))
+		if err != nil || !bytes.Equal(stored, data) {
+			t.Errorf("%s differs from the source: %v", name, err)
+		}
+	}
+}
+
+// countingDestination counts the files uploaded to a local destination
+type countingDestination struct {
+	*service.LocalDestination
+	uploads *int
+}
+
+func (d *countingDestination) UploadFile(ctx context.Context, localPath, remotePath string, onProgress func(delta int64)) error {
+	*d.uploads++
+	return d.LocalDestination.UploadFile(ctx, localPath, remotePath, onProgress)
+}
+
+func TestDedupeFileUploadsWithoutOriginal(t *testing.T) {
+	fake := newFake(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "b.bin")
+	data := randomData(10000)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	remote := "/media/ZimaOS-HD/dedupe"
+	if err := os.MkdirAll(fake.LocalPath(remote), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := FileInfo{LocalPath: path, RemotePath: remote + "/b.bin", Size: int64(len(data)), DuplicateOf: remote + "/a.bin"}
+	pool := testPool(fake)
+	// A fresh client each time, as listings are cached
+	dedupe := func(mode string) bool {
+		return pool.dedupeFile(context.Background(), &service.LocalSource{}, fake.Client(), file, mode)
+	}
+
+	// The original failed to upload
+	if dedupe(service.DedupeSkip) {
+		t.Error("skipped a duplicate whose original is missing")
+	}
+	// The original is there, but with other content
+	if err := os.WriteFile(fake.LocalPath(file.DuplicateOf), data[:5000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if dedupe(service.DedupeSkip) {
+		t.Error("skipped a duplicate whose original has another size")
+	}
+	if err := os.WriteFile(fake.LocalPath(file.DuplicateOf), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !dedupe(service.DedupeSkip) {
+		t.Error("uploaded a duplicate whose original is stored")
+	}
+	// ZimaOS cannot copy files, so copy mode uploads
+	if dedupe(service.DedupeCopy) {
+		t.Error("copied a duplicate on a destination that cannot copy")
+	}
+}
@@ -0,0 +1,34 @@
+package worker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/config"
+	"github.com/atopos31/stoz/models"
+)
+
+func TestMain(m *testing.M) {
+	config.Load()
+	common.InitLogger("error")
+
+	// Tasks read their sources from HOST_PATH/volume* and keep state in the database
+	dir, err := os.MkdirTemp("", "stoz-worker-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	config.AppConfig.Scan.HostPath = dir
+	if err := models.InitDB(filepath.Join(dir, "stoz.db")); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
@@ -4,11 +4,11 @@ import (
 	"context"
 	"crypto/md5"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"math"
-	"os"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -20,25 +20,44 @@ import (
 	"github.com/atopos31/stoz/service"
 )
 
+// WorkerPool runs queued migration tasks. The queue itself is persisted in
+// the database; SubmitTask only wakes an idle worker.
 type WorkerPool struct {
-	taskQueue    chan string
+	notify       chan struct{}
 	workers      int
+	maxPerTarget int
+	pollInterval time.Duration
 	migrationSvc *service.MigrationService
-	stopChan     chan struct{}
+	stopChan     chan struct{} // Closed on shutdown: workers checkpoint at the next safe point
+	stopOnce     sync.Once
+	hardCtx      context.Context // Cancelled when the shutdown timeout expires
+	hardCancel   context.CancelFunc
 	wg           sync.WaitGroup
+
+	factoryMu     sync.RWMutex
+	clientFactory ClientFactory // nil = newClient
 }
 
+// ClientFactory connects to the destination a task migrates to
+type ClientFactory func(task *models.MigrationTask) (service.Destination, error)
+
+// errDraining is returned from inner loops when the pool is shutting down
+var errDraining = errors.New("worker pool is shutting down")
+
 var pool *WorkerPool
 var poolOnce sync.Once
 
 func GetWorkerPool() *WorkerPool {
 	poolOnce.Do(func() {
 		pool = &WorkerPool{
-			taskQueue:    make(chan string, 100),
+			notify:       make(chan struct{}, 1),
 			workers:      config.AppConfig.Worker.Count,
+			maxPerTarget: config.AppConfig.Worker.MaxPerTarget,
+			pollInterval: config.AppConfig.Worker.QueuePoll,
 			migrationSvc: service.GetMigrationService(),
 			stopChan:     make(chan struct{}),
 		}
+		pool.hardCtx, pool.hardCancel = context.WithCancel(context.Background())
 		pool.Start()
 	})
 	return pool
@@ -53,60 +72,181 @@ func (p *WorkerPool) Start() {
 }
 
 func (p *WorkerPool) Stop() {
-	close(p.stopChan)
-	p.wg.Wait()
-	common.Info("All migration workers stopped")
+	p.Shutdown(context.Background())
+}
+
+// Shutdown asks running tasks to checkpoint after their current file and
+// waits for them. When ctx expires, in-flight uploads are interrupted and the
+// interrupted files are redone on the next start.
+func (p *WorkerPool) Shutdown(ctx context.Context) error {
+	p.stopOnce.Do(func() {
+		close(p.stopChan)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		common.Info("All migration workers stopped")
+		return nil
+	case <-ctx.Done():
+	}
+
+	common.Warn("Shutdown timeout reached, interrupting running uploads")
+	p.hardCancel()
+
+	// Give workers a moment to persist their checkpoints
+	select {
+	case <-done:
+		common.Info("All migration workers stopped")
+	case <-time.After(5 * time.Second):
+		common.Warn("Some workers did not stop in time; their tasks will be recovered on next start")
+	}
+	return ctx.Err()
+}
+
+func (p *WorkerPool) draining() bool {
+	select {
+	case <-p.stopChan:
+		return true
+	default:
+		return false
+	}
 }
 
+// SubmitTask signals that a task was queued. It never blocks.
 func (p *WorkerPool) SubmitTask(taskID string) {
-	p.taskQueue <- taskID
+	common.Infof("Task %s queued", taskID)
+	p.wake()
+}
+
+func (p *WorkerPool) wake() {
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
 }
 
 func (p *WorkerPool) worker(id int) {
 	defer p.wg.Done()
 	common.Infof("Worker %d started", id)
 
+	timer := time.NewTimer(p.pollInterval)
+	defer timer.Stop()
+
 	for {
 		select {
 		case <-p.stopChan:
 			common.Infof("Worker %d stopping", id)
 			return
-		case taskID := <-p.taskQueue:
-			common.Infof("Worker %d processing task %s", id, taskID)
-			if err := p.processTask(taskID); err != nil {
-				common.Errorf("Worker %d failed to process task %s: %v", id, taskID, err)
+		default:
+		}
+
+		task, err := p.migrationSvc.ClaimNextTask(p.maxPerTarget)
+		if err != nil {
+			common.Errorf("Worker %d failed to claim task: %v", id, err)
+		}
+
+		if task == nil {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(p.pollInterval)
+
+			select {
+			case <-p.stopChan:
+				common.Infof("Worker %d stopping", id)
+				return
+			case <-p.notify:
+			case <-timer.C:
 			}
+			continue
+		}
+
+		// More work may be waiting; let another idle worker look
+		p.wake()
+
+		common.Infof("Worker %d processing task %s", id, task.TaskID)
+		if err := p.processTask(task); err != nil {
+			common.Errorf("Worker %d failed to process task %s: %v", id, task.TaskID, err)
+		}
+		if current, err := p.migrationSvc.GetTask(task.TaskID); err == nil && current.Status == models.StatusCancelled {
+			p.audit("task.cancelled", current, nil)
 		}
+
+		// A per-target slot was freed
+		p.wake()
 	}
 }
 
-func (p *WorkerPool) processTask(taskID string) error {
-	task, err := p.migrationSvc.GetTask(taskID)
-	if err != nil {
-		return fmt.Errorf("failed to get task: %w", err)
+// SetClientFactory replaces how tasks connect to their destination, e.g. with a fake
+// server in tests. nil restores the default.
+func (p *WorkerPool) SetClientFactory(factory ClientFactory) {
+	p.factoryMu.Lock()
+	p.clientFactory = factory
+	p.factoryMu.Unlock()
+}
+
+func (p *WorkerPool) connect(task *models.MigrationTask) (service.Destination, error) {
+	p.factoryMu.RLock()
+	factory := p.clientFactory
+	p.factoryMu.RUnlock()
+
+	if factory != nil {
+		return factory(task)
 	}
+	return p.newClient(task)
+}
 
-	// CRITICAL: Check cancelled status BEFORE setting to running
-	if task.Status == models.StatusCancelled {
-		common.Infof("Task %s is cancelled, skipping", taskID)
-		return nil
+// newClient connects with the task's saved target or its own credentials.
+// Credentials are stored encrypted and only decrypted here.
+func (p *WorkerPool) newClient(task *models.MigrationTask) (service.Destination, error) {
+	if task.DestinationType != "" && task.DestinationType != service.DestinationZimaOS {
+		var opts service.DestinationOptions
+		if task.DestinationOptions != "" {
+			if err := json.Unmarshal([]byte(task.DestinationOptions), &opts); err != nil {
+				return nil, fmt.Errorf("failed to parse destination options: %w", err)
+			}
+		}
+		password, err := common.DecryptSecret(task.DestSecret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt destination credentials: %w", err)
+		}
+		return service.NewDestination(task.DestinationType, task.DestURL, task.DestUsername, password, opts)
 	}
 
-	// Only transition to running if task is pending
-	if task.Status != models.StatusPending {
-		common.Warnf("Task %s has unexpected status %s, skipping", taskID, task.Status)
-		return nil
+	if task.TargetID != "" {
+		target, err := service.GetTargetService().GetTarget(task.TargetID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load target %s: %w", task.TargetID, err)
+		}
+		client, err := service.GetTargetService().NewClient(target)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
 	}
 
-	task.Status = models.StatusRunning
-	now := time.Now()
-	task.StartedAt = &now
-	if err := p.migrationSvc.UpdateTask(task); err != nil {
-		return fmt.Errorf("failed to update task status: %w", err)
+	password, err := common.DecryptSecret(task.DestSecret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt ZimaOS credentials: %w", err)
 	}
+	return service.NewZimaOSClient(task.DestURL, task.DestUsername, password), nil
+}
+
+// processTask runs a task that has already been claimed (status running)
+func (p *WorkerPool) processTask(task *models.MigrationTask) error {
+	taskID := task.TaskID
 
-	// Create cancelable context
-	ctx, cancel := context.WithCancel(context.Background())
+	// Create cancelable context; it is also cancelled when a shutdown times out
+	ctx, cancel := context.WithCancel(p.hardCtx)
 	defer cancel()
 
 	// Start background goroutine to monitor cancellation status
@@ -151,18 +291,102 @@ func (p *WorkerPool) processTask(taskID string) error {
 		return p.failTask(task, fmt.Errorf("failed to parse source folders: %w", err))
 	}
 
-	client := service.NewZimaOSClient(task.ZimaOSHost, task.ZimaOSUsername, task.ZimaOSPassword)
+	// Re-check the sources: a folder may have been replaced by a symlink
+	// since the task was created, or the task predates path validation
+	source, releaseSource, err := service.OpenTaskSource(task.SourceTargetID)
+	if err != nil {
+		return p.failTask(task, err)
+	}
+	defer releaseSource()
+	for i, folder := range sourceFolders {
+		resolved, err := source.Resolve(folder)
+		if err != nil {
+			common.SecurityEvent("task_source_rejected", map[string]interface{}{
+				"task_id": taskID,
+				"path":    folder,
+				"error":   err.Error(),
+			})
+			return p.failTask(task, err)
+		}
+		sourceFolders[i] = resolved
+	}
+
+	client, err := p.connect(task)
+	if err != nil {
+		return p.failTask(task, err)
+	}
+	defer client.Close()
 	if err := client.Login(); err != nil {
-		return p.failTask(task, fmt.Errorf("failed to login to ZimaOS: %w", err))
+		return p.failTask(task, fmt.Errorf("failed to connect to destination: %w", err))
 	}
 
-	common.Infof("Successfully logged in to ZimaOS for task %s", taskID)
+	common.Infof("Successfully connected to destination for task %s", taskID)
+
+	bandwidth := service.GetBandwidthManager()
+	var taskPolicy service.BandwidthPolicy
+	if options.Bandwidth != nil {
+		taskPolicy = *options.Bandwidth
+	}
+	taskLimiter := bandwidth.AcquireTask(taskID, taskPolicy)
+	defer bandwidth.ReleaseTask(taskID)
+	client.SetBandwidthLimiters(bandwidth.Global(), taskLimiter)
+	client.SetSource(source)
+	if options.Compression != nil {
+		compressing, ok := client.(service.CompressingDestination)
+		if !ok {
+			return p.failTask(task, fmt.Errorf("destination cannot store compressed files"))
+		}
+		compressing.SetCompression(*options.Compression)
+	}
 
-	fileList, totalSize, err := p.scanFolders(sourceFolders, task.BasePath, options)
+	fileList, totalSize, err := p.scanFolders(source, sourceFolders, task.BasePath, options)
 	if err != nil {
 		return p.failTask(task, fmt.Errorf("failed to scan folders: %w", err))
 	}
 
+	// Duplicates are found first, so they are never batched
+	if options.Dedupe != nil {
+		common.Infof("Task %s: looking for duplicate files", taskID)
+		planCtx, stopPlan := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-p.stopChan:
+				stopPlan()
+			case <-planCtx.Done():
+			}
+		}()
+		duplicates, duplicateSize, err := planDedupe(planCtx, source, fileList, *options.Dedupe)
+		stopPlan()
+		if err != nil {
+			if p.draining() {
+				// Nothing was uploaded in this run, so the last checkpoint still holds
+				if err := p.migrationSvc.CheckpointTask(task); err != nil {
+					return fmt.Errorf("failed to checkpoint task: %w", err)
+				}
+				p.audit("task.checkpointed", task, nil)
+				common.Infof("Task %s requeued for shutdown during duplicate analysis", taskID)
+				return nil
+			}
+			if ctx.Err() != nil {
+				common.Infof("Task %s cancelled during duplicate analysis", taskID)
+				return nil
+			}
+			return p.failTask(task, fmt.Errorf("failed to find duplicates: %w", err))
+		}
+		common.Infof("Task %s: found %d duplicate files, %d bytes", taskID, duplicates, duplicateSize)
+	}
+
+	// Batch archives and manifests are spooled locally and read through the source
+	var spool *service.SpoolSource
+	if options.Batch != nil || options.Dedupe != nil {
+		spool = service.NewSpoolSource(source)
+		client.SetSource(spool)
+	}
+	if options.Batch != nil {
+		batches := planBatches(fileList, options.Batch.WithDefaults())
+		common.Infof("Task %s: packing small files into %d batch archives", taskID, batches)
+	}
+
 	task.TotalFiles = len(fileList)
 	task.TotalSize = totalSize
 	if err := p.migrationSvc.UpdateTask(task); err != nil {
@@ -183,6 +407,27 @@ func (p *WorkerPool) processTask(taskID string) error {
 		StartedAt:       time.Now(),
 		UpdatedAt:       time.Now(),
 	}
+
+	start := p.resumeIndex(task, fileList)
+	if start > 0 {
+		p.audit("task.resumed", task, nil)
+	} else {
+		p.audit("task.started", task, nil)
+	}
+	// Everything the task limiter lets through is sent to the destination
+	status.TrackWire(0, taskLimiter)
+	if start > 0 {
+		status.ProcessedFiles = task.ProcessedFiles
+		status.FailedFiles = task.FailedFiles
+		status.DedupedFiles = task.DedupedFiles
+		status.DedupedSize = task.DedupedSize
+		status.TransferredSize = task.CheckpointTransferred
+		status.TrackWire(task.WireSize, taskLimiter)
+		if status.TotalSize > 0 {
+			status.Progress = float64(status.TransferredSize) / float64(status.TotalSize) * 100
+		}
+		common.Infof("Task %s: resuming from checkpoint at file %d/%d", taskID, start, len(fileList))
+	}
 	p.migrationSvc.UpdateTaskStatus(taskID, status)
 
 	startTime := time.Now()
@@ -191,7 +436,14 @@ func (p *WorkerPool) processTask(taskID string) error {
 	lastDBUpdate := time.Now()
 	var lastTransferredSize int64
 
-	for i, fileInfo := range fileList {
+	for i := start; i < len(fileList); i++ {
+		fileInfo := fileList[i]
+
+		// Between files is a safe point to stop for a shutdown
+		if p.draining() {
+			return p.checkpointTask(task, status, fileList, i)
+		}
+
 		// Check if context is cancelled before processing each file
 		select {
 		case <-ctx.Done():
@@ -211,6 +463,49 @@ func (p *WorkerPool) processTask(taskID string) error {
 			return nil
 		}
 
+		if fileInfo.Archive != "" {
+			end := i + 1
+			for end < len(fileList) && fileList[end].Archive == fileInfo.Archive {
+				end++
+			}
+
+			baseTransferred, baseFailed := status.TransferredSize, status.FailedFiles
+			if err := p.uploadBatch(ctx, task, status, client, spool, fileList[i:end], options); err != nil {
+				if ctx.Err() != nil {
+					// Interrupted: the whole batch is redone on resume
+					status.TransferredSize, status.FailedFiles = baseTransferred, baseFailed
+					if p.draining() {
+						return p.checkpointTask(task, status, fileList, i)
+					}
+					common.Infof("Task %s cancelled during batch %s", taskID, fileInfo.Archive)
+					return nil
+				}
+				return p.failTask(task, err)
+			}
+
+			status.ProcessedFiles = end
+			if status.TotalSize > 0 {
+				status.Progress = float64(status.TransferredSize) / float64(status.TotalSize) * 100
+			} else {
+				status.Progress = 100
+			}
+			status.CurrentFileTransferred = status.CurrentFileSize
+			status.CurrentFileProgress = 100
+
+			now := time.Now()
+			if now.Sub(lastSpeedUpdate) >= 1*time.Second {
+				status.Speed = int64(float64(status.TransferredSize-lastTransferredSize) / now.Sub(lastSpeedUpdate).Seconds())
+				lastSpeedUpdate = now
+				lastTransferredSize = status.TransferredSize
+			}
+			status.UpdatedAt = now
+			p.migrationSvc.UpdateTaskStatus(taskID, status)
+
+			p.saveCheckpoint(task, status, fileList, end)
+			i = end - 1
+			continue
+		}
+
 		status.CurrentFile = fileInfo.LocalPath
 		status.CurrentFileSize = fileInfo.Size
 		status.CurrentFileTransferred = 0
@@ -218,6 +513,26 @@ func (p *WorkerPool) processTask(taskID string) error {
 		status.UpdatedAt = time.Now()
 		p.migrationSvc.UpdateTaskStatus(taskID, status)
 
+		// Duplicates count as transferred without being uploaded
+		if fileInfo.DuplicateOf != "" && p.dedupeFile(ctx, source, client, fileInfo, options.Dedupe.Mode) {
+			status.DedupedFiles++
+			status.DedupedSize += fileInfo.Size
+			status.ProcessedFiles = i + 1
+			status.TransferredSize += fileInfo.Size
+			if status.TotalSize > 0 {
+				status.Progress = float64(status.TransferredSize) / float64(status.TotalSize) * 100
+			} else {
+				status.Progress = 100
+			}
+			status.CurrentFileTransferred = fileInfo.Size
+			status.CurrentFileProgress = 100
+			status.UpdatedAt = time.Now()
+			p.migrationSvc.UpdateTaskStatus(taskID, status)
+
+			p.saveCheckpoint(task, status, fileList, i+1)
+			continue
+		}
+
 		remoteDir := filepath.Dir(fileInfo.RemotePath)
 		if err := client.CreateFolder(remoteDir); err != nil {
 			common.Errorf("Failed to create remote folder %s: %v", remoteDir, err)
@@ -226,6 +541,7 @@ func (p *WorkerPool) processTask(taskID string) error {
 			}
 			status.FailedFiles++
 			p.logError(taskID, fileInfo.LocalPath, err)
+			p.saveCheckpoint(task, status, fileList, i+1)
 			continue
 		}
 
@@ -280,6 +596,9 @@ func (p *WorkerPool) processTask(taskID string) error {
 			if now.Sub(lastDBUpdate) >= 1*time.Second || fileTransferred == fileInfo.Size {
 				task.ProcessedFiles = status.ProcessedFiles
 				task.TransferredSize = status.TransferredSize
+				task.WireSize = status.RefreshWireSize()
+				task.DedupedFiles = status.DedupedFiles
+				task.DedupedSize = status.DedupedSize
 				task.Progress = status.Progress
 				task.FailedFiles = status.FailedFiles
 				if err := p.migrationSvc.UpdateTask(task); err != nil {
@@ -290,12 +609,24 @@ func (p *WorkerPool) processTask(taskID string) error {
 		}
 
 		if err := p.uploadFileWithRetry(ctx, client, fileInfo.LocalPath, fileInfo.RemotePath, config.AppConfig.Worker.MaxRetries, onProgress, onReset); err != nil {
+			if ctx.Err() != nil {
+				// Interrupted rather than failed: the file is redone on resume
+				status.TransferredSize = baseTransferred
+				if p.draining() {
+					return p.checkpointTask(task, status, fileList, i)
+				}
+				common.Infof("Task %s cancelled during upload of %s", taskID, fileInfo.LocalPath)
+				return nil
+			}
+
 			common.Errorf("Failed to upload file %s: %v", fileInfo.LocalPath, err)
 			if !options.SkipErrors {
 				return p.failTask(task, fmt.Errorf("failed to upload file: %w", err))
 			}
 			status.FailedFiles++
+			status.TransferredSize = baseTransferred
 			p.logError(taskID, fileInfo.LocalPath, err)
+			p.saveCheckpoint(task, status, fileList, i+1)
 			continue
 		}
 
@@ -322,12 +653,20 @@ func (p *WorkerPool) processTask(taskID string) error {
 		status.UpdatedAt = now
 		p.migrationSvc.UpdateTaskStatus(taskID, status)
 
-		task.ProcessedFiles = status.ProcessedFiles
-		task.TransferredSize = status.TransferredSize
-		task.Progress = status.Progress
-		task.FailedFiles = status.FailedFiles
-		if err := p.migrationSvc.UpdateTask(task); err != nil {
-			common.Errorf("Failed to update task progress: %v", err)
+		p.saveCheckpoint(task, status, fileList, i+1)
+	}
+
+	// Skipped duplicates are only recorded in the manifest
+	if options.Dedupe != nil && options.Dedupe.Mode == service.DedupeSkip {
+		if err := p.writeDedupeManifest(ctx, client, spool, taskID, task.BasePath, fileList); err != nil {
+			if p.draining() {
+				return p.checkpointTask(task, status, fileList, len(fileList))
+			}
+			if ctx.Err() != nil {
+				common.Infof("Task %s cancelled while writing the duplicate manifest", taskID)
+				return nil
+			}
+			return p.failTask(task, err)
 		}
 	}
 
@@ -341,9 +680,18 @@ func (p *WorkerPool) processTask(taskID string) error {
 		if err := p.migrationSvc.UpdateTask(task); err != nil {
 			common.Errorf("Failed to update task to verifying status: %v", err)
 		}
+		p.audit("task.verifying", task, nil)
 
 		// Execute file verification
-		if err := p.verifyFiles(task, fileList, client); err != nil {
+		if err := p.verifyFiles(ctx, task, source, fileList, client, options); err != nil {
+			if errors.Is(err, errDraining) || (ctx.Err() != nil && p.draining()) {
+				// Uploads are done; verification restarts on resume
+				return p.checkpointTask(task, status, fileList, len(fileList))
+			}
+			if ctx.Err() != nil {
+				common.Infof("Task %s cancelled during verification", taskID)
+				return nil
+			}
 			return p.failTask(task, fmt.Errorf("verification failed: %w", err))
 		}
 	}
@@ -351,6 +699,9 @@ func (p *WorkerPool) processTask(taskID string) error {
 	task.Status = models.StatusCompleted
 	task.ProcessedFiles = status.ProcessedFiles
 	task.TransferredSize = status.TransferredSize
+	task.WireSize = status.RefreshWireSize()
+	task.DedupedFiles = status.DedupedFiles
+	task.DedupedSize = status.DedupedSize
 	task.Progress = 100
 	task.FailedFiles = status.FailedFiles
 	completedAt := time.Now()
@@ -358,6 +709,7 @@ func (p *WorkerPool) processTask(taskID string) error {
 	if err := p.migrationSvc.UpdateTask(task); err != nil {
 		common.Errorf("Failed to update task completion: %v", err)
 	}
+	p.audit("task.completed", task, nil)
 
 	status.Status = models.StatusCompleted
 	status.Progress = 100
@@ -368,44 +720,105 @@ func (p *WorkerPool) processTask(taskID string) error {
 	return nil
 }
 
+// resumeIndex returns the index of the first file still to upload. The
+// checkpoint is trusted only if the file list still has the same file at that
+// position; otherwise the file is looked up by path, or the task starts over.
+func (p *WorkerPool) resumeIndex(task *models.MigrationTask, fileList []FileInfo) int {
+	index := task.CheckpointIndex
+	if index <= 0 || task.CheckpointPath == "" {
+		return 0
+	}
+
+	if index <= len(fileList) && fileList[index-1].LocalPath == task.CheckpointPath {
+		return index
+	}
+
+	for i, file := range fileList {
+		if file.LocalPath == task.CheckpointPath {
+			common.Warnf("Task %s: file list changed since checkpoint, resuming after %s", task.TaskID, task.CheckpointPath)
+			return i + 1
+		}
+	}
+
+	common.Warnf("Task %s: checkpoint file %s no longer exists, restarting from the beginning", task.TaskID, task.CheckpointPath)
+	task.ProcessedFiles = 0
+	task.FailedFiles = 0
+	task.CheckpointTransferred = 0
+	return 0
+}
+
+// saveCheckpoint persists progress after fileList[next-1] has been handled
+func (p *WorkerPool) saveCheckpoint(task *models.MigrationTask, status *service.TaskStatus, fileList []FileInfo, next int) {
+	p.setCheckpoint(task, status, fileList, next)
+	if err := p.migrationSvc.UpdateTask(task); err != nil {
+		common.Errorf("Failed to update task progress: %v", err)
+	}
+}
+
+func (p *WorkerPool) setCheckpoint(task *models.MigrationTask, status *service.TaskStatus, fileList []FileInfo, next int) {
+	task.ProcessedFiles = status.ProcessedFiles
+	task.TransferredSize = status.TransferredSize
+	task.WireSize = status.RefreshWireSize()
+	task.DedupedFiles = status.DedupedFiles
+	task.DedupedSize = status.DedupedSize
+	task.Progress = status.Progress
+	task.FailedFiles = status.FailedFiles
+	task.CheckpointIndex = next
+	task.CheckpointTransferred = status.TransferredSize
+	task.CheckpointPath = ""
+	if next > 0 && next <= len(fileList) {
+		task.CheckpointPath = fileList[next-1].LocalPath
+	}
+}
+
+// checkpointTask stops a task for shutdown and puts it back in the queue so
+// the next start resumes with fileList[next]
+func (p *WorkerPool) checkpointTask(task *models.MigrationTask, status *service.TaskStatus, fileList []FileInfo, next int) error {
+	p.setCheckpoint(task, status, fileList, next)
+	if err := p.migrationSvc.CheckpointTask(task); err != nil {
+		return fmt.Errorf("failed to checkpoint task: %w", err)
+	}
+	p.audit("task.checkpointed", task, nil)
+
+	status.Status = task.Status
+	status.UpdatedAt = time.Now()
+	p.migrationSvc.UpdateTaskStatus(task.TaskID, status)
+
+	common.Infof("Task %s checkpointed at file %d/%d for shutdown", task.TaskID, next, len(fileList))
+	return nil
+}
+
 type FileInfo struct {
-	LocalPath  string
-	RemotePath string
-	Size       int64
+	LocalPath   string
+	RemotePath  string
+	Size        int64
+	Archive     string // Batch archive the file is uploaded in, if any
+	DuplicateOf string // Remote path of the first file with the same content, if any
 }
 
-func (p *WorkerPool) scanFolders(folders []string, basePath string, options service.MigrationOptions) ([]FileInfo, int64, error) {
+func (p *WorkerPool) scanFolders(source service.Source, folders []string, basePath string, options service.MigrationOptions) ([]FileInfo, int64, error) {
 	var fileList []FileInfo
 	var totalSize int64
 	var mu sync.Mutex
 
 	for _, folder := range folders {
-		err := filepath.WalkDir(folder, func(path string, d fs.DirEntry, err error) error {
-			if err != nil {
-				common.Warnf("Failed to access path %s: %v", path, err)
-				return nil
-			}
-
-			if d.IsDir() {
+		// The source skips unsafe symlinks and non-regular files; a network
+		// source fails the scan on a folder it cannot list
+		err := source.Walk(folder, func(path string, info fs.FileInfo) error {
+			if info.IsDir() {
 				// Skip directories starting with @ (e.g., @eaDir - Synology thumbnail system)
-				if strings.HasPrefix(d.Name(), "@") {
+				if strings.HasPrefix(info.Name(), "@") {
 					common.Infof("Skipping system directory: %s", path)
-					return filepath.SkipDir
+					return fs.SkipDir
 				}
 				// Skip #recycle directories if not including recycle bin
-				if !options.IncludeRecycle && d.Name() == "#recycle" {
+				if !options.IncludeRecycle && info.Name() == "#recycle" {
 					common.Infof("Skipping recycle bin: %s", path)
-					return filepath.SkipDir
+					return fs.SkipDir
 				}
 				return nil
 			}
 
-			info, err := d.Info()
-			if err != nil {
-				common.Warnf("Failed to get file info for %s: %v", path, err)
-				return nil
-			}
-
 			relativePath := strings.TrimPrefix(path, folder)
 			relativePath = strings.TrimPrefix(relativePath, "/")
 
@@ -432,7 +845,7 @@ func (p *WorkerPool) scanFolders(folders []string, basePath string, options serv
 	return fileList, totalSize, nil
 }
 
-func (p *WorkerPool) uploadFileWithRetry(ctx context.Context, client *service.ZimaOSClient, localPath, remotePath string, maxRetries int, onProgress func(delta int64), onReset func()) error {
+func (p *WorkerPool) uploadFileWithRetry(ctx context.Context, client service.Destination, localPath, remotePath string, maxRetries int, onProgress func(delta int64), onReset func()) error {
 	var err error
 	for i := 0; i < maxRetries; i++ {
 		// Check if context is cancelled before retry
@@ -451,14 +864,18 @@ func (p *WorkerPool) uploadFileWithRetry(ctx context.Context, client *service.Zi
 		}
 
 		// If it's a cancellation error, return immediately without retry
-		if err == context.Canceled || err == context.DeadlineExceeded {
+		if err == context.Canceled || err == context.DeadlineExceeded || ctx.Err() != nil {
 			return err
 		}
 
 		if i < maxRetries-1 {
 			backoff := time.Duration(math.Pow(2, float64(i))) * time.Second
 			common.Warnf("Upload failed (attempt %d/%d), retrying in %v: %v", i+1, maxRetries, backoff, err)
-			time.Sleep(backoff)
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("upload cancelled: %w", ctx.Err())
+			case <-time.After(backoff):
+			}
 		}
 	}
 
@@ -473,6 +890,7 @@ func (p *WorkerPool) failTask(task *models.MigrationTask, err error) error {
 	if updateErr := p.migrationSvc.UpdateTask(task); updateErr != nil {
 		common.Errorf("Failed to update failed task: %v", updateErr)
 	}
+	p.audit("task.failed", task, err)
 
 	status := &service.TaskStatus{
 		TaskID:    task.TaskID,
@@ -485,6 +903,11 @@ func (p *WorkerPool) failTask(task *models.MigrationTask, err error) error {
 	return err
 }
 
+// audit records a task state transition made by the worker
+func (p *WorkerPool) audit(action string, task *models.MigrationTask, err error) {
+	service.GetAuditService().RecordTask(service.AuditActorSystem, action, task, err)
+}
+
 func (p *WorkerPool) logError(taskID, filePath string, err error) {
 	p.logErrorWithType(taskID, filePath, err, "upload")
 }
@@ -503,20 +926,42 @@ func (p *WorkerPool) logErrorWithType(taskID, filePath string, err error, errorT
 }
 
 // verifyFiles verifies all uploaded files for integrity
-func (p *WorkerPool) verifyFiles(task *models.MigrationTask, fileList []FileInfo, client *service.ZimaOSClient) error {
+func (p *WorkerPool) verifyFiles(ctx context.Context, task *models.MigrationTask, source service.Source, fileList []FileInfo, client service.Destination, options service.MigrationOptions) error {
 	totalFiles := len(fileList)
 	verifiedCount := 0
 	failedCount := 0
+	indexes := make(map[string]*service.BatchIndex)
 
 	for i, file := range fileList {
+		if p.draining() {
+			return errDraining
+		}
+
 		// Check if task was cancelled
 		currentTask, _ := p.migrationSvc.GetTask(task.TaskID)
 		if currentTask.Status == models.StatusCancelled {
 			return fmt.Errorf("task cancelled during verification")
 		}
 
-		// Verify single file
-		if err := p.verifySingleFile(file, client); err != nil {
+		// Verify single file; batched files may have been kept in their archive
+		err := p.verifySingleFile(ctx, source, file, client)
+		if err != nil && file.Archive != "" {
+			if archiveErr := p.verifyArchivedFile(ctx, source, file, client, indexes); archiveErr == nil {
+				err = nil
+			} else {
+				err = fmt.Errorf("%w (archive %s: %v)", err, file.Archive, archiveErr)
+			}
+		}
+		// A skipped duplicate is in the manifest; its original is verified on its own
+		if err != nil && file.DuplicateOf != "" && options.Dedupe != nil && options.Dedupe.Mode == service.DedupeSkip {
+			if _, statErr := client.GetFileInfo(file.RemotePath); errors.Is(statErr, common.ErrFileNotFound) {
+				err = nil
+			}
+		}
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
 			failedCount++
 			p.logErrorWithType(task.TaskID, file.RemotePath, fmt.Errorf("verification failed: %w", err), "verify")
 
@@ -536,6 +981,9 @@ func (p *WorkerPool) verifyFiles(task *models.MigrationTask, fileList []FileInfo
 				TotalFiles:        task.TotalFiles,
 				TransferredSize:   task.TransferredSize,
 				TotalSize:         task.TotalSize,
+				WireSize:          task.WireSize,
+				DedupedFiles:      task.DedupedFiles,
+				DedupedSize:       task.DedupedSize,
 				Progress:          100, // Upload already completed
 				VerifyingFiles:    verifiedCount,
 				VerifyFailedFiles: failedCount,
@@ -552,9 +1000,9 @@ func (p *WorkerPool) verifyFiles(task *models.MigrationTask, fileList []FileInfo
 }
 
 // verifySingleFile verifies the integrity of a single file
-func (p *WorkerPool) verifySingleFile(file FileInfo, client *service.ZimaOSClient) error {
-	// 1. Get local file info
-	localStat, err := os.Stat(file.LocalPath)
+func (p *WorkerPool) verifySingleFile(ctx context.Context, source service.Source, file FileInfo, client service.Destination) error {
+	// 1. Get source file info
+	localStat, err := source.Stat(file.LocalPath)
 	if err != nil {
 		return fmt.Errorf("failed to stat local file: %w", err)
 	}
@@ -570,10 +1018,11 @@ func (p *WorkerPool) verifySingleFile(file FileInfo, client *service.ZimaOSClien
 		return fmt.Errorf("size mismatch: local=%d, remote=%d", localStat.Size(), remoteMeta.Size)
 	}
 
-	// 4. Compare modification time (allow 1 second tolerance)
+	// 4. Compare modification time (allow 1 second tolerance). Destinations
+	// that cannot keep it report 0.
 	localModTime := localStat.ModTime().Unix()
 	timeDiff := localModTime - remoteMeta.Modified
-	if timeDiff < -1 || timeDiff > 1 {
+	if remoteMeta.Modified != 0 && (timeDiff < -1 || timeDiff > 1) {
 		return fmt.Errorf("modified time mismatch: local=%d, remote=%d", localModTime, remoteMeta.Modified)
 	}
 
@@ -584,7 +1033,7 @@ func (p *WorkerPool) verifySingleFile(file FileInfo, client *service.ZimaOSClien
 	}
 
 	// Calculate local file first 1MB MD5
-	localHash, err := p.calculateFileMD5(file.LocalPath, chunkSize)
+	localHash, err := p.calculateFileMD5(ctx, source, file.LocalPath, chunkSize)
 	if err != nil {
 		return fmt.Errorf("failed to calculate local MD5: %w", err)
 	}
@@ -606,8 +1055,8 @@ func (p *WorkerPool) verifySingleFile(file FileInfo, client *service.ZimaOSClien
 }
 
 // calculateFileMD5 calculates the MD5 hash of the first N bytes of a file
-func (p *WorkerPool) calculateFileMD5(filePath string, size int64) (string, error) {
-	file, err := os.Open(filePath)
+func (p *WorkerPool) calculateFileMD5(ctx context.Context, source service.Source, filePath string, size int64) (string, error) {
+	file, err := source.Open(ctx, filePath)
 	if err != nil {
 		return "", err
 	}
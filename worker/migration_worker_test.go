@@ -0,0 +1,195 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atopos31/stoz/config"
+	"github.com/atopos31/stoz/models"
+	"github.com/atopos31/stoz/service"
+	"github.com/atopos31/stoz/service/zimaosfake"
+)
+
+// testPool returns a pool that uploads to fake and is not running any workers
+func testPool(fake *zimaosfake.Server) *WorkerPool {
+	return &WorkerPool{
+		migrationSvc: service.GetMigrationService(),
+		stopChan:     make(chan struct{}),
+		hardCtx:      context.Background(),
+		clientFactory: func(task *models.MigrationTask) (service.Destination, error) {
+			return fake.Client(), nil
+		},
+	}
+}
+
+// testTask writes files into a source folder and stores a task for it that
+// has already been claimed, as processTask expects
+func testTask(t *testing.T, files map[string][]byte, options service.MigrationOptions) *models.MigrationTask {
+	t.Helper()
+
+	src := filepath.Join(config.AppConfig.Scan.HostPath, "volume1", t.Name())
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(src, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	folders, _ := json.Marshal([]string{src})
+	optionsJSON, _ := json.Marshal(options)
+	now := time.Now()
+	task := &models.MigrationTask{
+		TaskID:        fmt.Sprintf("%s-%d", t.Name(), now.UnixNano()),
+		Status:        models.StatusRunning,
+		SourceFolders: string(folders),
+		DestURL:       "fake",
+		DestUsername:  "admin",
+		BasePath:      "/media/ZimaOS-HD/" + t.Name(),
+		Options:       string(optionsJSON),
+		StartedAt:     &now,
+	}
+	if err := models.DB.Create(task).Error; err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+func newFake(t *testing.T) *zimaosfake.Server {
+	t.Helper()
+	fake, err := zimaosfake.New("admin", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+func randomData(size int) []byte {
+	data := make([]byte, size)
+	rand.Read(data)
+	return data
+}
+
+// finishedTask reloads a task after processTask returned
+func finishedTask(t *testing.T, taskID string) *models.MigrationTask {
+	t.Helper()
+	task, err := service.GetMigrationService().GetTask(taskID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+func TestProcessTaskReplaysUploadAfterRelogin(t *testing.T) {
+	fake := newFake(t)
+	data := randomData(200000)
+	task := testTask(t, map[string][]byte{"a.bin": data}, service.MigrationOptions{})
+
+	fake.InjectFault(zimaosfake.EndpointUpload, zimaosfake.Fault{Status: 401, Times: 1})
+	if err := testPool(fake).processTask(task); err != nil {
+		t.Fatalf("processTask: %v", err)
+	}
+
+	if got := finishedTask(t, task.TaskID); got.Status != models.StatusCompleted {
+		t.Fatalf("status %s (%s), want completed", got.Status, got.Error)
+	}
+	if n := fake.Requests(zimaosfake.EndpointLogin); n != 2 {
+		t.Errorf("%d logins, want the initial one and one after the 401", n)
+	}
+	if n := fake.Requests(zimaosfake.EndpointUpload); n != 2 {
+		t.Errorf("%d upload requests, want the rejected one and its replay", n)
+	}
+	stored, err := fake.ReadFile(task.BasePath + "/" + t.Name() + "/a.bin")
+	if err != nil || !bytes.Equal(stored, data) {
+		t.Errorf("stored file differs from the source (%d of %d bytes): %v", len(stored), len(data), err)
+	}
+}
+
+func TestProcessTaskFailsAfterMaxRetries(t *testing.T) {
+	maxRetries := config.AppConfig.Worker.MaxRetries
+	config.AppConfig.Worker.MaxRetries = 2
+	t.Cleanup(func() { config.AppConfig.Worker.MaxRetries = maxRetries })
+
+	fake := newFake(t)
+	task := testTask(t, map[string][]byte{"a.bin": randomData(1000)}, service.MigrationOptions{})
+
+	fake.InjectFault(zimaosfake.EndpointUpload, zimaosfake.Fault{Status: 500})
+	if err := testPool(fake).processTask(task); err == nil {
+		t.Fatal("processTask succeeded while every upload failed")
+	}
+
+	got := finishedTask(t, task.TaskID)
+	if got.Status != models.StatusFailed || !strings.Contains(got.Error, "upload failed after 2 attempts") {
+		t.Errorf("status %s (%s), want failed after 2 attempts", got.Status, got.Error)
+	}
+	if n := fake.Requests(zimaosfake.EndpointUpload); n != 2 {
+		t.Errorf("%d upload requests, want MAX_RETRIES", n)
+	}
+}
+
+func TestProcessTaskVerificationCatchesTruncatedUpload(t *testing.T) {
+	if !config.AppConfig.Worker.EnableVerification {
+		t.Skip("verification is disabled")
+	}
+
+	fake := newFake(t)
+	task := testTask(t, map[string][]byte{"a.bin": randomData(200000)}, service.MigrationOptions{})
+
+	// The upload is acknowledged although only part of the file was stored
+	fake.InjectFault(zimaosfake.EndpointUpload, zimaosfake.Fault{Truncate: 1000, Times: 1})
+	if err := testPool(fake).processTask(task); err == nil {
+		t.Fatal("processTask succeeded with a truncated upload")
+	}
+
+	got := finishedTask(t, task.TaskID)
+	if got.Status != models.StatusFailed || !strings.Contains(got.Error, "verification failed") {
+		t.Errorf("status %s (%s), want failed verification", got.Status, got.Error)
+	}
+}
+
+func TestProcessTaskCancelledDuringSlowUpload(t *testing.T) {
+	fake := newFake(t)
+	data := randomData(16 << 20)
+	task := testTask(t, map[string][]byte{"a.bin": data}, service.MigrationOptions{})
+
+	// 512 chunks of 32 KiB: about 10 seconds if the upload ran to the end
+	fake.InjectFault(zimaosfake.EndpointUpload, zimaosfake.Fault{Delay: 20 * time.Millisecond})
+	done := make(chan error, 1)
+	go func() { done <- testPool(fake).processTask(task) }()
+
+	for fake.Requests(zimaosfake.EndpointUpload) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if err := service.GetMigrationService().CancelTask(task.TaskID); err != nil {
+		t.Fatal(err)
+	}
+
+	cancelled := time.Now()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("processTask: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("upload was not interrupted by the cancellation")
+	}
+	t.Logf("stopped %v after the cancellation", time.Since(cancelled))
+
+	if got := finishedTask(t, task.TaskID); got.Status != models.StatusCancelled {
+		t.Errorf("status %s, want cancelled", got.Status)
+	}
+	if stored, _ := fake.ReadFile(task.BasePath + "/" + t.Name() + "/a.bin"); len(stored) >= len(data) {
+		t.Errorf("stored %d bytes, want the upload cut short", len(stored))
+	}
+}
@@ -0,0 +1,140 @@
+package worker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/atopos31/stoz/common"
+	"github.com/atopos31/stoz/models"
+	"github.com/atopos31/stoz/service"
+)
+
+// Scheduler turns due schedules into migration tasks
+type Scheduler struct {
+	scheduleSvc  *service.ScheduleService
+	migrationSvc *service.MigrationService
+	interval     time.Duration
+	stopChan     chan struct{}
+	wg           sync.WaitGroup
+}
+
+var scheduler *Scheduler
+var schedulerOnce sync.Once
+
+func GetScheduler() *Scheduler {
+	schedulerOnce.Do(func() {
+		scheduler = &Scheduler{
+			scheduleSvc:  service.GetScheduleService(),
+			migrationSvc: service.GetMigrationService(),
+			interval:     15 * time.Second,
+			stopChan:     make(chan struct{}),
+		}
+		scheduler.Start()
+	})
+	return scheduler
+}
+
+func (s *Scheduler) Start() {
+	s.wg.Add(1)
+	go s.run()
+	common.Info("Migration scheduler started")
+}
+
+func (s *Scheduler) Stop() {
+	close(s.stopChan)
+	s.wg.Wait()
+	common.Info("Migration scheduler stopped")
+}
+
+func (s *Scheduler) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	s.tick()
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+			s.tick()
+		}
+	}
+}
+
+func (s *Scheduler) tick() {
+	now := time.Now()
+	schedules, err := s.scheduleSvc.DueSchedules(now)
+	if err != nil {
+		common.Errorf("Failed to load due schedules: %v", err)
+		return
+	}
+
+	for _, schedule := range schedules {
+		s.fire(schedule, now)
+	}
+}
+
+func (s *Scheduler) fire(schedule *models.Schedule, now time.Time) {
+	// Skip this run if the previous one is still going
+	if schedule.LastTaskID != "" {
+		if last, err := s.migrationSvc.GetTask(schedule.LastTaskID); err == nil && !models.IsTerminalStatus(last.Status) {
+			common.Warnf("Schedule %s: previous task %s is still %s, skipping run", schedule.ScheduleID, last.TaskID, last.Status)
+			schedule.LastSkippedAt = &now
+			s.audit("schedule.skipped", schedule, "previous task "+last.TaskID+" is still "+last.Status, nil)
+			if err := s.scheduleSvc.Advance(schedule, now); err != nil {
+				common.Errorf("Failed to advance schedule %s: %v", schedule.ScheduleID, err)
+			}
+			return
+		}
+	}
+
+	spec, err := s.scheduleSvc.TaskSpec(schedule)
+	if err != nil {
+		common.Errorf("Schedule %s: %v", schedule.ScheduleID, err)
+		s.audit("schedule.run", schedule, "", err)
+		if err := s.scheduleSvc.Advance(schedule, now); err != nil {
+			common.Errorf("Failed to advance schedule %s: %v", schedule.ScheduleID, err)
+		}
+		return
+	}
+
+	taskID, err := s.migrationSvc.CreateTask(spec)
+	if err != nil {
+		common.Errorf("Schedule %s: failed to create task: %v", schedule.ScheduleID, err)
+		s.audit("schedule.run", schedule, "", err)
+		if err := s.scheduleSvc.Advance(schedule, now); err != nil {
+			common.Errorf("Failed to advance schedule %s: %v", schedule.ScheduleID, err)
+		}
+		return
+	}
+
+	schedule.LastTaskID = taskID
+	schedule.LastRunAt = &now
+	if err := s.scheduleSvc.Advance(schedule, now); err != nil {
+		common.Errorf("Failed to advance schedule %s: %v", schedule.ScheduleID, err)
+	}
+
+	GetWorkerPool().SubmitTask(taskID)
+	s.audit("schedule.run", schedule, "started task "+taskID, nil)
+	common.Infof("Schedule %s started task %s", schedule.ScheduleID, taskID)
+}
+
+// audit records the outcome of a scheduled run
+func (s *Scheduler) audit(action string, schedule *models.Schedule, detail string, err error) {
+	entry := &models.AuditLog{
+		Actor:        service.AuditActorScheduler,
+		Action:       action,
+		ResourceType: "schedule",
+		ResourceID:   schedule.ScheduleID,
+		TargetID:     schedule.TargetID,
+		TargetHost:   schedule.ZimaOSHost,
+		Detail:       detail,
+	}
+	if err != nil {
+		entry.Outcome = models.AuditFailure
+		entry.Detail = err.Error()
+	}
+	service.GetAuditService().Record(entry)
+}